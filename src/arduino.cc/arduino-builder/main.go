@@ -31,18 +31,27 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
+	"runtime"
 	"strings"
 	"syscall"
+	"time"
 
 	"arduino.cc/builder"
+	"arduino.cc/builder/constants"
 	"arduino.cc/builder/gohasissues"
 	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/objectcache"
+	"arduino.cc/builder/trace"
 	"arduino.cc/builder/types"
 	"arduino.cc/builder/utils"
 	"arduino.cc/properties"
@@ -75,8 +84,29 @@ const FLAG_WARNINGS_ALL = "all"
 const FLAG_LOGGER = "logger"
 const FLAG_LOGGER_HUMAN = "human"
 const FLAG_LOGGER_MACHINE = "machine"
+const FLAG_LOGGER_JSON = "json"
 const FLAG_VERSION = "version"
 const FLAG_VID_PID = "vid-pid"
+const FLAG_JOBS = "jobs"
+const FLAG_TRACE_FILE = "trace-file"
+const FLAG_DAEMON = "daemon"
+const FLAG_DAEMON_ALLOW_REMOTE = "daemon-allow-remote"
+const FLAG_WATCH = "watch"
+const FLAG_DRY_RUN = "dry-run"
+const FLAG_COMPILE_COMMANDS = "compile-commands"
+const FLAG_EXPORT_BUILD = "export-build"
+const FLAG_FQBN_MATRIX = "fqbn-matrix"
+const FLAG_OBJECT_CACHE = "object-cache"
+const FLAG_HASH_CHECK = "hash-check"
+const FLAG_BUILD_PATH_LOCK_TIMEOUT = "build-path-lock-timeout"
+const FLAG_REPRODUCIBLE = "reproducible"
+const FLAG_VERIFY_REPRODUCIBLE = "verify-reproducible"
+const FLAG_SBOM = "sbom"
+const FLAG_LICENSE_POLICY = "license-policy"
+const FLAG_OUTPUT_DIR = "output-dir"
+const FLAG_OUTPUT_NAME = "output-name"
+
+const DAEMON_UNIX_PREFIX = "unix:"
 
 type foldersFlag []string
 
@@ -122,7 +152,7 @@ var toolsFoldersFlag foldersFlag
 var librariesBuiltInFoldersFlag foldersFlag
 var librariesFoldersFlag foldersFlag
 var customBuildPropertiesFlag propertiesFlag
-var fqbnFlag *string
+var fqbnFlag propertiesFlag
 var coreAPIVersionFlag *string
 var ideVersionFlag *string
 var buildPathFlag *string
@@ -133,6 +163,24 @@ var warningsLevelFlag *string
 var loggerFlag *string
 var versionFlag *bool
 var vidPidFlag *string
+var jobsFlag *int
+var traceFileFlag *string
+var daemonFlag *string
+var daemonAllowRemoteFlag *bool
+var watchFlag *bool
+var dryRunFlag *bool
+var compileCommandsFlag *string
+var exportBuildFlag *string
+var fqbnMatrixFlag *string
+var objectCacheFlag *string
+var hashCheckFlag *bool
+var buildPathLockTimeoutFlag *int
+var reproducibleFlag *bool
+var verifyReproducibleFlag *bool
+var sbomFlag *string
+var licensePolicyFlag *string
+var outputDirFlag *string
+var outputNameFlag *string
 
 func init() {
 	compileFlag = flag.Bool(FLAG_ACTION_COMPILE, false, "compiles the given sketch")
@@ -144,7 +192,7 @@ func init() {
 	flag.Var(&librariesBuiltInFoldersFlag, FLAG_BUILT_IN_LIBRARIES, "Specify a built-in 'libraries' folder. These are low priority libraries. Can be added multiple times for specifying multiple built-in 'libraries' folders")
 	flag.Var(&librariesFoldersFlag, FLAG_LIBRARIES, "Specify a 'libraries' folder. Can be added multiple times for specifying multiple 'libraries' folders")
 	flag.Var(&customBuildPropertiesFlag, FLAG_PREFS, "Specify a custom preference. Can be added multiple times for specifying multiple custom preferences")
-	fqbnFlag = flag.String(FLAG_FQBN, "", "fully qualified board name")
+	flag.Var(&fqbnFlag, FLAG_FQBN, "fully qualified board name. Can be added multiple times to build for several boards")
 	coreAPIVersionFlag = flag.String(FLAG_CORE_API_VERSION, "10600", "version of core APIs (used to populate ARDUINO #define)")
 	ideVersionFlag = flag.String(FLAG_IDE_VERSION, "10600", "[deprecated] use '"+FLAG_CORE_API_VERSION+"' instead")
 	buildPathFlag = flag.String(FLAG_BUILD_PATH, "", "build path")
@@ -152,9 +200,27 @@ func init() {
 	quietFlag = flag.Bool(FLAG_QUIET, false, "if 'true' doesn't print any warnings or progress or whatever")
 	debugLevelFlag = flag.Int(FLAG_DEBUG_LEVEL, builder.DEFAULT_DEBUG_LEVEL, "Turns on debugging messages. The higher, the chattier")
 	warningsLevelFlag = flag.String(FLAG_WARNINGS, "", "Sets warnings level. Available values are '"+FLAG_WARNINGS_NONE+"', '"+FLAG_WARNINGS_DEFAULT+"', '"+FLAG_WARNINGS_MORE+"' and '"+FLAG_WARNINGS_ALL+"'")
-	loggerFlag = flag.String(FLAG_LOGGER, FLAG_LOGGER_HUMAN, "Sets type of logger. Available values are '"+FLAG_LOGGER_HUMAN+"', '"+FLAG_LOGGER_MACHINE+"'")
+	loggerFlag = flag.String(FLAG_LOGGER, FLAG_LOGGER_HUMAN, "Sets type of logger. Available values are '"+FLAG_LOGGER_HUMAN+"', '"+FLAG_LOGGER_MACHINE+"', '"+FLAG_LOGGER_JSON+"'")
 	versionFlag = flag.Bool(FLAG_VERSION, false, "prints version and exits")
 	vidPidFlag = flag.String(FLAG_VID_PID, "", "specify to use vid/pid specific build properties, as defined in boards.txt")
+	jobsFlag = flag.Int(FLAG_JOBS, runtime.NumCPU(), "number of files to compile in parallel")
+	traceFileFlag = flag.String(FLAG_TRACE_FILE, "", "records the time taken by each step of the build in the given file, in Chrome trace-event format, and prints the slowest ones")
+	daemonFlag = flag.String(FLAG_DAEMON, "", "instead of building, serves builds over HTTP on the given address ('host:port' or '"+DAEMON_UNIX_PREFIX+"/path/to/socket'), keeping hardware, tools and libraries loaded. Only loopback addresses are accepted, unless '"+FLAG_DAEMON_ALLOW_REMOTE+"' is given")
+	daemonAllowRemoteFlag = flag.Bool(FLAG_DAEMON_ALLOW_REMOTE, false, "lets '"+FLAG_DAEMON+"' listen on addresses other than loopback ones. Anyone who can reach the address can then run the recipes of the platforms on this machine")
+	watchFlag = flag.Bool(FLAG_WATCH, false, "after compiling, keeps compiling again every time the sketch, the libraries it uses or the core change")
+	dryRunFlag = flag.Bool(FLAG_DRY_RUN, false, "prints the commands a build from scratch would run, by phase, without running them")
+	compileCommandsFlag = flag.String(FLAG_COMPILE_COMMANDS, "", "where to write the compilation database, by default "+constants.FILE_COMPILE_COMMANDS+" in the build path")
+	fqbnMatrixFlag = flag.String(FLAG_FQBN_MATRIX, "", "builds for each board listed in the given file, one fully qualified board name per line, optionally followed by menu options like 'cpu=atmega1280', each in its own subfolder of the build path")
+	objectCacheFlag = flag.String(FLAG_OBJECT_CACHE, "", "reuses object files and archives compiled by other builds, stored in the given folder or on the given http:// or https:// server (with GET and PUT requests)")
+	hashCheckFlag = flag.Bool(FLAG_HASH_CHECK, false, "compiles again the files whose content, or the content of the headers they include, changed, instead of the ones with a newer modification time. Useful when checking out or restoring files changes their modification times")
+	buildPathLockTimeoutFlag = flag.Int(FLAG_BUILD_PATH_LOCK_TIMEOUT, 0, "how many seconds to wait for another build using the same build path to end: 0 fails at once, -1 waits forever")
+	reproducibleFlag = flag.Bool(FLAG_REPRODUCIBLE, false, "builds the same files every time: the time properties are the ones of SOURCE_DATE_EPOCH (or of the epoch if not set), and the folders of the build, of the sketch, of the hardware, of the tools and of the libraries are replaced by fixed names in what's compiled")
+	verifyReproducibleFlag = flag.Bool(FLAG_VERIFY_REPRODUCIBLE, false, "builds reproducibly twice, in the build path and in a temporary folder, and fails if the files they produce differ")
+	sbomFlag = flag.String(FLAG_SBOM, "", "writes a software bill of materials of the build in the build path, listing the core, the variant, the libraries and the tools used with the hashes of the compiled files, as '"+builder.SBOM_SPDX+"' ({build.project_name}"+builder.SBOM_SPDX_SUFFIX+") or '"+builder.SBOM_CYCLONEDX+"' ({build.project_name}"+builder.SBOM_CYCLONEDX_SUFFIX+") JSON")
+	licensePolicyFlag = flag.String(FLAG_LICENSE_POLICY, "", "file listing the licenses the libraries used may have ('allow' and 'deny' patterns) and whether the build warns about or fails on the other libraries ('disallowed') and on the ones without a license ('unspecified'), showing the headers that made them be used")
+	outputDirFlag = flag.String(FLAG_OUTPUT_DIR, "", "copies the files the build produces, like the .elf and .hex ones, to the given folder, listing them with their sizes and SHA-256 hashes in its "+constants.FILE_OUTPUT_DIR_MANIFEST+". When building for several boards, each one gets a subfolder")
+	outputNameFlag = flag.String(FLAG_OUTPUT_NAME, builder.DEFAULT_OUTPUT_NAME, "the name of the files copied to '"+FLAG_OUTPUT_DIR+"', before their extension: {project}, {board}, {vendor}, {arch} and the build properties, like {version}, are replaced by their value")
+	exportBuildFlag = flag.String(FLAG_EXPORT_BUILD, "", "instead of compiling, preprocesses the sketch and writes the commands building it in the build path, as a '"+builder.EXPORT_BUILD_NINJA+"' ("+constants.FILE_BUILD_NINJA+") or '"+builder.EXPORT_BUILD_MAKE+"' ("+constants.FILE_MAKEFILE+") project")
 }
 
 func main() {
@@ -169,6 +235,14 @@ func main() {
 		return
 	}
 
+	if *daemonFlag != "" {
+		err := runDaemon(*daemonFlag, *daemonAllowRemoteFlag)
+		if err != nil {
+			printCompleteError(err)
+		}
+		return
+	}
+
 	ctx := &types.Context{}
 
 	if *buildOptionsFileFlag != "" {
@@ -228,10 +302,35 @@ func main() {
 	}
 
 	// FLAG_FQBN
-	if fqbn, err := gohasissues.Unquote(*fqbnFlag); err != nil {
+	fqbns, err := toSliceOfUnquoted(fqbnFlag)
+	if err != nil {
 		printCompleteError(err)
-	} else if fqbn != "" {
-		ctx.FQBN = fqbn
+	}
+
+	// FLAG_FQBN_MATRIX
+	if *fqbnMatrixFlag != "" {
+		matrixFqbns, err := builder.ReadMatrixFile(*fqbnMatrixFlag, i18n.HumanLogger{})
+		if err != nil {
+			printCompleteError(err)
+		}
+		fqbns = append(fqbns, matrixFqbns...)
+	}
+	if len(fqbns) > 0 {
+		ctx.FQBN = fqbns[0]
+	}
+	matrix := len(fqbns) > 1 || *fqbnMatrixFlag != ""
+	if matrix && *watchFlag {
+		printErrorMessageAndFlagUsage(errors.New("Parameter '" + FLAG_WATCH + "' can't be used when building for several boards"))
+	}
+	// stdin can only be read once
+	if *watchFlag && flag.Arg(0) == builder.SKETCH_ARCHIVE_STDIN {
+		printErrorMessageAndFlagUsage(errors.New("Parameter '" + FLAG_WATCH + "' can't be used with a sketch read from stdin"))
+	}
+	if *verifyReproducibleFlag && (matrix || *watchFlag) {
+		printErrorMessageAndFlagUsage(errors.New("Parameter '" + FLAG_VERIFY_REPRODUCIBLE + "' can't be used when building for several boards or with '" + FLAG_WATCH + "'"))
+	}
+	if *sbomFlag != "" && *sbomFlag != builder.SBOM_SPDX && *sbomFlag != builder.SBOM_CYCLONEDX {
+		printErrorMessageAndFlagUsage(errors.New("Parameter '" + FLAG_SBOM + "' must be '" + builder.SBOM_SPDX + "' or '" + builder.SBOM_CYCLONEDX + "'"))
 	}
 	if ctx.FQBN == "" {
 		printErrorMessageAndFlagUsage(errors.New("Parameter '" + FLAG_FQBN + "' is mandatory"))
@@ -295,25 +394,98 @@ func main() {
 		ctx.DebugLevel = *debugLevelFlag
 	}
 
+	// FLAG_JOBS
+	if *jobsFlag < 1 {
+		printErrorMessageAndFlagUsage(errors.New("Parameter '" + FLAG_JOBS + "' must be greater than zero"))
+	}
+	ctx.Jobs = *jobsFlag
+
+	// FLAG_COMPILE_COMMANDS
+	ctx.CompilationDatabasePath = *compileCommandsFlag
+
+	// FLAG_OBJECT_CACHE
+	if *objectCacheFlag != "" {
+		ctx.ObjectCache = objectcache.Open(*objectCacheFlag)
+	}
+
+	// FLAG_HASH_CHECK
+	ctx.HashCheck = *hashCheckFlag
+
+	// FLAG_BUILD_PATH_LOCK_TIMEOUT
+	ctx.BuildPathLockTimeout = time.Duration(*buildPathLockTimeoutFlag) * time.Second
+
+	// FLAG_REPRODUCIBLE
+	ctx.Reproducible = *reproducibleFlag || *verifyReproducibleFlag
+
+	// FLAG_SBOM
+	ctx.SBOMFormat = *sbomFlag
+
+	// FLAG_LICENSE_POLICY
+	ctx.LicensePolicyFile = *licensePolicyFlag
+
+	// FLAG_OUTPUT_DIR
+	outputDir, err := gohasissues.Unquote(*outputDirFlag)
+	if err != nil {
+		printCompleteError(err)
+	}
+	ctx.OutputDir = outputDir
+	ctx.OutputName = *outputNameFlag
+
+	// FLAG_DRY_RUN
+	ctx.DryRun = *dryRunFlag
+
+	// FLAG_TRACE_FILE
+	if *traceFileFlag != "" {
+		ctx.Trace = trace.New()
+	}
+
 	if *quietFlag {
 		ctx.SetLogger(i18n.NoopLogger{})
 	} else if *loggerFlag == FLAG_LOGGER_MACHINE {
 		ctx.SetLogger(i18n.MachineLogger{})
+	} else if *loggerFlag == FLAG_LOGGER_JSON {
+		ctx.SetLogger(i18n.JSONLogger{})
 	} else {
 		ctx.SetLogger(i18n.HumanLogger{})
 	}
 
+	// Stop the build, and the commands it started, on SIGINT and SIGTERM
+	cancelContext, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
 	if *dumpPrefsFlag {
-		err = builder.RunParseHardwareAndDumpBuildProperties(ctx)
+		err = builder.RunParseHardwareAndDumpBuildPropertiesWithContext(cancelContext, ctx)
 	} else if *preprocessFlag {
-		err = builder.RunPreprocess(ctx)
+		err = builder.RunPreprocessWithContext(cancelContext, ctx)
+	} else if *exportBuildFlag != "" {
+		err = builder.RunExportBuildWithContext(cancelContext, ctx, *exportBuildFlag)
 	} else {
 		if flag.NArg() == 0 {
 			fmt.Fprintln(os.Stderr, "Last parameter must be the sketch to compile")
 			flag.Usage()
 			os.Exit(1)
 		}
-		err = builder.RunBuilder(ctx)
+		if matrix {
+			err = builder.RunMatrixWithContext(cancelContext, ctx, fqbns)
+		} else if *verifyReproducibleFlag {
+			err = builder.RunVerifyReproducibleBuildWithContext(cancelContext, ctx)
+		} else if *watchFlag {
+			err = builder.RunWatch(cancelContext, ctx)
+		} else {
+			err = builder.RunBuilderWithContext(cancelContext, ctx)
+		}
+	}
+
+	if *traceFileFlag != "" {
+		builder.PrintTraceSummary(ctx)
+		if traceErr := ctx.Trace.WriteChromeTraceFile(*traceFileFlag); traceErr != nil && err == nil {
+			err = traceErr
+		}
 	}
 
 	if err != nil {
@@ -329,6 +501,44 @@ func main() {
 	}
 }
 
+func runDaemon(address string, allowRemote bool) error {
+	network := "tcp"
+	if strings.HasPrefix(address, DAEMON_UNIX_PREFIX) {
+		network = "unix"
+		address = strings.TrimPrefix(address, DAEMON_UNIX_PREFIX)
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	// Builds run the recipes of the platforms, so they are only served to
+	// this machine, unless asked otherwise
+	if tcpAddr, ok := listener.Addr().(*net.TCPAddr); ok && !tcpAddr.IP.IsLoopback() && !allowRemote {
+		listener.Close()
+		return errors.New("Parameter '" + FLAG_DAEMON + "' must be a loopback address, like 'localhost:port', unless '" + FLAG_DAEMON_ALLOW_REMOTE + "' is given")
+	}
+
+	// Closing the listener makes Serve return, and removes the unix
+	// socket if any
+	closing := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(closing)
+		listener.Close()
+	}()
+
+	fmt.Println("Listening on " + listener.Addr().String())
+	err = http.Serve(listener, builder.NewDaemon())
+	select {
+	case <-closing:
+		return nil
+	default:
+		return err
+	}
+}
+
 func toExitCode(err error) int {
 	if exiterr, ok := err.(*exec.ExitError); ok {
 		if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
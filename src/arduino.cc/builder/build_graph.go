@@ -0,0 +1,224 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package builder
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/trace"
+	"arduino.cc/builder/types"
+)
+
+// A BuildStep is a list of commands that are run in order. Inputs and
+// Outputs are names of the things the step needs and produces (e.g.
+// "core.archive"): a step is started only after all the steps that
+// produce its inputs are done, so steps that don't depend on each other
+// can run at the same time. Preprocessing steps only prepare the
+// sources to compile. Hooks are the prefixes of the hook recipes the
+// commands run: when the platform defines any of them, the steps with
+// hooks are run one after the other, in order, so that the hooks are
+// run in the same order as always.
+type BuildStep struct {
+	Name       string
+	Commands   []types.Command
+	Inputs     []string
+	Outputs    []string
+	Hooks      []string
+	Preprocess bool
+}
+
+type BuildGraph struct {
+	Steps []*BuildStep
+}
+
+type buildStepResult struct {
+	step int
+	err  error
+}
+
+// Run every step of the graph, as soon as its inputs are available. When
+// a step fails, no new steps are started: the ones already running are
+// waited for and the first error is returned. In dry runs steps are run
+// one at a time, in order, so that their commands can be told apart.
+func (g *BuildGraph) Run(ctx *types.Context, progressEnabled bool) error {
+	dependencies, err := g.dependencies(ctx)
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+
+	stepsCount := len(g.Steps)
+	started := make([]bool, stepsCount)
+	completed := make([]bool, stepsCount)
+	results := make(chan buildStepResult)
+	running := 0
+	completedCount := 0
+
+	printProgressIfProgressEnabledAndMachineLogger(progressEnabled, ctx, 0)
+
+	var mainErr error
+	for {
+		if mainErr == nil {
+			for idx, step := range g.Steps {
+				if ctx.DryRun && running > 0 {
+					break
+				}
+				if started[idx] || !allCompleted(dependencies[idx], completed) || g.waitsForHooks(ctx, idx, completed) {
+					continue
+				}
+				started[idx] = true
+				running++
+				go func(idx int, step *BuildStep) {
+					results <- buildStepResult{step: idx, err: runBuildStep(ctx, step)}
+				}(idx, step)
+			}
+		}
+
+		if running == 0 {
+			break
+		}
+
+		result := <-results
+		running--
+		if result.err != nil {
+			if mainErr == nil {
+				mainErr = result.err
+			}
+			continue
+		}
+		completed[result.step] = true
+		completedCount++
+		printProgressIfProgressEnabledAndMachineLogger(progressEnabled, ctx, float32(completedCount)*100/float32(stepsCount))
+	}
+
+	if mainErr != nil {
+		return i18n.WrapError(mainErr)
+	}
+
+	if completedCount < stepsCount {
+		var stuck []string
+		for idx, step := range g.Steps {
+			if !completed[idx] {
+				stuck = append(stuck, step.Name)
+			}
+		}
+		return i18n.ErrorfWithLogger(ctx.GetLogger(), constants.MSG_BUILD_STEPS_CYCLE, strings.Join(stuck, ", "))
+	}
+
+	return nil
+}
+
+func runBuildStep(ctx *types.Context, step *BuildStep) error {
+	logger := ctx.GetLogger()
+	i18n.LogEvent(logger, os.Stdout, constants.EVENT_PHASE_START, map[string]interface{}{"phase": step.Name})
+	start := time.Now()
+	span := ctx.Trace.Begin(trace.CATEGORY_PHASE, step.Name, nil)
+	if ctx.DryRun {
+		ctx.DryRunPhase = step.Name
+	}
+
+	err := runCommands(ctx, step.Commands, false)
+	span.End()
+
+	duration := time.Since(start)
+	ctx.AddPhaseTiming(step.Name, duration)
+
+	data := map[string]interface{}{"phase": step.Name, "duration": duration.Seconds(), "success": err == nil}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	i18n.LogEvent(logger, os.Stdout, constants.EVENT_PHASE_END, data)
+
+	return err
+}
+
+// Return, for each step, the indexes of the steps producing its inputs
+func (g *BuildGraph) dependencies(ctx *types.Context) ([][]int, error) {
+	producers := make(map[string]int)
+	for idx, step := range g.Steps {
+		for _, output := range step.Outputs {
+			if other, ok := producers[output]; ok {
+				return nil, i18n.ErrorfWithLogger(ctx.GetLogger(), constants.MSG_BUILD_STEPS_SAME_OUTPUT, g.Steps[other].Name, step.Name, output)
+			}
+			producers[output] = idx
+		}
+	}
+
+	dependencies := make([][]int, len(g.Steps))
+	for idx, step := range g.Steps {
+		for _, input := range step.Inputs {
+			producer, ok := producers[input]
+			if !ok {
+				return nil, i18n.ErrorfWithLogger(ctx.GetLogger(), constants.MSG_BUILD_STEP_INPUT_MISSING, step.Name, input)
+			}
+			dependencies[idx] = append(dependencies[idx], producer)
+		}
+	}
+
+	return dependencies, nil
+}
+
+// Whether the step has to wait for earlier steps with hooks to be done.
+// Only called once its inputs are available, so the build properties
+// are loaded and aren't changed anymore
+func (g *BuildGraph) waitsForHooks(ctx *types.Context, step int, completed []bool) bool {
+	if len(g.Steps[step].Hooks) == 0 || !g.hooksDefined(ctx) {
+		return false
+	}
+	for idx := 0; idx < step; idx++ {
+		if len(g.Steps[idx].Hooks) > 0 && !completed[idx] {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *BuildGraph) hooksDefined(ctx *types.Context) bool {
+	for _, step := range g.Steps {
+		for _, hook := range step.Hooks {
+			if len(findRecipes(ctx.BuildProperties, hook, constants.HOOKS_PATTERN_SUFFIX)) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func allCompleted(steps []int, completed []bool) bool {
+	for _, step := range steps {
+		if !completed[step] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,187 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package builder
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+	"arduino.cc/builder/utils"
+)
+
+const BUILD_PATH_LOCK_POLL_INTERVAL = 200 * time.Millisecond
+
+// Builds sharing a build path would delete or overwrite each other's
+// files: the first one creates a lock file in it, the others wait for it
+// to be removed, or fail. The lock file names the process holding it, so
+// that the lock of a process that died can be taken over
+type buildPathLockHolder struct {
+	PID      int    `json:"pid"`
+	Hostname string `json:"hostname"`
+	Command  string `json:"command"`
+}
+
+type buildPathLock struct {
+	file string
+}
+
+func (lock *buildPathLock) Close() error {
+	return os.Remove(lock.file)
+}
+
+func lockBuildPath(ctx *types.Context) error {
+	if ctx.BuildPathLock != nil {
+		return nil
+	}
+	logger := ctx.GetLogger()
+	lockFile := filepath.Join(ctx.BuildPath, constants.FILE_BUILD_PATH_LOCK)
+	hostname, _ := os.Hostname()
+	holder := &buildPathLockHolder{PID: os.Getpid(), Hostname: hostname, Command: strings.Join(os.Args, " ")}
+
+	deadline := time.Now().Add(ctx.BuildPathLockTimeout)
+	waiting := false
+	for {
+		err := createBuildPathLockFile(lockFile, holder)
+		if err == nil {
+			ctx.BuildPathLock = &buildPathLock{file: lockFile}
+			return nil
+		}
+		if !os.IsExist(err) {
+			return i18n.WrapError(err)
+		}
+
+		other, err := readBuildPathLockFile(lockFile)
+		if os.IsNotExist(err) {
+			// Released in the meantime
+			continue
+		}
+		// Otherwise the holder may still be writing it
+		if err == nil && other.Hostname == hostname && other.PID != holder.PID && !utils.ProcessExists(other.PID) {
+			logger.Println(constants.LOG_LEVEL_WARN, constants.MSG_BUILD_PATH_STALE_LOCK, strconv.Itoa(other.PID), ctx.BuildPath)
+			err = removeStaleBuildPathLockFile(lockFile, other)
+			if err != nil {
+				return i18n.WrapError(err)
+			}
+			continue
+		}
+		if other == nil {
+			other = &buildPathLockHolder{}
+		}
+
+		if ctx.BuildPathLockTimeout >= 0 && !time.Now().Before(deadline) {
+			return i18n.ErrorfWithLogger(logger, constants.MSG_BUILD_PATH_LOCKED, ctx.BuildPath, strconv.Itoa(other.PID), other.Hostname, other.Command)
+		}
+		if !waiting {
+			logger.Println(constants.LOG_LEVEL_INFO, constants.MSG_BUILD_PATH_LOCK_WAITING, strconv.Itoa(other.PID), other.Command, ctx.BuildPath)
+			waiting = true
+		}
+		select {
+		case <-ctx.CancelContext().Done():
+			return i18n.ErrorfWithLogger(logger, constants.MSG_BUILD_CANCELED)
+		case <-time.After(BUILD_PATH_LOCK_POLL_INTERVAL):
+		}
+	}
+}
+
+func unlockBuildPath(ctx *types.Context) {
+	if ctx.BuildPathLock == nil {
+		return
+	}
+	ctx.BuildPathLock.Close()
+	ctx.BuildPathLock = nil
+}
+
+// Fails with an error satisfying os.IsExist if the lock is held
+func createBuildPathLockFile(lockFile string, holder *buildPathLockHolder) error {
+	bytes, err := json.Marshal(holder)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(lockFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, os.FileMode(0644))
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(bytes)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(lockFile)
+	}
+	return err
+}
+
+// Remove the lock file if it's still the one of the given dead holder.
+// Other builds may be taking it over too: it's first moved aside, so
+// that only one of them gets it, and a lock taken by one of them in the
+// meantime is put back
+func removeStaleBuildPathLockFile(lockFile string, stale *buildPathLockHolder) error {
+	staleFile := lockFile + ".stale-" + strconv.Itoa(os.Getpid()) + "-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	err := os.Rename(lockFile, staleFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer os.Remove(staleFile)
+
+	holder, err := readBuildPathLockFile(staleFile)
+	if err == nil && *holder == *stale {
+		return nil
+	}
+	// Fails if yet another lock was taken
+	err = os.Link(staleFile, lockFile)
+	if os.IsExist(err) {
+		return nil
+	}
+	return err
+}
+
+func readBuildPathLockFile(lockFile string) (*buildPathLockHolder, error) {
+	bytes, err := ioutil.ReadFile(lockFile)
+	if err != nil {
+		return nil, err
+	}
+	var holder buildPathLockHolder
+	err = json.Unmarshal(bytes, &holder)
+	if err != nil {
+		return nil, err
+	}
+	return &holder, nil
+}
@@ -0,0 +1,287 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/objectcache"
+	"arduino.cc/builder/types"
+	"arduino.cc/builder/utils"
+)
+
+// What to compile, and how. Only SketchLocation, FQBN, HardwareFolders
+// and ToolsFolders are mandatory
+type CompileOptions struct {
+	SketchLocation          string   `json:"sketch"`
+	FQBN                    string   `json:"fqbn"`
+	HardwareFolders         []string `json:"hardware"`
+	ToolsFolders            []string `json:"tools"`
+	BuiltInLibrariesFolders []string `json:"built_in_libraries"`
+	OtherLibrariesFolders   []string `json:"libraries"`
+	CustomBuildProperties   []string `json:"prefs"`
+	// A temporary folder is used if empty
+	BuildPath string `json:"build_path"`
+	// Defaults to 10600
+	ArduinoAPIVersion string `json:"core_api_version"`
+	WarningsLevel     string `json:"warnings"`
+	USBVidPid         string `json:"vid_pid"`
+	Verbose           bool   `json:"verbose"`
+	// 0 means one per CPU
+	Jobs int `json:"jobs"`
+	// List the commands of the build in BuildResult.DryRunCommands,
+	// without running them
+	DryRun bool `json:"dry_run"`
+	// Where to write compile_commands.json, in the build path if empty
+	CompilationDatabasePath string `json:"compile_commands"`
+	// Folder or http(s) URL of the object cache, none if empty
+	ObjectCache string `json:"object_cache"`
+	// Decide which files to compile again by content instead of by
+	// modification time
+	HashCheck bool `json:"hash_check"`
+	// How many seconds to wait for another build using the build path
+	// to end: 0 fails at once, -1 waits forever
+	BuildPathLockTimeout int `json:"build_path_lock_timeout"`
+	// Build the same files every time, see SetReproducibleBuildProperties
+	Reproducible bool `json:"reproducible"`
+	// Write a software bill of materials in the build path, in the
+	// SBOM_SPDX or SBOM_CYCLONEDX format, none if empty
+	SBOM string `json:"sbom"`
+	// File with the licenses the libraries used may have, see
+	// LicensePolicy, none if empty
+	LicensePolicy string `json:"license_policy"`
+	// Where to copy the artifacts, none if empty, named after the
+	// OutputName template, DEFAULT_OUTPUT_NAME if empty
+	OutputDir  string `json:"output_dir"`
+	OutputName string `json:"output_name"`
+	// Nothing is logged if nil
+	Logger i18n.Logger `json:"-"`
+	// Runs the commands of the build, as local processes if nil
+	Executor types.Executor `json:"-"`
+	// Canceling it stops the build
+	Context context.Context `json:"-"`
+}
+
+type BuildResult struct {
+	Success   bool     `json:"success"`
+	Error     string   `json:"error,omitempty"`
+	Sketch    string   `json:"sketch"`
+	FQBN      string   `json:"fqbn"`
+	BuildPath string   `json:"build_path"`
+	Artifacts []string `json:"artifacts"`
+
+	SketchObjectFiles    []string `json:"sketch_object_files"`
+	LibrariesObjectFiles []string `json:"libraries_object_files"`
+	CoreObjectFiles      []string `json:"core_object_files"`
+	CoreArchive          string   `json:"core_archive,omitempty"`
+
+	Libraries []*LibraryResult        `json:"libraries"`
+	Size      *types.SketchSize       `json:"size,omitempty"`
+	Warnings  []types.CompilerWarning `json:"warnings"`
+
+	Phases []*PhaseResult `json:"phases"`
+	// Only set in dry runs, in the order they would be run
+	DryRunCommands []types.DryRunCommand `json:"dry_run_commands,omitempty"`
+	// In seconds
+	Duration float64 `json:"duration"`
+}
+
+type LibraryResult struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	Folder  string `json:"folder"`
+	// The include that made the library be used
+	Include string `json:"include,omitempty"`
+	// Folders of the other libraries that provide the same include
+	Alternatives []string `json:"alternatives"`
+}
+
+type PhaseResult struct {
+	Phase string `json:"phase"`
+	// In seconds
+	Duration float64 `json:"duration"`
+}
+
+// Compile a sketch, returning what the build produced. The result is
+// returned, describing what was done until then, even when the build
+// fails
+func Compile(options *CompileOptions) (*BuildResult, error) {
+	command := &Builder{}
+	err := command.Run(options.newContext())
+	return command.Result, err
+}
+
+func (options *CompileOptions) newContext() *types.Context {
+	ctx := &types.Context{
+		SketchLocation:          options.SketchLocation,
+		FQBN:                    options.FQBN,
+		HardwareFolders:         options.HardwareFolders,
+		ToolsFolders:            options.ToolsFolders,
+		BuiltInLibrariesFolders: options.BuiltInLibrariesFolders,
+		OtherLibrariesFolders:   options.OtherLibrariesFolders,
+		CustomBuildProperties:   options.CustomBuildProperties,
+		BuildPath:               options.BuildPath,
+		ArduinoAPIVersion:       options.ArduinoAPIVersion,
+		WarningsLevel:           options.WarningsLevel,
+		USBVidPid:               options.USBVidPid,
+		Verbose:                 options.Verbose,
+		Jobs:                    options.Jobs,
+		DryRun:                  options.DryRun,
+		CompilationDatabasePath: options.CompilationDatabasePath,
+		Executor:                options.Executor,
+		HashCheck:               options.HashCheck,
+		BuildPathLockTimeout:    time.Duration(options.BuildPathLockTimeout) * time.Second,
+		Reproducible:            options.Reproducible,
+		SBOMFormat:              options.SBOM,
+		LicensePolicyFile:       options.LicensePolicy,
+		OutputDir:               options.OutputDir,
+		OutputName:              options.OutputName,
+	}
+	if ctx.ArduinoAPIVersion == "" {
+		ctx.ArduinoAPIVersion = "10600"
+	}
+	if options.ObjectCache != "" {
+		ctx.ObjectCache = objectcache.Open(options.ObjectCache)
+	}
+	if options.Logger != nil {
+		ctx.SetLogger(options.Logger)
+	} else {
+		ctx.SetLogger(i18n.NoopLogger{})
+	}
+	if options.Context != nil {
+		ctx.SetCancelContext(options.Context)
+	}
+	return ctx
+}
+
+// Collect the results of a build from the context. buildErr is the
+// error the build failed with, if any
+func NewBuildResult(ctx *types.Context, buildErr error, duration time.Duration) *BuildResult {
+	result := &BuildResult{
+		Success:              buildErr == nil,
+		Sketch:               ctx.SketchLocation,
+		FQBN:                 ctx.FQBN,
+		BuildPath:            ctx.BuildPath,
+		Artifacts:            []string{},
+		SketchObjectFiles:    nonNil(ctx.SketchObjectFiles),
+		LibrariesObjectFiles: nonNil(ctx.LibrariesObjectFiles),
+		CoreObjectFiles:      nonNil(ctx.CoreObjectsFiles),
+		CoreArchive:          ctx.CoreArchiveFilePath,
+		Libraries:            []*LibraryResult{},
+		Size:                 ctx.SketchSize,
+		Warnings:             ctx.CompilerWarnings(),
+		Phases:               []*PhaseResult{},
+		DryRunCommands:       ctx.DryRunCommands(),
+		Duration:             duration.Seconds(),
+	}
+	if buildErr != nil {
+		result.Error = buildErr.Error()
+	}
+	if result.Warnings == nil {
+		result.Warnings = []types.CompilerWarning{}
+	}
+
+	// Files left by previous builds aren't artifacts of a dry run
+	if !ctx.DryRun {
+		result.Artifacts = append(result.Artifacts, projectArtifacts(ctx)...)
+	}
+
+	headers := []string{}
+	for header := range ctx.LibrariesResolutionResults {
+		headers = append(headers, header)
+	}
+	sort.Strings(headers)
+	for _, library := range ctx.ImportedLibraries {
+		libraryResult := &LibraryResult{Name: library.Name, Version: library.Version, Folder: library.Folder, Alternatives: []string{}}
+		for _, header := range headers {
+			resolution := ctx.LibrariesResolutionResults[header]
+			if resolution.Library != library {
+				continue
+			}
+			libraryResult.Include = header
+			for _, notUsed := range resolution.NotUsedLibraries {
+				libraryResult.Alternatives = append(libraryResult.Alternatives, notUsed.Folder)
+			}
+			break
+		}
+		result.Libraries = append(result.Libraries, libraryResult)
+	}
+
+	for _, timing := range ctx.PhaseTimings() {
+		result.Phases = append(result.Phases, &PhaseResult{Phase: timing.Phase, Duration: timing.Duration.Seconds()})
+	}
+
+	return result
+}
+
+// The files of the build path named after build.project_name, like the
+// .elf and .hex ones, sorted. The temporary files of interrupted builds
+// aren't artifacts
+func projectArtifacts(ctx *types.Context) []string {
+	projectName := ctx.BuildProperties[constants.BUILD_PROPERTIES_BUILD_PROJECT_NAME]
+	if ctx.BuildPath == "" || projectName == "" {
+		return nil
+	}
+	var artifacts []string
+	files, _ := filepath.Glob(filepath.Join(ctx.BuildPath, projectName+".*"))
+	for _, file := range files {
+		if strings.HasPrefix(filepath.Base(file), projectName+".tmp.") {
+			continue
+		}
+		if info, err := os.Stat(file); err == nil && !info.IsDir() {
+			artifacts = append(artifacts, file)
+		}
+	}
+	return artifacts
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+// Write the result in the build path, for tools that can't call Compile
+func WriteBuildResult(ctx *types.Context, result *BuildResult) error {
+	bytes, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+	return utils.WriteFileBytes(filepath.Join(ctx.BuildPath, constants.FILE_BUILD_RESULT), bytes)
+}
@@ -30,6 +30,7 @@
 package builder
 
 import (
+	"context"
 	"os"
 	"reflect"
 	"strconv"
@@ -38,6 +39,7 @@ import (
 	"arduino.cc/builder/constants"
 	"arduino.cc/builder/i18n"
 	"arduino.cc/builder/phases"
+	"arduino.cc/builder/trace"
 	"arduino.cc/builder/types"
 	"arduino.cc/builder/utils"
 )
@@ -66,83 +68,191 @@ const DEFAULT_WARNINGS_LEVEL = "none"
 const DEFAULT_SOFTWARE = "ARDUINO"
 const DEFAULT_BUILD_CORE = "arduino"
 
-type Builder struct{}
+type Builder struct {
+	// Set once the build is done, even if it failed
+	Result *BuildResult
+}
 
 func (s *Builder) Run(ctx *types.Context) error {
-	commands := []types.Command{
-		&GenerateBuildPathIfMissing{},
-		&EnsureBuildPathExists{},
+	start := time.Now()
+	defer unlockBuildPath(ctx)
 
-		&ContainerSetupHardwareToolsLibsSketchAndProps{},
+	mainErr := newBuilderGraph().Run(ctx, true)
 
-		&ContainerBuildOptions{},
-
-		&WarnAboutPlatformRewrites{},
-
-		&RecipeByPrefixSuffixRunner{Prefix: constants.HOOKS_PREBUILD, Suffix: constants.HOOKS_PATTERN_SUFFIX},
-
-		&ContainerMergeCopySketchFiles{},
-
-		utils.LogIfVerbose(constants.LOG_LEVEL_INFO, "Detecting libraries used..."),
-		&ContainerFindIncludes{},
-
-		&WarnAboutArchIncompatibleLibraries{},
-
-		utils.LogIfVerbose(constants.LOG_LEVEL_INFO, "Generating function prototypes..."),
-		&ContainerAddPrototypes{},
-
-		utils.LogIfVerbose(constants.LOG_LEVEL_INFO, "Compiling sketch..."),
-		&RecipeByPrefixSuffixRunner{Prefix: constants.HOOKS_SKETCH_PREBUILD, Suffix: constants.HOOKS_PATTERN_SUFFIX},
-		&phases.SketchBuilder{},
-		&RecipeByPrefixSuffixRunner{Prefix: constants.HOOKS_SKETCH_POSTBUILD, Suffix: constants.HOOKS_PATTERN_SUFFIX},
+	if ctx.DryRun {
+		ctx.DryRunPhase = "size"
+	}
+	commands := []types.Command{
+		&PrintUsedAndNotUsedLibraries{SketchError: mainErr != nil},
 
-		utils.LogIfVerbose(constants.LOG_LEVEL_INFO, "Compiling libraries..."),
-		&RecipeByPrefixSuffixRunner{Prefix: constants.HOOKS_LIBRARIES_PREBUILD, Suffix: constants.HOOKS_PATTERN_SUFFIX},
-		&UnusedCompiledLibrariesRemover{},
-		&phases.LibrariesBuilder{},
-		&RecipeByPrefixSuffixRunner{Prefix: constants.HOOKS_LIBRARIES_POSTBUILD, Suffix: constants.HOOKS_PATTERN_SUFFIX},
+		&PrintUsedLibrariesIfVerbose{},
 
-		utils.LogIfVerbose(constants.LOG_LEVEL_INFO, "Compiling core..."),
-		&RecipeByPrefixSuffixRunner{Prefix: constants.HOOKS_CORE_PREBUILD, Suffix: constants.HOOKS_PATTERN_SUFFIX},
-		&phases.CoreBuilder{},
-		&RecipeByPrefixSuffixRunner{Prefix: constants.HOOKS_CORE_POSTBUILD, Suffix: constants.HOOKS_PATTERN_SUFFIX},
+		&phases.Sizer{SketchError: mainErr != nil},
 
-		utils.LogIfVerbose(constants.LOG_LEVEL_INFO, "Linking everything together..."),
-		&RecipeByPrefixSuffixRunner{Prefix: constants.HOOKS_LINKING_PRELINK, Suffix: constants.HOOKS_PATTERN_SUFFIX},
-		&phases.Linker{},
-		&RecipeByPrefixSuffixRunner{Prefix: constants.HOOKS_LINKING_POSTLINK, Suffix: constants.HOOKS_PATTERN_SUFFIX},
+		&CompilationDatabaseSaver{SketchError: mainErr != nil},
 
-		&RecipeByPrefixSuffixRunner{Prefix: constants.HOOKS_OBJCOPY_PREOBJCOPY, Suffix: constants.HOOKS_PATTERN_SUFFIX},
-		&RecipeByPrefixSuffixRunner{Prefix: "recipe.objcopy.", Suffix: constants.HOOKS_PATTERN_SUFFIX},
-		&RecipeByPrefixSuffixRunner{Prefix: constants.HOOKS_OBJCOPY_POSTOBJCOPY, Suffix: constants.HOOKS_PATTERN_SUFFIX},
+		&SBOMSaver{SketchError: mainErr != nil},
 
-		&MergeSketchWithBootloader{},
+		&OutputDirExporter{SketchError: mainErr != nil},
 
-		&RecipeByPrefixSuffixRunner{Prefix: constants.HOOKS_POSTBUILD, Suffix: constants.HOOKS_PATTERN_SUFFIX},
+		&PrintDryRunCommands{},
 	}
+	otherErr := runCommands(ctx, commands, false)
 
-	mainErr := runCommands(ctx, commands, true)
-
-	commands = []types.Command{
-		&PrintUsedAndNotUsedLibraries{SketchError: mainErr != nil},
-
-		&PrintUsedLibrariesIfVerbose{},
-
-		&phases.Sizer{SketchError: mainErr != nil},
+	err := mainErr
+	if err == nil {
+		err = otherErr
 	}
-	otherErr := runCommands(ctx, commands, false)
 
-	if mainErr != nil {
-		return mainErr
+	s.Result = NewBuildResult(ctx, err, time.Since(start))
+	if ctx.BuildPath != "" {
+		resultErr := WriteBuildResult(ctx, s.Result)
+		if err == nil {
+			err = resultErr
+		}
 	}
 
-	return otherErr
+	return err
+}
+
+// The steps of a build. Preprocessing steps prepare the sources to
+// compile, the others compile and link them
+func newBuilderGraph() *BuildGraph {
+	return &BuildGraph{Steps: []*BuildStep{
+		{
+			Name: "setup",
+			Commands: []types.Command{
+				&GenerateBuildPathIfMissing{},
+				&EnsureBuildPathExists{},
+
+				&ContainerSetupHardwareToolsLibsSketchAndProps{},
+
+				&ContainerBuildOptions{},
+
+				&WarnAboutPlatformRewrites{},
+
+				&RecipeByPrefixSuffixRunner{Prefix: constants.HOOKS_PREBUILD, Suffix: constants.HOOKS_PATTERN_SUFFIX},
+			},
+			Outputs:    []string{"build.properties"},
+			Preprocess: true,
+		},
+		{
+			Name: "sketch.merge",
+			Commands: []types.Command{
+				&ContainerMergeCopySketchFiles{},
+			},
+			Inputs:     []string{"build.properties"},
+			Outputs:    []string{"sketch.sources"},
+			Preprocess: true,
+		},
+		{
+			Name: "includes",
+			Commands: []types.Command{
+				utils.LogIfVerbose(constants.LOG_LEVEL_INFO, "Detecting libraries used..."),
+				&ContainerFindIncludes{},
+
+				&WarnAboutArchIncompatibleLibraries{},
+
+				&CheckLicensePolicy{},
+			},
+			Inputs:     []string{"sketch.sources"},
+			Outputs:    []string{"include.folders"},
+			Preprocess: true,
+		},
+		{
+			Name: "prototypes",
+			Commands: []types.Command{
+				utils.LogIfVerbose(constants.LOG_LEVEL_INFO, "Generating function prototypes..."),
+				&ContainerAddPrototypes{},
+			},
+			Inputs:     []string{"include.folders"},
+			Outputs:    []string{"sketch.preprocessed"},
+			Preprocess: true,
+		},
+		{
+			Name: "sketch",
+			Commands: []types.Command{
+				utils.LogIfVerbose(constants.LOG_LEVEL_INFO, "Compiling sketch..."),
+				&RecipeByPrefixSuffixRunner{Prefix: constants.HOOKS_SKETCH_PREBUILD, Suffix: constants.HOOKS_PATTERN_SUFFIX},
+				&phases.SketchBuilder{},
+				&RecipeByPrefixSuffixRunner{Prefix: constants.HOOKS_SKETCH_POSTBUILD, Suffix: constants.HOOKS_PATTERN_SUFFIX},
+			},
+			Inputs:  []string{"sketch.preprocessed", "include.folders"},
+			Outputs: []string{"sketch.objects"},
+			Hooks:   []string{constants.HOOKS_SKETCH_PREBUILD, constants.HOOKS_SKETCH_POSTBUILD},
+		},
+		{
+			Name: "libraries",
+			Commands: []types.Command{
+				utils.LogIfVerbose(constants.LOG_LEVEL_INFO, "Compiling libraries..."),
+				&RecipeByPrefixSuffixRunner{Prefix: constants.HOOKS_LIBRARIES_PREBUILD, Suffix: constants.HOOKS_PATTERN_SUFFIX},
+				&UnusedCompiledLibrariesRemover{},
+				&phases.LibrariesBuilder{},
+				&RecipeByPrefixSuffixRunner{Prefix: constants.HOOKS_LIBRARIES_POSTBUILD, Suffix: constants.HOOKS_PATTERN_SUFFIX},
+			},
+			Inputs:  []string{"include.folders"},
+			Outputs: []string{"libraries.objects"},
+			Hooks:   []string{constants.HOOKS_LIBRARIES_PREBUILD, constants.HOOKS_LIBRARIES_POSTBUILD},
+		},
+		{
+			// The core doesn't depend on the sketch nor on the
+			// libraries, so it's compiled while they're processed,
+			// unless there are hooks to run in order
+			Name: "core",
+			Commands: []types.Command{
+				utils.LogIfVerbose(constants.LOG_LEVEL_INFO, "Compiling core..."),
+				&RecipeByPrefixSuffixRunner{Prefix: constants.HOOKS_CORE_PREBUILD, Suffix: constants.HOOKS_PATTERN_SUFFIX},
+				&phases.CoreBuilder{},
+				&RecipeByPrefixSuffixRunner{Prefix: constants.HOOKS_CORE_POSTBUILD, Suffix: constants.HOOKS_PATTERN_SUFFIX},
+			},
+			Inputs:  []string{"build.properties"},
+			Outputs: []string{"core.archive"},
+			Hooks:   []string{constants.HOOKS_CORE_PREBUILD, constants.HOOKS_CORE_POSTBUILD},
+		},
+		{
+			Name: "link",
+			Commands: []types.Command{
+				utils.LogIfVerbose(constants.LOG_LEVEL_INFO, "Linking everything together..."),
+				&RecipeByPrefixSuffixRunner{Prefix: constants.HOOKS_LINKING_PRELINK, Suffix: constants.HOOKS_PATTERN_SUFFIX},
+				&phases.Linker{},
+				&RecipeByPrefixSuffixRunner{Prefix: constants.HOOKS_LINKING_POSTLINK, Suffix: constants.HOOKS_PATTERN_SUFFIX},
+			},
+			Inputs:  []string{"sketch.objects", "libraries.objects", "core.archive"},
+			Outputs: []string{"elf"},
+		},
+		{
+			Name: "objcopy",
+			Commands: []types.Command{
+				&RecipeByPrefixSuffixRunner{Prefix: constants.HOOKS_OBJCOPY_PREOBJCOPY, Suffix: constants.HOOKS_PATTERN_SUFFIX},
+				&RecipeByPrefixSuffixRunner{Prefix: "recipe.objcopy.", Suffix: constants.HOOKS_PATTERN_SUFFIX, ProjectInputs: []string{".elf"}},
+				&RecipeByPrefixSuffixRunner{Prefix: constants.HOOKS_OBJCOPY_POSTOBJCOPY, Suffix: constants.HOOKS_PATTERN_SUFFIX},
+
+				&MergeSketchWithBootloader{},
+			},
+			Inputs:  []string{"elf"},
+			Outputs: []string{"hex"},
+		},
+		{
+			Name: "postbuild",
+			Commands: []types.Command{
+				&RecipeByPrefixSuffixRunner{Prefix: constants.HOOKS_POSTBUILD, Suffix: constants.HOOKS_PATTERN_SUFFIX},
+			},
+			Inputs: []string{"hex"},
+		},
+	}}
 }
 
 type Preprocess struct{}
 
 func (s *Preprocess) Run(ctx *types.Context) error {
-	commands := []types.Command{
+	defer unlockBuildPath(ctx)
+	commands := append(preprocessCommands(), &PrintPreprocessedSource{})
+	return runCommands(ctx, commands, true)
+}
+
+// Preprocess the sketch, leaving the result in ctx.SourceGccMinusE
+func preprocessCommands() []types.Command {
+	return []types.Command{
 		&GenerateBuildPathIfMissing{},
 		&EnsureBuildPathExists{},
 
@@ -159,25 +269,23 @@ func (s *Preprocess) Run(ctx *types.Context) error {
 		&WarnAboutArchIncompatibleLibraries{},
 
 		&ContainerAddPrototypes{},
-
-		&PrintPreprocessedSource{},
 	}
-
-	return runCommands(ctx, commands, true)
 }
 
 type ParseHardwareAndDumpBuildProperties struct{}
 
 func (s *ParseHardwareAndDumpBuildProperties) Run(ctx *types.Context) error {
-	commands := []types.Command{
+	commands := append(parseHardwareCommands(), &DumpBuildProperties{})
+	return runCommands(ctx, commands, true)
+}
+
+// Load everything needed to compute ctx.BuildProperties
+func parseHardwareCommands() []types.Command {
+	return []types.Command{
 		&GenerateBuildPathIfMissing{},
 
 		&ContainerSetupHardwareToolsLibsSketchAndProps{},
-
-		&DumpBuildProperties{},
 	}
-
-	return runCommands(ctx, commands, true)
 }
 
 func runCommands(ctx *types.Context, commands []types.Command, progressEnabled bool) error {
@@ -186,9 +294,11 @@ func runCommands(ctx *types.Context, commands []types.Command, progressEnabled b
 
 	progress := float32(0)
 	for _, command := range commands {
-		PrintRingNameIfDebug(ctx, command)
+		if ctx.CancelContext().Err() != nil {
+			return i18n.ErrorfWithLogger(ctx.GetLogger(), constants.MSG_BUILD_CANCELED)
+		}
 		printProgressIfProgressEnabledAndMachineLogger(progressEnabled, ctx, progress)
-		err := command.Run(ctx)
+		err := runCommand(ctx, command)
 		if err != nil {
 			return i18n.WrapError(err)
 		}
@@ -209,25 +319,68 @@ func printProgressIfProgressEnabledAndMachineLogger(progressEnabled bool, ctx *t
 	if log.Name() == "machine" {
 		log.Println(constants.LOG_LEVEL_INFO, constants.MSG_PROGRESS, strconv.FormatFloat(float64(progress), 'f', 2, 32))
 	}
+	i18n.LogEvent(log, os.Stdout, constants.EVENT_PROGRESS, map[string]interface{}{"progress": progress})
+}
+
+func runCommand(ctx *types.Context, command types.Command) error {
+	PrintRingNameIfDebug(ctx, command)
+	span := ctx.Trace.Begin(trace.CATEGORY_COMMAND, commandName(command), nil)
+	err := command.Run(ctx)
+	span.End()
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+	return nil
 }
 
 func PrintRingNameIfDebug(ctx *types.Context, command types.Command) {
 	if ctx.DebugLevel >= 10 {
-		ctx.GetLogger().Fprintln(os.Stdout, constants.LOG_LEVEL_DEBUG, constants.MSG_RUNNING_COMMAND, strconv.FormatInt(time.Now().Unix(), 10), reflect.Indirect(reflect.ValueOf(command)).Type().Name())
+		ctx.GetLogger().Fprintln(os.Stdout, constants.LOG_LEVEL_DEBUG, constants.MSG_RUNNING_COMMAND, strconv.FormatInt(time.Now().Unix(), 10), commandName(command))
 	}
 }
 
+func commandName(command types.Command) string {
+	return reflect.Indirect(reflect.ValueOf(command)).Type().Name()
+}
+
 func RunBuilder(ctx *types.Context) error {
 	command := Builder{}
 	return command.Run(ctx)
 }
 
+// Same as RunBuilder, but the build is stopped, killing any command
+// still running, as soon as cancelContext is canceled
+func RunBuilderWithContext(cancelContext context.Context, ctx *types.Context) error {
+	ctx.SetCancelContext(cancelContext)
+	return RunBuilder(ctx)
+}
+
 func RunParseHardwareAndDumpBuildProperties(ctx *types.Context) error {
 	command := ParseHardwareAndDumpBuildProperties{}
 	return command.Run(ctx)
 }
 
+func RunParseHardwareAndDumpBuildPropertiesWithContext(cancelContext context.Context, ctx *types.Context) error {
+	ctx.SetCancelContext(cancelContext)
+	return RunParseHardwareAndDumpBuildProperties(ctx)
+}
+
 func RunPreprocess(ctx *types.Context) error {
 	command := Preprocess{}
 	return command.Run(ctx)
 }
+
+func RunPreprocessWithContext(cancelContext context.Context, ctx *types.Context) error {
+	ctx.SetCancelContext(cancelContext)
+	return RunPreprocess(ctx)
+}
+
+func RunExportBuild(ctx *types.Context, format string) error {
+	command := ExportBuild{Format: format}
+	return command.Run(ctx)
+}
+
+func RunExportBuildWithContext(cancelContext context.Context, ctx *types.Context, format string) error {
+	ctx.SetCancelContext(cancelContext)
+	return RunExportBuild(ctx, format)
+}
@@ -0,0 +1,63 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package builder_utils
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/utils"
+	"arduino.cc/properties"
+)
+
+// Like Ninja's command log, the command line that produced each object
+// file and archive is recorded next to it: they are built again when
+// it changes, even if their sources didn't (e.g. when the flags of the
+// platform or of a menu option change)
+
+func commandChanged(commandFile string, commandLine string) bool {
+	bytes, err := ioutil.ReadFile(commandFile)
+	return err != nil || string(bytes) != commandLine+"\n"
+}
+
+func writeCommandFile(commandFile string, commandLine string) error {
+	return utils.WriteFile(commandFile, commandLine+"\n")
+}
+
+// The recipe adding each object file to the archive, with the object
+// file left out
+func archiveCommandLine(buildProperties properties.Map, archiveFilePath string) string {
+	properties := buildProperties.Clone()
+	properties[constants.BUILD_PROPERTIES_ARCHIVE_FILE] = filepath.Base(archiveFilePath)
+	properties[constants.BUILD_PROPERTIES_ARCHIVE_FILE_PATH] = archiveFilePath
+	properties[constants.BUILD_PROPERTIES_OBJECT_FILE] = "$" + constants.BUILD_PROPERTIES_OBJECT_FILE
+	return properties.ExpandPropsInString(properties[constants.RECIPE_AR_PATTERN])
+}
@@ -0,0 +1,299 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package builder_utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/utils"
+)
+
+var errInvalidDependencyFile = errors.New("invalid dependency file")
+
+// A rule of a dependency file: targets depend on prerequisites
+type dependencyRule struct {
+	targets       []string
+	prerequisites []string
+}
+
+// Parses a dependency file, as written by gcc -MD or -MMD. Rules span
+// many lines, each one but the last ending with a backslash, and can
+// have many targets (e.g. with -MT). There can be many rules, like the
+// empty ones -MP adds for headers
+func readDependencyFile(dependencyFile string) ([]dependencyRule, error) {
+	bytes, err := ioutil.ReadFile(dependencyFile)
+	if err != nil {
+		return nil, err
+	}
+	content := strings.Replace(string(bytes), "\r\n", "\n", -1)
+	content = strings.Replace(content, "\\\n", " ", -1)
+
+	var rules []dependencyRule
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		rule, ok := parseDependencyRule(line)
+		if !ok {
+			return nil, errInvalidDependencyFile
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseDependencyRule(line string) (dependencyRule, bool) {
+	rule := dependencyRule{}
+	separated := false
+	word := ""
+	addWord := func() {
+		if word == "" {
+			return
+		}
+		word = strings.Replace(word, "$$", "$", -1)
+		if separated {
+			rule.prerequisites = append(rule.prerequisites, word)
+		} else {
+			rule.targets = append(rule.targets, word)
+		}
+		word = ""
+	}
+
+	chars := []rune(line)
+	for i := 0; i < len(chars); i++ {
+		char := chars[i]
+		switch {
+		case char == '\\' && i+1 < len(chars) && strings.ContainsRune(" \t#", chars[i+1]):
+			// Other backslashes are part of Windows paths
+			i++
+			word += string(chars[i])
+		case char == ' ' || char == '\t':
+			addWord()
+		case char == ':' && !separated && (i+1 == len(chars) || chars[i+1] == ' ' || chars[i+1] == '\t'):
+			// Unlike the colon of drive letters in Windows paths
+			addWord()
+			separated = true
+		default:
+			word += string(char)
+		}
+	}
+	addWord()
+
+	return rule, separated && len(rule.targets) > 0
+}
+
+// Returns the prerequisites of target, and whether there's any rule for
+// it
+func dependenciesOf(rules []dependencyRule, target string) ([]string, bool) {
+	target = filepath.Clean(target)
+	var dependencies []string
+	found := false
+	for _, rule := range rules {
+		for _, ruleTarget := range rule.targets {
+			if filepath.Clean(ruleTarget) == target {
+				found = true
+				dependencies = append(dependencies, rule.prerequisites...)
+				break
+			}
+		}
+	}
+	return dependencies, found
+}
+
+// Write a dependency file for objectFile that the build and other tools
+// understand
+func writeDependencyFile(dependencyFile string, objectFile string, dependencies []string) error {
+	rows := []string{escapeDependency(objectFile) + ":"}
+	for _, dependency := range dependencies {
+		rows = append(rows, " "+escapeDependency(dependency))
+	}
+	return utils.WriteFile(dependencyFile, strings.Join(rows, " \\\n")+"\n")
+}
+
+func escapeDependency(path string) string {
+	path = strings.Replace(path, "$", "$$", -1)
+	path = strings.Replace(path, "#", "\\#", -1)
+	return strings.Replace(path, " ", "\\ ", -1)
+}
+
+// The content of a source or of a dependency of an object file when it
+// was compiled. Its size and modification time at the moment it was
+// hashed tell if it needs to be hashed again
+type fileHash struct {
+	path    string
+	size    int64
+	modTime int64
+	hash    string
+}
+
+// Same as ObjFileIsUpToDate, but the object file is up to date as long
+// as the content of its source and dependencies is the same it was when
+// it was compiled, as recorded in hashFile by writeHashFile. Files are
+// only hashed again if their size or modification time changed
+func ObjFileIsUpToDateByHash(sourceFile, objectFile, hashFile string) (bool, error) {
+	sourceFile = filepath.Clean(sourceFile)
+
+	_, err := os.Stat(objectFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, i18n.WrapError(err)
+	}
+
+	hashes, err := readHashFile(hashFile)
+	if err != nil {
+		// Missing, or written by something else
+		return false, nil
+	}
+
+	hasSource := false
+	changed := false
+	for _, hash := range hashes {
+		hasSource = hasSource || hash.path == sourceFile
+		info, err := os.Stat(hash.path)
+		if err != nil {
+			return false, nil
+		}
+		if info.Size() == hash.size && info.ModTime().UnixNano() == hash.modTime {
+			continue
+		}
+		content, err := hashFileContent(hash.path)
+		if err != nil || content != hash.hash {
+			return false, nil
+		}
+		hash.size = info.Size()
+		hash.modTime = info.ModTime().UnixNano()
+		changed = true
+	}
+	if !hasSource {
+		return false, nil
+	}
+
+	// Touched but not changed: spare hashing them next time
+	if changed {
+		if err := writeHashes(hashFile, hashes); err != nil {
+			return false, i18n.WrapError(err)
+		}
+	}
+	return true, nil
+}
+
+// Record the content of the source and dependencies of the object file
+// just compiled, for ObjFileIsUpToDateByHash. Nothing is recorded if the
+// dependencies aren't known
+func writeHashFile(hashFile, sourceFile, objectFile, dependencyFile string) error {
+	os.Remove(hashFile)
+
+	rules, err := readDependencyFile(dependencyFile)
+	if os.IsNotExist(err) || err == errInvalidDependencyFile {
+		return nil
+	}
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+	dependencies, found := dependenciesOf(rules, objectFile)
+	if !found {
+		return nil
+	}
+
+	files := utils.AppendIfNotPresent([]string{filepath.Clean(sourceFile)}, utils.Map(dependencies, filepath.Clean)...)
+	var hashes []*fileHash
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return i18n.WrapError(err)
+		}
+		content, err := hashFileContent(file)
+		if err != nil {
+			return i18n.WrapError(err)
+		}
+		hashes = append(hashes, &fileHash{path: file, size: info.Size(), modTime: info.ModTime().UnixNano(), hash: content})
+	}
+	return i18n.WrapError(writeHashes(hashFile, hashes))
+}
+
+// One line per file: hash, size, modification time and path
+func writeHashes(hashFile string, hashes []*fileHash) error {
+	var rows []string
+	for _, hash := range hashes {
+		rows = append(rows, strings.Join([]string{hash.hash, strconv.FormatInt(hash.size, 10), strconv.FormatInt(hash.modTime, 10), hash.path}, " "))
+	}
+	return utils.WriteFile(hashFile, strings.Join(rows, "\n")+"\n")
+}
+
+func readHashFile(hashFile string) ([]*fileHash, error) {
+	rows, err := utils.ReadFileToRows(hashFile)
+	if err != nil {
+		return nil, err
+	}
+	var hashes []*fileHash
+	for _, row := range utils.Filter(rows, nonEmptyString) {
+		fields := strings.SplitN(row, " ", 4)
+		if len(fields) != 4 {
+			return nil, os.ErrInvalid
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		modTime, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, &fileHash{hash: fields[0], size: size, modTime: modTime, path: fields[3]})
+	}
+	return hashes, nil
+}
+
+func hashFileContent(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
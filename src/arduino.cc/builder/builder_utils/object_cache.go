@@ -0,0 +1,217 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package builder_utils
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/objectcache"
+	"arduino.cc/builder/trace"
+	"arduino.cc/builder/types"
+	"arduino.cc/builder/utils"
+	"arduino.cc/properties"
+)
+
+// Object files are stored in the object cache under a key computed from
+// the compile command line, the source and the dependencies listed in
+// the .d file gcc writes. Dependencies are only known after compiling,
+// so the list of the last compilation of the same command line and
+// source is stored as well, under a key not depending on them
+
+// Copy the object file compiled with command from the object cache, if
+// there. Returns whether it was
+func restoreCachedObject(ctx *types.Context, command *types.CompileCommand, dependencyFile string) (bool, error) {
+	cache := ctx.ObjectCache
+	baseKey, err := compileCacheKey(command, dependencyFile)
+	if err != nil {
+		return false, i18n.WrapError(err)
+	}
+
+	list, err := cache.Get(dependenciesCacheKey(baseKey))
+	if err != nil || list == nil {
+		return false, i18n.WrapError(err)
+	}
+	dependencies := utils.Filter(strings.Split(string(list), "\n"), nonEmptyString)
+
+	key, err := objectCacheKey(baseKey, dependencies)
+	if err != nil {
+		// Some dependency is gone: not the same compilation
+		return false, nil
+	}
+	object, err := cache.Get(key)
+	if err != nil || object == nil {
+		return false, i18n.WrapError(err)
+	}
+
+	err = utils.WriteFileBytes(command.Output, object)
+	if err == nil {
+		err = writeDependencyFile(dependencyFile, command.Output, dependencies)
+	}
+	if err != nil {
+		os.Remove(command.Output)
+		os.Remove(dependencyFile)
+		return false, i18n.WrapError(err)
+	}
+	return true, nil
+}
+
+// Store the object file just compiled with command in the object cache
+func storeCachedObject(ctx *types.Context, command *types.CompileCommand, dependencyFile string) error {
+	rules, err := readDependencyFile(dependencyFile)
+	if os.IsNotExist(err) {
+		// Without it, which headers were used isn't known
+		return nil
+	}
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+	dependencies, found := dependenciesOf(rules, command.Output)
+	if !found {
+		return i18n.ErrorfWithLogger(ctx.GetLogger(), constants.MSG_INVALID_DEPENDENCY_FILE, dependencyFile)
+	}
+
+	baseKey, err := compileCacheKey(command, dependencyFile)
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+	key, err := objectCacheKey(baseKey, dependencies)
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+	object, err := ioutil.ReadFile(command.Output)
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+
+	cache := ctx.ObjectCache
+	if err := cache.Put(key, object); err != nil {
+		return i18n.WrapError(err)
+	}
+	return i18n.WrapError(cache.Put(dependenciesCacheKey(baseKey), []byte(strings.Join(dependencies, "\n"))))
+}
+
+// The command line, with the paths of the files it writes left out so
+// that compilations in other build paths get the same key, and the
+// source
+func compileCacheKey(command *types.CompileCommand, dependencyFile string) (string, error) {
+	hash := objectcache.NewKeyHash()
+	hash.WriteString("compile")
+	for _, arg := range command.Arguments {
+		arg = strings.Replace(arg, command.Output, "{"+constants.BUILD_PROPERTIES_OBJECT_FILE+"}", -1)
+		arg = strings.Replace(arg, dependencyFile, "{dependency_file}", -1)
+		hash.WriteString(arg)
+	}
+	if err := hash.WriteFile(command.File); err != nil {
+		return "", err
+	}
+	return hash.Key(), nil
+}
+
+func dependenciesCacheKey(baseKey string) string {
+	hash := objectcache.NewKeyHash()
+	hash.WriteString("dependencies")
+	hash.WriteString(baseKey)
+	return hash.Key()
+}
+
+func objectCacheKey(baseKey string, dependencies []string) (string, error) {
+	hash := objectcache.NewKeyHash()
+	hash.WriteString("object")
+	hash.WriteString(baseKey)
+	for _, dependency := range dependencies {
+		hash.WriteString(dependency)
+		if err := hash.WriteFile(dependency); err != nil {
+			return "", err
+		}
+	}
+	return hash.Key(), nil
+}
+
+// The recipe building the archive, with the paths of the archive and of
+// the object files left out, and the object files
+func archiveCacheKey(objectFiles []string, buildProperties properties.Map) (string, error) {
+	properties := buildProperties.Clone()
+	properties[constants.BUILD_PROPERTIES_ARCHIVE_FILE] = "$" + constants.BUILD_PROPERTIES_ARCHIVE_FILE
+	properties[constants.BUILD_PROPERTIES_ARCHIVE_FILE_PATH] = "$" + constants.BUILD_PROPERTIES_ARCHIVE_FILE_PATH
+	properties[constants.BUILD_PROPERTIES_OBJECT_FILE] = "$" + constants.BUILD_PROPERTIES_OBJECT_FILE
+
+	hash := objectcache.NewKeyHash()
+	hash.WriteString("archive")
+	hash.WriteString(properties.ExpandPropsInString(properties[constants.RECIPE_AR_PATTERN]))
+	for _, objectFile := range objectFiles {
+		hash.WriteString(filepath.Base(objectFile))
+		if err := hash.WriteFile(objectFile); err != nil {
+			return "", err
+		}
+	}
+	return hash.Key(), nil
+}
+
+// Copy the file stored under key in the object cache to path, if there.
+// Returns whether it was
+func restoreCachedFile(ctx *types.Context, key string, path string) (bool, error) {
+	data, err := ctx.ObjectCache.Get(key)
+	if err != nil || data == nil {
+		return false, i18n.WrapError(err)
+	}
+	if err := utils.WriteFileBytes(path, data); err != nil {
+		os.Remove(path)
+		return false, i18n.WrapError(err)
+	}
+	return true, nil
+}
+
+func storeCachedFile(ctx *types.Context, key string, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+	return i18n.WrapError(ctx.ObjectCache.Put(key, data))
+}
+
+func countObjectCacheLookup(ctx *types.Context, hit bool) {
+	if hit {
+		ctx.Trace.Count(trace.COUNTER_OBJECT_CACHE_HIT)
+	} else {
+		ctx.Trace.Count(trace.COUNTER_OBJECT_CACHE_MISS)
+	}
+}
+
+// The object cache only makes builds faster: when it can't be used,
+// files are compiled as if there was none
+func warnAboutObjectCache(ctx *types.Context, w io.Writer, err error) {
+	ctx.GetLogger().Fprintln(w, constants.LOG_LEVEL_WARN, constants.MSG_OBJECT_CACHE_ERROR, err.Error())
+}
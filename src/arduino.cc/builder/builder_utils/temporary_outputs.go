@@ -0,0 +1,201 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package builder_utils
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+	"arduino.cc/builder/utils"
+	"arduino.cc/properties"
+)
+
+// Recipes write their outputs under temporary names, that are renamed
+// into place once they succeed: an interrupted build doesn't leave
+// around partially written files that a later one would consider up to
+// date
+
+// Compiles the source file of properties to a temporary object file,
+// then renames it and its dependency file into place
+func compileToTemporaryFile(ctx *types.Context, properties properties.Map, recipe string, dependencyFile string, stdout io.Writer, stderr io.Writer) error {
+	if ctx.DryRun {
+		_, err := execRecipe(ctx, properties, recipe, false, ctx.Verbose, ctx.Verbose, stdout, stderr)
+		return i18n.WrapError(err)
+	}
+
+	objectFile := properties[constants.BUILD_PROPERTIES_OBJECT_FILE]
+	temporaryObjectFile := utils.TemporaryPath(objectFile)
+	temporaryDependencyFile := strings.TrimSuffix(temporaryObjectFile, filepath.Ext(temporaryObjectFile)) + ".d"
+	properties = properties.Clone()
+	properties[constants.BUILD_PROPERTIES_OBJECT_FILE] = temporaryObjectFile
+
+	_, err := execRecipe(ctx, properties, recipe, false, ctx.Verbose, ctx.Verbose, stdout, stderr)
+	if err == nil {
+		err = renameDependencyFile(temporaryDependencyFile, dependencyFile, temporaryObjectFile, objectFile)
+	}
+	if err == nil {
+		err = renameIfExists(temporaryObjectFile, objectFile)
+	}
+	if err != nil {
+		os.Remove(temporaryObjectFile)
+		os.Remove(temporaryDependencyFile)
+	}
+	return i18n.WrapError(err)
+}
+
+// The dependency file written along with the temporary object file,
+// named after it or where the recipe says, has it as target: it's
+// written again for the object file
+func renameDependencyFile(temporaryDependencyFile string, dependencyFile string, temporaryObjectFile string, objectFile string) error {
+	writtenDependencyFile := temporaryDependencyFile
+	if _, err := os.Stat(writtenDependencyFile); os.IsNotExist(err) {
+		writtenDependencyFile = dependencyFile
+	}
+	rules, err := readDependencyFile(writtenDependencyFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	dependencies, found := dependenciesOf(rules, temporaryObjectFile)
+	if err != nil || !found {
+		return renameIfExists(writtenDependencyFile, dependencyFile)
+	}
+	if err := writeDependencyFile(dependencyFile, objectFile, dependencies); err != nil {
+		return err
+	}
+	if writtenDependencyFile != dependencyFile {
+		return os.Remove(writtenDependencyFile)
+	}
+	return nil
+}
+
+// Recipes may write their output elsewhere, ignoring the name they're
+// given
+func renameIfExists(temporaryFile string, file string) error {
+	err := os.Rename(temporaryFile, file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Runs recipes writing files named after the project (e.g.
+// {build.path}/{build.project_name}.elf) with a temporary project name,
+// then renames the files they wrote into place. Files named after the
+// project with the given extensions, that they read, are linked under
+// the temporary name first. The renamed files are the project outputs of
+// the build
+func RunWithTemporaryProjectName(ctx *types.Context, buildProperties properties.Map, inputExtensions []string, run func(properties.Map) error) error {
+	if ctx.DryRun {
+		return run(buildProperties)
+	}
+
+	buildPath := buildProperties[constants.BUILD_PROPERTIES_BUILD_PATH]
+	projectName := buildProperties[constants.BUILD_PROPERTIES_BUILD_PROJECT_NAME]
+	temporaryProjectName := projectName + ".tmp"
+	removeTemporaryFiles(buildPath, temporaryProjectName)
+
+	for _, extension := range inputExtensions {
+		err := linkOrCopyFile(filepath.Join(buildPath, projectName+extension), filepath.Join(buildPath, temporaryProjectName+extension))
+		if err != nil && !os.IsNotExist(err) {
+			removeTemporaryFiles(buildPath, temporaryProjectName)
+			return i18n.WrapError(err)
+		}
+	}
+
+	properties := buildProperties.Clone()
+	properties[constants.BUILD_PROPERTIES_BUILD_PROJECT_NAME] = temporaryProjectName
+	err := run(properties)
+	for _, extension := range inputExtensions {
+		os.Remove(filepath.Join(buildPath, temporaryProjectName+extension))
+	}
+	if err != nil {
+		removeTemporaryFiles(buildPath, temporaryProjectName)
+		return i18n.WrapError(err)
+	}
+
+	files, err := temporaryFiles(buildPath, temporaryProjectName)
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+	for _, file := range files {
+		output := filepath.Join(buildPath, projectName+strings.TrimPrefix(file, temporaryProjectName))
+		err := os.Rename(filepath.Join(buildPath, file), output)
+		if err != nil {
+			removeTemporaryFiles(buildPath, temporaryProjectName)
+			return i18n.WrapError(err)
+		}
+		ctx.AddProjectOutput(output)
+	}
+	return nil
+}
+
+// Names of the files in buildPath named after the temporary project name
+func temporaryFiles(buildPath string, temporaryProjectName string) ([]string, error) {
+	folder, err := os.Open(buildPath)
+	if err != nil {
+		return nil, err
+	}
+	names, err := folder.Readdirnames(-1)
+	folder.Close()
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, name := range names {
+		if strings.HasPrefix(name, temporaryProjectName+".") {
+			files = append(files, name)
+		}
+	}
+	return files, nil
+}
+
+func removeTemporaryFiles(buildPath string, temporaryProjectName string) {
+	files, _ := temporaryFiles(buildPath, temporaryProjectName)
+	for _, file := range files {
+		os.Remove(filepath.Join(buildPath, file))
+	}
+}
+
+func linkOrCopyFile(source string, destination string) error {
+	if err := os.Link(source, destination); err == nil || os.IsNotExist(err) {
+		return err
+	}
+	bytes, err := ioutil.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileBytes(destination, bytes)
+}
@@ -32,60 +32,89 @@ package builder_utils
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"arduino.cc/builder/constants"
 	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/trace"
+	"arduino.cc/builder/types"
 	"arduino.cc/builder/utils"
 	"arduino.cc/properties"
 )
 
-func CompileFilesRecursive(objectFiles []string, sourcePath string, buildPath string, buildProperties properties.Map, includes []string, verbose bool, warningsLevel string, logger i18n.Logger) ([]string, error) {
-	objectFiles, err := CompileFiles(objectFiles, sourcePath, false, buildPath, buildProperties, includes, verbose, warningsLevel, logger)
+func CompileFilesRecursive(ctx *types.Context, objectFiles []string, sourcePath string, buildPath string, buildProperties properties.Map, includes []string) ([]string, error) {
+	jobs, err := collectCompileJobsRecursive(sourcePath)
 	if err != nil {
 		return nil, i18n.WrapError(err)
 	}
+	return compileFilesWithRecipe(ctx, objectFiles, sourcePath, jobs, buildPath, buildProperties, includes)
+}
 
-	folders, err := utils.ReadDirFiltered(sourcePath, utils.FilterDirs)
+func CompileFiles(ctx *types.Context, objectFiles []string, sourcePath string, recurse bool, buildPath string, buildProperties properties.Map, includes []string) ([]string, error) {
+	jobs, err := collectCompileJobs(sourcePath, recurse)
 	if err != nil {
 		return nil, i18n.WrapError(err)
 	}
+	return compileFilesWithRecipe(ctx, objectFiles, sourcePath, jobs, buildPath, buildProperties, includes)
+}
 
-	for _, folder := range folders {
-		objectFiles, err = CompileFilesRecursive(objectFiles, filepath.Join(sourcePath, folder.Name()), filepath.Join(buildPath, folder.Name()), buildProperties, includes, verbose, warningsLevel, logger)
+// File extensions that get compiled, each paired with the recipe used to
+// compile it. Files are compiled in this order.
+var COMPILE_RECIPES = [][2]string{
+	{".S", constants.RECIPE_S_PATTERN},
+	{".c", constants.RECIPE_C_PATTERN},
+	{".cpp", constants.RECIPE_CPP_PATTERN},
+}
+
+type compileJob struct {
+	source string
+	recipe string
+}
+
+func collectCompileJobs(sourcePath string, recurse bool) ([]compileJob, error) {
+	var jobs []compileJob
+	for _, extensionAndRecipe := range COMPILE_RECIPES {
+		sources, err := findFilesInFolder(sourcePath, extensionAndRecipe[0], recurse)
 		if err != nil {
 			return nil, i18n.WrapError(err)
 		}
+		for _, source := range sources {
+			jobs = append(jobs, compileJob{source: source, recipe: extensionAndRecipe[1]})
+		}
 	}
-
-	return objectFiles, nil
+	return jobs, nil
 }
 
-func CompileFiles(objectFiles []string, sourcePath string, recurse bool, buildPath string, buildProperties properties.Map, includes []string, verbose bool, warningsLevel string, logger i18n.Logger) ([]string, error) {
-	objectFiles, err := compileFilesWithExtensionWithRecipe(objectFiles, sourcePath, recurse, buildPath, buildProperties, includes, ".S", constants.RECIPE_S_PATTERN, verbose, warningsLevel, logger)
+// Same as collectCompileJobs, but the files of each folder are listed
+// before the ones of its subfolders
+func collectCompileJobsRecursive(sourcePath string) ([]compileJob, error) {
+	jobs, err := collectCompileJobs(sourcePath, false)
 	if err != nil {
 		return nil, i18n.WrapError(err)
 	}
-	objectFiles, err = compileFilesWithExtensionWithRecipe(objectFiles, sourcePath, recurse, buildPath, buildProperties, includes, ".c", constants.RECIPE_C_PATTERN, verbose, warningsLevel, logger)
-	if err != nil {
-		return nil, i18n.WrapError(err)
-	}
-	objectFiles, err = compileFilesWithExtensionWithRecipe(objectFiles, sourcePath, recurse, buildPath, buildProperties, includes, ".cpp", constants.RECIPE_CPP_PATTERN, verbose, warningsLevel, logger)
+
+	folders, err := utils.ReadDirFiltered(sourcePath, utils.FilterDirs)
 	if err != nil {
 		return nil, i18n.WrapError(err)
 	}
-	return objectFiles, nil
-}
 
-func compileFilesWithExtensionWithRecipe(objectFiles []string, sourcePath string, recurse bool, buildPath string, buildProperties properties.Map, includes []string, extension string, recipe string, verbose bool, warningsLevel string, logger i18n.Logger) ([]string, error) {
-	sources, err := findFilesInFolder(sourcePath, extension, recurse)
-	if err != nil {
-		return nil, i18n.WrapError(err)
+	for _, folder := range folders {
+		otherJobs, err := collectCompileJobsRecursive(filepath.Join(sourcePath, folder.Name()))
+		if err != nil {
+			return nil, i18n.WrapError(err)
+		}
+		jobs = append(jobs, otherJobs...)
 	}
-	return compileFilesWithRecipe(objectFiles, sourcePath, sources, buildPath, buildProperties, includes, recipe, verbose, warningsLevel, logger)
+
+	return jobs, nil
 }
 
 func findFilesInFolder(sourcePath string, extension string, recurse bool) ([]string, error) {
@@ -116,49 +145,217 @@ func findFilesInFolder(sourcePath string, extension string, recurse bool) ([]str
 	return sources, nil
 }
 
-func compileFilesWithRecipe(objectFiles []string, sourcePath string, sources []string, buildPath string, buildProperties properties.Map, includes []string, recipe string, verbose bool, warningsLevel string, logger i18n.Logger) ([]string, error) {
-	for _, source := range sources {
-		objectFile, err := compileFileWithRecipe(sourcePath, source, buildPath, buildProperties, includes, recipe, verbose, warningsLevel, logger)
-		if err != nil {
-			return nil, i18n.WrapError(err)
+type compileResult struct {
+	objectFile string
+	command    *types.CompileCommand
+	stdout     bytes.Buffer
+	stderr     bytes.Buffer
+	err        error
+	done       chan struct{}
+}
+
+// Compile the given files using up to ctx.Jobs compiler invocations at a
+// time, counting the ones of the other phases running at the same time.
+// The output of every invocation is buffered and printed in the
+// same order the files were given, and the object files are returned in
+// that order as well, so the result doesn't depend on scheduling. Once a
+// compilation fails no new ones are started, the running ones are waited
+// for and the error of the first failed file is returned.
+func compileFilesWithRecipe(ctx *types.Context, objectFiles []string, sourcePath string, jobs []compileJob, buildPath string, buildProperties properties.Map, includes []string) ([]string, error) {
+	if len(jobs) == 0 {
+		return objectFiles, nil
+	}
+
+	results := make([]*compileResult, len(jobs))
+	for i := range results {
+		results[i] = &compileResult{done: make(chan struct{})}
+	}
+
+	var failed int32
+	queue := make(chan int, len(jobs))
+	for i := range jobs {
+		queue <- i
+	}
+	close(queue)
+
+	workers := ctx.ParallelJobs()
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range queue {
+				result := results[i]
+				ctx.AcquireCompileSlot()
+				if atomic.LoadInt32(&failed) == 0 {
+					result.objectFile, result.command, result.err = compileFileWithRecipe(ctx, sourcePath, jobs[i].source, buildPath, buildProperties, includes, jobs[i].recipe, &result.stdout, &result.stderr)
+					if result.err != nil {
+						atomic.StoreInt32(&failed, 1)
+					}
+				}
+				ctx.ReleaseCompileSlot()
+				close(result.done)
+			}
+		}()
+	}
+
+	var firstErr error
+	for i, result := range results {
+		<-result.done
+		os.Stdout.Write(result.stdout.Bytes())
+		os.Stderr.Write(result.stderr.Bytes())
+		if result.err == nil && result.stderr.Len() > 0 {
+			ctx.AddCompilerWarning(types.CompilerWarning{Source: jobs[i].source, Output: result.stderr.String()})
+		}
+		if result.err != nil && firstErr == nil {
+			firstErr = result.err
 		}
+		if firstErr == nil && result.objectFile != constants.EMPTY_STRING {
+			objectFiles = append(objectFiles, result.objectFile)
+			ctx.AddCompileCommand(*result.command)
+		}
+	}
 
-		objectFiles = append(objectFiles, objectFile)
+	if firstErr != nil {
+		return nil, i18n.WrapError(firstErr)
 	}
 	return objectFiles, nil
 }
 
-func compileFileWithRecipe(sourcePath string, source string, buildPath string, buildProperties properties.Map, includes []string, recipe string, verbose bool, warningsLevel string, logger i18n.Logger) (string, error) {
+// Name the files of a sketch unpacked from an archive, in the output of
+// commands, after the archive and their path in it
+func mapSketchArchivePaths(ctx *types.Context, output []byte) []byte {
+	if ctx.SketchArchiveFolder == "" {
+		return output
+	}
+	return bytes.Replace(output, []byte(ctx.SketchArchiveFolder+string(os.PathSeparator)), []byte(ctx.SketchArchivePrefix), -1)
+}
+
+func compileFileWithRecipe(ctx *types.Context, sourcePath string, source string, buildPath string, buildProperties properties.Map, includes []string, recipe string, stdout io.Writer, stderr io.Writer) (string, *types.CompileCommand, error) {
+	logger := ctx.GetLogger()
 	properties := buildProperties.Clone()
-	properties[constants.BUILD_PROPERTIES_COMPILER_WARNING_FLAGS] = properties[constants.BUILD_PROPERTIES_COMPILER_WARNING_FLAGS+"."+warningsLevel]
+	properties[constants.BUILD_PROPERTIES_COMPILER_WARNING_FLAGS] = properties[constants.BUILD_PROPERTIES_COMPILER_WARNING_FLAGS+"."+ctx.WarningsLevel]
 	properties[constants.BUILD_PROPERTIES_INCLUDES] = strings.Join(includes, constants.SPACE)
 	properties[constants.BUILD_PROPERTIES_SOURCE_FILE] = source
 	relativeSource, err := filepath.Rel(sourcePath, source)
 	if err != nil {
-		return "", i18n.WrapError(err)
+		return "", nil, i18n.WrapError(err)
 	}
 	properties[constants.BUILD_PROPERTIES_OBJECT_FILE] = filepath.Join(buildPath, relativeSource+".o")
 
 	err = utils.EnsureFolderExists(filepath.Dir(properties[constants.BUILD_PROPERTIES_OBJECT_FILE]))
 	if err != nil {
-		return "", i18n.WrapError(err)
+		return "", nil, i18n.WrapError(err)
 	}
 
-	objIsUpToDate, err := ObjFileIsUpToDate(properties[constants.BUILD_PROPERTIES_SOURCE_FILE], properties[constants.BUILD_PROPERTIES_OBJECT_FILE], filepath.Join(buildPath, relativeSource+".d"))
+	dependencyFile := filepath.Join(buildPath, relativeSource+".d")
+	hashFile := filepath.Join(buildPath, relativeSource+".hash")
+	var objIsUpToDate bool
+	if ctx.HashCheck {
+		objIsUpToDate, err = ObjFileIsUpToDateByHash(properties[constants.BUILD_PROPERTIES_SOURCE_FILE], properties[constants.BUILD_PROPERTIES_OBJECT_FILE], hashFile)
+	} else {
+		objIsUpToDate, err = ObjFileIsUpToDate(properties[constants.BUILD_PROPERTIES_SOURCE_FILE], properties[constants.BUILD_PROPERTIES_OBJECT_FILE], dependencyFile)
+	}
 	if err != nil {
-		return "", i18n.WrapError(err)
+		return "", nil, i18n.WrapError(err)
+	}
+
+	compileCommand, err := prepareCompileCommand(properties, recipe, logger)
+	if err != nil {
+		return "", nil, i18n.WrapError(err)
 	}
 
-	if !objIsUpToDate {
-		_, err = ExecRecipe(properties, recipe, false, verbose, verbose, logger)
+	commandFile := filepath.Join(buildPath, relativeSource+".cmd")
+	commandLine := utils.CommandLine(compileCommand.Arguments)
+	// Dry runs list the commands of a build from scratch
+	objIsUpToDate = objIsUpToDate && !ctx.DryRun && !commandChanged(commandFile, commandLine)
+
+	useObjectCache := !objIsUpToDate && ctx.ObjectCache != nil && !ctx.DryRun
+	fromObjectCache := false
+	if useObjectCache {
+		fromObjectCache, err = restoreCachedObject(ctx, compileCommand, dependencyFile)
 		if err != nil {
-			return "", i18n.WrapError(err)
+			warnAboutObjectCache(ctx, stdout, err)
+		}
+		countObjectCacheLookup(ctx, fromObjectCache)
+	}
+
+	span := ctx.Trace.Begin(trace.CATEGORY_COMPILE, source, map[string]interface{}{"cached": objIsUpToDate || fromObjectCache})
+	defer span.End()
+
+	i18n.LogEvent(logger, stdout, constants.EVENT_FILE_COMPILED, map[string]interface{}{
+		"source": source,
+		"object": properties[constants.BUILD_PROPERTIES_OBJECT_FILE],
+		"cached": objIsUpToDate || fromObjectCache,
+	})
+
+	if objIsUpToDate {
+		if ctx.Verbose {
+			logger.Fprintln(stdout, constants.LOG_LEVEL_INFO, constants.MSG_USING_PREVIOUS_COMPILED_FILE, properties[constants.BUILD_PROPERTIES_OBJECT_FILE])
+		}
+		return properties[constants.BUILD_PROPERTIES_OBJECT_FILE], compileCommand, nil
+	}
+
+	if !ctx.DryRun {
+		// What was recorded before this compilation doesn't describe
+		// the new object file, even when it isn't updated
+		os.Remove(hashFile)
+		os.Remove(commandFile)
+	}
+	if fromObjectCache {
+		if ctx.Verbose {
+			logger.Fprintln(stdout, constants.LOG_LEVEL_INFO, constants.MSG_USING_OBJECT_CACHE_FILE, properties[constants.BUILD_PROPERTIES_OBJECT_FILE])
+		}
+	} else {
+		err = compileToTemporaryFile(ctx, properties, recipe, dependencyFile, stdout, stderr)
+		if err != nil {
+			return "", nil, i18n.WrapError(err)
+		}
+		if useObjectCache {
+			if err := storeCachedObject(ctx, compileCommand, dependencyFile); err != nil {
+				warnAboutObjectCache(ctx, stdout, err)
+			}
 		}
-	} else if verbose {
-		logger.Println(constants.LOG_LEVEL_INFO, constants.MSG_USING_PREVIOUS_COMPILED_FILE, properties[constants.BUILD_PROPERTIES_OBJECT_FILE])
 	}
 
-	return properties[constants.BUILD_PROPERTIES_OBJECT_FILE], nil
+	if !ctx.DryRun {
+		err = writeCommandFile(commandFile, commandLine)
+		if err != nil {
+			return "", nil, i18n.WrapError(err)
+		}
+	}
+	if ctx.HashCheck && !ctx.DryRun {
+		err = writeHashFile(hashFile, properties[constants.BUILD_PROPERTIES_SOURCE_FILE], properties[constants.BUILD_PROPERTIES_OBJECT_FILE], dependencyFile)
+		if err != nil {
+			return "", nil, i18n.WrapError(err)
+		}
+	}
+
+	return properties[constants.BUILD_PROPERTIES_OBJECT_FILE], compileCommand, nil
+}
+
+// Return the compilation database entry of the file compiled with the
+// given properties, cached or not
+func prepareCompileCommand(properties properties.Map, recipe string, logger i18n.Logger) (*types.CompileCommand, error) {
+	command, _, err := prepareCommandForRecipe(properties, recipe, false, logger)
+	if err != nil {
+		return nil, i18n.WrapError(err)
+	}
+
+	directory := command.Dir
+	if directory == constants.EMPTY_STRING {
+		directory, err = os.Getwd()
+		if err != nil {
+			return nil, i18n.WrapError(err)
+		}
+	}
+
+	return &types.CompileCommand{
+		Directory: directory,
+		File:      properties[constants.BUILD_PROPERTIES_SOURCE_FILE],
+		Arguments: command.Args,
+		Output:    properties[constants.BUILD_PROPERTIES_OBJECT_FILE],
+	}, nil
 }
 
 func ObjFileIsUpToDate(sourceFile, objectFile, dependencyFile string) (bool, error) {
@@ -196,32 +393,25 @@ func ObjFileIsUpToDate(sourceFile, objectFile, dependencyFile string) (bool, err
 		return false, nil
 	}
 
-	rows, err := utils.ReadFileToRows(dependencyFile)
+	rules, err := readDependencyFile(dependencyFile)
+	if err == errInvalidDependencyFile {
+		return false, nil
+	}
 	if err != nil {
 		return false, i18n.WrapError(err)
 	}
 
-	rows = utils.Map(rows, removeEndingBackSlash)
-	rows = utils.Map(rows, strings.TrimSpace)
-	rows = utils.Map(rows, unescapeDep)
-	rows = utils.Filter(rows, nonEmptyString)
-
-	if len(rows) == 0 {
+	if len(rules) == 0 {
 		return true, nil
 	}
 
-	firstRow := rows[0]
-	if !strings.HasSuffix(firstRow, ":") {
-		return false, nil
-	}
-	objFileInDepFile := firstRow[:len(firstRow)-1]
-	if objFileInDepFile != objectFile {
+	dependencies, found := dependenciesOf(rules, objectFile)
+	if !found {
 		return false, nil
 	}
 
-	rows = rows[1:]
-	for _, row := range rows {
-		depStat, err := os.Stat(row)
+	for _, dependency := range dependencies {
+		depStat, err := os.Stat(dependency)
 		if err != nil && !os.IsNotExist(err) {
 			// There is probably a parsing error of the dep file
 			// Ignore the error and trigger a full rebuild anyway
@@ -238,32 +428,26 @@ func ObjFileIsUpToDate(sourceFile, objectFile, dependencyFile string) (bool, err
 	return true, nil
 }
 
-func unescapeDep(s string) string {
-	s = strings.Replace(s, "\\ ", " ", -1)
-	s = strings.Replace(s, "\\\t", "\t", -1)
-	s = strings.Replace(s, "\\#", "#", -1)
-	s = strings.Replace(s, "$$", "$", -1)
-	s = strings.Replace(s, "\\\\", "\\", -1)
-	return s
-}
-
-func removeEndingBackSlash(s string) string {
-	if strings.HasSuffix(s, "\\") {
-		s = s[:len(s)-1]
-	}
-	return s
-}
-
 func nonEmptyString(s string) bool {
 	return s != constants.EMPTY_STRING
 }
 
-func ArchiveCompiledFiles(buildPath string, archiveFile string, objectFiles []string, buildProperties properties.Map, verbose bool, logger i18n.Logger) (string, error) {
+func ArchiveCompiledFiles(ctx *types.Context, buildPath string, archiveFile string, objectFiles []string, buildProperties properties.Map) (string, error) {
+	verbose := ctx.Verbose
+	logger := ctx.GetLogger()
 	archiveFilePath := filepath.Join(buildPath, archiveFile)
 
-	rebuildArchive := false
+	if ctx.Reproducible {
+		// Not in the order they were found
+		objectFiles = append([]string(nil), objectFiles...)
+		sort.Strings(objectFiles)
+	}
+
+	commandFile := archiveFilePath + ".cmd"
+	commandLine := archiveCommandLine(buildProperties, archiveFilePath)
+	rebuildArchive := commandChanged(commandFile, commandLine)
 
-	if archiveFileStat, err := os.Stat(archiveFilePath); err == nil {
+	if archiveFileStat, err := os.Stat(archiveFilePath); err == nil && !ctx.DryRun {
 
 		for _, objectFile := range objectFiles {
 			objectFileStat, _ := os.Stat(objectFile)
@@ -275,12 +459,7 @@ func ArchiveCompiledFiles(buildPath string, archiveFile string, objectFiles []st
 		}
 
 		// something changed, rebuild the core archive
-		if rebuildArchive {
-			err = os.Remove(archiveFilePath)
-			if err != nil {
-				return "", i18n.WrapError(err)
-			}
-		} else {
+		if !rebuildArchive {
 			if verbose {
 				logger.Println(constants.LOG_LEVEL_INFO, constants.MSG_USING_PREVIOUS_COMPILED_FILE, archiveFilePath)
 			}
@@ -288,14 +467,67 @@ func ArchiveCompiledFiles(buildPath string, archiveFile string, objectFiles []st
 		}
 	}
 
+	cacheKey := ""
+	if ctx.ObjectCache != nil && !ctx.DryRun {
+		var err error
+		cacheKey, err = archiveCacheKey(objectFiles, buildProperties)
+		fromObjectCache := false
+		if err == nil {
+			fromObjectCache, err = restoreCachedFile(ctx, cacheKey, archiveFilePath)
+		}
+		if err != nil {
+			warnAboutObjectCache(ctx, os.Stdout, err)
+			cacheKey = ""
+		}
+		countObjectCacheLookup(ctx, fromObjectCache)
+		if fromObjectCache {
+			if verbose {
+				logger.Println(constants.LOG_LEVEL_INFO, constants.MSG_USING_OBJECT_CACHE_FILE, archiveFilePath)
+			}
+			return archiveFilePath, i18n.WrapError(writeCommandFile(commandFile, commandLine))
+		}
+	}
+
+	if !ctx.DryRun {
+		os.Remove(commandFile)
+	}
+
+	// The archive is built from scratch under a temporary name, and
+	// replaces the previous one once complete
+	temporaryArchiveFilePath := archiveFilePath
+	if !ctx.DryRun {
+		temporaryArchiveFilePath = utils.TemporaryPath(archiveFilePath)
+		os.Remove(temporaryArchiveFilePath)
+	}
 	for _, objectFile := range objectFiles {
 		properties := buildProperties.Clone()
-		properties[constants.BUILD_PROPERTIES_ARCHIVE_FILE] = filepath.Base(archiveFilePath)
-		properties[constants.BUILD_PROPERTIES_ARCHIVE_FILE_PATH] = archiveFilePath
+		properties[constants.BUILD_PROPERTIES_ARCHIVE_FILE] = filepath.Base(temporaryArchiveFilePath)
+		properties[constants.BUILD_PROPERTIES_ARCHIVE_FILE_PATH] = temporaryArchiveFilePath
 		properties[constants.BUILD_PROPERTIES_OBJECT_FILE] = objectFile
 
-		_, err := ExecRecipe(properties, constants.RECIPE_AR_PATTERN, false, verbose, verbose, logger)
+		_, err := ExecRecipe(ctx, properties, constants.RECIPE_AR_PATTERN, false, verbose, verbose)
 		if err != nil {
+			os.Remove(temporaryArchiveFilePath)
+			return "", i18n.WrapError(err)
+		}
+	}
+	if !ctx.DryRun && len(objectFiles) == 0 {
+		os.Remove(archiveFilePath)
+	} else if !ctx.DryRun {
+		if err := os.Rename(temporaryArchiveFilePath, archiveFilePath); err != nil {
+			os.Remove(temporaryArchiveFilePath)
+			return "", i18n.WrapError(err)
+		}
+	}
+
+	if cacheKey != "" && len(objectFiles) > 0 {
+		if err := storeCachedFile(ctx, cacheKey, archiveFilePath); err != nil {
+			warnAboutObjectCache(ctx, os.Stdout, err)
+		}
+	}
+
+	if !ctx.DryRun {
+		if err := writeCommandFile(commandFile, commandLine); err != nil {
 			return "", i18n.WrapError(err)
 		}
 	}
@@ -303,31 +535,104 @@ func ArchiveCompiledFiles(buildPath string, archiveFile string, objectFiles []st
 	return archiveFilePath, nil
 }
 
-func ExecRecipe(properties properties.Map, recipe string, removeUnsetProperties bool, echoCommandLine bool, echoOutput bool, logger i18n.Logger) ([]byte, error) {
-	command, err := PrepareCommandForRecipe(properties, recipe, removeUnsetProperties, echoCommandLine, echoOutput, logger)
+func ExecRecipe(ctx *types.Context, properties properties.Map, recipe string, removeUnsetProperties bool, echoCommandLine bool, echoOutput bool) ([]byte, error) {
+	return execRecipe(ctx, properties, recipe, removeUnsetProperties, echoCommandLine, echoOutput, os.Stdout, os.Stderr)
+}
+
+func execRecipe(ctx *types.Context, properties properties.Map, recipe string, removeUnsetProperties bool, echoCommandLine bool, echoOutput bool, stdout io.Writer, stderr io.Writer) ([]byte, error) {
+	command, commandLine, err := prepareCommandForRecipe(properties, recipe, removeUnsetProperties, ctx.GetLogger())
 	if err != nil {
 		return nil, i18n.WrapError(err)
 	}
 
-	if echoOutput {
-		command.Stdout = os.Stdout
+	timeout, err := RecipeTimeout(properties, recipe)
+	if err != nil {
+		return nil, i18n.WrapError(err)
 	}
 
-	command.Stderr = os.Stderr
+	if echoCommandLine {
+		PrintCommandLine(ctx.GetLogger(), stdout, commandLine)
+	}
+
+	// Loggers supporting events get the output as an event, once the
+	// command is done, so that theirs stays parseable
+	_, outputAsEvent := ctx.GetLogger().(i18n.EventLogger)
+	output := &bytes.Buffer{}
+	if echoOutput && !outputAsEvent {
+		command.Stdout = stdout
+	} else {
+		command.Stdout = output
+	}
+
+	// Written once the command is done, to name the files of a sketch
+	// archive after it
+	errorOutput := &bytes.Buffer{}
+	if ctx.SketchArchiveFolder != "" {
+		command.Stderr = errorOutput
+	} else {
+		command.Stderr = stderr
+	}
 
+	span := ctx.Trace.Begin(recipeTraceCategory(recipe), recipe, map[string]interface{}{"command": commandLine})
+	err = utils.RunCommand(ctx, command, timeout)
+	span.End()
+	stderr.Write(mapSketchArchivePaths(ctx, errorOutput.Bytes()))
 	if echoOutput {
-		err := command.Run()
+		if outputAsEvent && output.Len() > 0 {
+			i18n.LogEvent(ctx.GetLogger(), stdout, constants.EVENT_OUTPUT, map[string]interface{}{"output": output.String()})
+		}
 		return nil, i18n.WrapError(err)
 	}
+	return output.Bytes(), i18n.WrapError(err)
+}
 
-	bytes, err := command.Output()
-	return bytes, i18n.WrapError(err)
+// Return how long the given recipe is allowed to run: recipes can set
+// their own timeout, in seconds, with a property named like the recipe
+// but ending with .timeout instead of .pattern (e.g.
+// recipe.c.combine.timeout), or share the one set with recipe.timeout.
+// Zero means no timeout.
+func RecipeTimeout(buildProperties properties.Map, recipe string) (time.Duration, error) {
+	timeout := buildProperties[strings.TrimSuffix(recipe, constants.HOOKS_PATTERN_SUFFIX)+constants.RECIPE_TIMEOUT_SUFFIX]
+	if timeout == constants.EMPTY_STRING {
+		timeout = buildProperties[constants.RECIPE_TIMEOUT]
+	}
+	if timeout == constants.EMPTY_STRING {
+		return 0, nil
+	}
+	seconds, err := strconv.Atoi(timeout)
+	if err != nil {
+		return 0, i18n.WrapError(err)
+	}
+	return time.Duration(seconds) * time.Second, nil
 }
 
 func PrepareCommandForRecipe(buildProperties properties.Map, recipe string, removeUnsetProperties bool, echoCommandLine bool, echoOutput bool, logger i18n.Logger) (*exec.Cmd, error) {
+	command, commandLine, err := prepareCommandForRecipe(buildProperties, recipe, removeUnsetProperties, logger)
+	if err != nil {
+		return nil, i18n.WrapError(err)
+	}
+
+	if echoCommandLine {
+		PrintCommandLine(logger, os.Stdout, commandLine)
+	}
+
+	return command, nil
+}
+
+// Print the command line that is about to be run; loggers supporting
+// events get it as an event, so that their output stays parseable
+func PrintCommandLine(logger i18n.Logger, w io.Writer, commandLine string) {
+	if _, ok := logger.(i18n.EventLogger); ok {
+		i18n.LogEvent(logger, w, constants.EVENT_COMMAND, map[string]interface{}{"command_line": commandLine})
+		return
+	}
+	fmt.Fprintln(w, commandLine)
+}
+
+func prepareCommandForRecipe(buildProperties properties.Map, recipe string, removeUnsetProperties bool, logger i18n.Logger) (*exec.Cmd, string, error) {
 	pattern := buildProperties[recipe]
 	if pattern == constants.EMPTY_STRING {
-		return nil, i18n.ErrorfWithLogger(logger, constants.MSG_PATTERN_MISSING, recipe)
+		return nil, "", i18n.ErrorfWithLogger(logger, constants.MSG_PATTERN_MISSING, recipe)
 	}
 
 	var err error
@@ -335,32 +640,49 @@ func PrepareCommandForRecipe(buildProperties properties.Map, recipe string, remo
 	if removeUnsetProperties {
 		commandLine, err = properties.DeleteUnexpandedPropsFromString(commandLine)
 		if err != nil {
-			return nil, i18n.WrapError(err)
+			return nil, "", i18n.WrapError(err)
 		}
 	}
 
 	command, err := utils.PrepareCommand(commandLine, logger)
 	if err != nil {
-		return nil, i18n.WrapError(err)
+		return nil, "", i18n.WrapError(err)
 	}
 
-	if echoCommandLine {
-		fmt.Println(commandLine)
-	}
-
-	return command, nil
+	return command, commandLine, nil
 }
 
-func ExecRecipeCollectStdErr(buildProperties properties.Map, recipe string, removeUnsetProperties bool, echoCommandLine bool, echoOutput bool, logger i18n.Logger) (string, error) {
-	command, err := PrepareCommandForRecipe(buildProperties, recipe, removeUnsetProperties, echoCommandLine, echoOutput, logger)
+func ExecRecipeCollectStdErr(ctx *types.Context, buildProperties properties.Map, recipe string, removeUnsetProperties bool, echoCommandLine bool, echoOutput bool) (string, error) {
+	command, err := PrepareCommandForRecipe(buildProperties, recipe, removeUnsetProperties, echoCommandLine, echoOutput, ctx.GetLogger())
+	if err != nil {
+		return "", i18n.WrapError(err)
+	}
+
+	timeout, err := RecipeTimeout(buildProperties, recipe)
 	if err != nil {
 		return "", i18n.WrapError(err)
 	}
 
 	buffer := &bytes.Buffer{}
 	command.Stderr = buffer
-	command.Run()
-	return string(buffer.Bytes()), nil
+	span := ctx.Trace.Begin(recipeTraceCategory(recipe), recipe, map[string]interface{}{"command": strings.Join(command.Args, " ")})
+	err = utils.RunCommand(ctx, command, timeout)
+	span.End()
+	// A failure is expected (that's how missing includes are found),
+	// but a killed command must stop the build
+	if utils.IsCommandKilled(err) {
+		return "", i18n.WrapError(err)
+	}
+	return string(mapSketchArchivePaths(ctx, buffer.Bytes())), nil
+}
+
+// Hooks are traced separately from the other recipes, to tell apart
+// the time spent in the platform's own additions to the build
+func recipeTraceCategory(recipe string) string {
+	if strings.HasPrefix(recipe, constants.HOOKS_PREFIX) {
+		return trace.CATEGORY_HOOK
+	}
+	return trace.CATEGORY_RECIPE
 }
 
 func RemoveHyphenMDDFlagFromGCCCommandLine(buildProperties properties.Map) {
@@ -0,0 +1,122 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package builder
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sort"
+
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+	"arduino.cc/builder/utils"
+)
+
+// Writes the compilation database (compile_commands.json) used by
+// editors and tools like clangd, at ctx.CompilationDatabasePath or in
+// the build path
+type CompilationDatabaseSaver struct {
+	SketchError bool
+}
+
+func (s *CompilationDatabaseSaver) Run(ctx *types.Context) error {
+	if s.SketchError {
+		return nil
+	}
+
+	path := ctx.CompilationDatabasePath
+	if path == constants.EMPTY_STRING {
+		path = filepath.Join(ctx.BuildPath, constants.FILE_COMPILE_COMMANDS)
+	}
+
+	bytes, err := json.MarshalIndent(CompilationDatabase(ctx), "", "  ")
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+	return utils.WriteFileBytes(path, bytes)
+}
+
+// Return the compilation database of the build, sorted by file. The
+// sketch files copied to the build path are also listed under their
+// original names, and the merged sketch under the name of each .ino file
+// it comes from, so that editors find them
+func CompilationDatabase(ctx *types.Context) []types.CompileCommand {
+	originals := make(map[string][]string)
+	if ctx.Sketch != nil {
+		sketchFolder := filepath.Dir(ctx.Sketch.MainFile.Name)
+		mergedFile := filepath.Join(ctx.SketchBuildPath, filepath.Base(ctx.Sketch.MainFile.Name)+".cpp")
+		originals[mergedFile] = append(originals[mergedFile], ctx.Sketch.MainFile.Name)
+		for _, file := range ctx.Sketch.OtherSketchFiles {
+			originals[mergedFile] = append(originals[mergedFile], file.Name)
+		}
+		for _, file := range ctx.Sketch.AdditionalFiles {
+			relativePath, err := filepath.Rel(sketchFolder, file.Name)
+			if err != nil {
+				continue
+			}
+			copiedFile := filepath.Join(ctx.SketchBuildPath, relativePath)
+			originals[copiedFile] = append(originals[copiedFile], file.Name)
+		}
+	}
+
+	commands := []types.CompileCommand{}
+	for _, command := range ctx.CompileCommands() {
+		commands = append(commands, command)
+		for _, original := range originals[command.File] {
+			commands = append(commands, compileCommandForOriginal(command, original))
+		}
+	}
+
+	sort.SliceStable(commands, func(i, j int) bool {
+		return commands[i].File < commands[j].File
+	})
+	return commands
+}
+
+// Return a copy of command compiling original in place of the file it
+// was copied or merged to. .ino files are compiled as C++, with the
+// Arduino.h include added when merging
+func compileCommandForOriginal(command types.CompileCommand, original string) types.CompileCommand {
+	var arguments []string
+	for idx, argument := range command.Arguments {
+		if argument == command.File {
+			argument = original
+		}
+		arguments = append(arguments, argument)
+		if idx == 0 && MAIN_FILE_VALID_EXTENSIONS[filepath.Ext(original)] {
+			arguments = append(arguments, "-x", "c++", "-include", "Arduino.h")
+		}
+	}
+
+	command.File = original
+	command.Arguments = arguments
+	return command
+}
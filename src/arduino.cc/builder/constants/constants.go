@@ -43,11 +43,21 @@ const BUILD_PROPERTIES_BUILD_CORE = "build.core"
 const BUILD_PROPERTIES_BUILD_CORE_PATH = "build.core.path"
 const BUILD_PROPERTIES_BUILD_MCU = "build.mcu"
 const BUILD_PROPERTIES_BUILD_PATH = "build.path"
+const BUILD_PROPERTIES_BUILD_PREFIX_MAP_FLAGS = "build.prefix_map.flags"
 const BUILD_PROPERTIES_BUILD_PROJECT_NAME = "build.project_name"
+const BUILD_PROPERTIES_BUILD_SIZE_NATIVE = "build.size.native"
+const BUILD_PROPERTIES_BUILD_SIZE_SECTIONS_DATA = "build.size.sections.data"
+const BUILD_PROPERTIES_BUILD_SIZE_SECTIONS_EEPROM = "build.size.sections.eeprom"
+const BUILD_PROPERTIES_BUILD_SIZE_SECTIONS_TEXT = "build.size.sections.text"
 const BUILD_PROPERTIES_BUILD_SYSTEM_PATH = "build.system.path"
 const BUILD_PROPERTIES_BUILD_VARIANT = "build.variant"
 const BUILD_PROPERTIES_BUILD_VARIANT_PATH = "build.variant.path"
+const BUILD_PROPERTIES_COMPILER_C_ELF_EXTRA_FLAGS = "compiler.c.elf.extra_flags"
 const BUILD_PROPERTIES_COMPILER_C_ELF_FLAGS = "compiler.c.elf.flags"
+const BUILD_PROPERTIES_COMPILER_C_EXTRA_FLAGS = "compiler.c.extra_flags"
+const BUILD_PROPERTIES_COMPILER_CPP_EXTRA_FLAGS = "compiler.cpp.extra_flags"
+const BUILD_PROPERTIES_COMPILER_PREFIX_MAP_FLAG = "compiler.prefix_map.flag"
+const BUILD_PROPERTIES_COMPILER_S_EXTRA_FLAGS = "compiler.S.extra_flags"
 const BUILD_PROPERTIES_COMPILER_CPP_FLAGS = "compiler.cpp.flags"
 const BUILD_PROPERTIES_COMPILER_PATH = "compiler.path"
 const BUILD_PROPERTIES_COMPILER_WARNING_FLAGS = "compiler.warning_flags"
@@ -74,17 +84,35 @@ const BUILD_PROPERTIES_TOOLS_KEY = "tools"
 const BUILD_PROPERTIES_VID = "vid"
 const CTAGS = "ctags"
 const EMPTY_STRING = ""
+const ENV_SOURCE_DATE_EPOCH = "SOURCE_DATE_EPOCH"
+const EVENT_COMMAND = "command"
+const EVENT_DRY_RUN_COMMAND = "dry_run.command"
+const EVENT_FILE_COMPILED = "file.compiled"
+const EVENT_LIBRARY_RESOLVED = "library.resolved"
+const EVENT_MATRIX_RESULT = "matrix.result"
+const EVENT_OUTPUT = "output"
+const EVENT_PHASE_END = "phase.end"
+const EVENT_PHASE_START = "phase.start"
+const EVENT_PROGRESS = "progress"
+const EVENT_SIZE = "size"
+const FILE_BUILD_NINJA = "build.ninja"
+const FILE_BUILD_PATH_LOCK = "build.lock"
+const FILE_BUILD_RESULT = "build_result.json"
 const FILE_BOARDS_LOCAL_TXT = "boards.local.txt"
 const FILE_BOARDS_TXT = "boards.txt"
 const FILE_BUILTIN_TOOLS_VERSIONS_TXT = "builtin_tools_versions.txt"
+const FILE_COMPILE_COMMANDS = "compile_commands.json"
 const FILE_CTAGS_TARGET = "ctags_target.cpp"
 const FILE_CTAGS_TARGET_FOR_GCC_MINUS_E = "ctags_target_for_gcc_minus_e.cpp"
 const FILE_GCC_PREPROC_TARGET = "gcc_preproc_target.cpp"
+const FILE_OUTPUT_DIR_MANIFEST = "manifest.json"
 const FILE_PLATFORM_KEYS_REWRITE_TXT = "platform.keys.rewrite.txt"
 const FILE_PLATFORM_LOCAL_TXT = "platform.local.txt"
 const FILE_PLATFORM_TXT = "platform.txt"
 const FILE_PROGRAMMERS_TXT = "programmers.txt"
 const FILE_INCLUDES_CACHE = "includes.cache"
+const FILE_MAKEFILE = "Makefile"
+const FILE_MATRIX_RESULT = "matrix_result.json"
 const FOLDER_BOOTLOADERS = "bootloaders"
 const FOLDER_CORE = "core"
 const FOLDER_CORES = "cores"
@@ -92,6 +120,7 @@ const FOLDER_HARDWARE = "hardware"
 const FOLDER_LIBRARIES = "libraries"
 const FOLDER_PREPROC = "preproc"
 const FOLDER_SKETCH = "sketch"
+const FOLDER_SKETCH_ARCHIVE = "sketch_archive"
 const FOLDER_SYSTEM = "system"
 const FOLDER_TOOLS = "tools"
 const FOLDER_VARIANTS = "variants"
@@ -113,6 +142,7 @@ const hooks_postbuild_suffix = ".postbuild"
 const hooks_postlink_suffix = ".postlink"
 const hooks_postobjcopy_suffix = ".postobjcopy"
 const HOOKS_PREBUILD = hooks + hooks_prebuild_suffix
+const HOOKS_PREFIX = hooks + "."
 const hooks_prebuild_suffix = ".prebuild"
 const hooks_prelink_suffix = ".prelink"
 const hooks_preobjcopy_suffix = ".preobjcopy"
@@ -146,29 +176,71 @@ const LOG_LEVEL_ERROR = "error"
 const LOG_LEVEL_INFO = "info"
 const LOG_LEVEL_WARN = "warn"
 const MSG_ARCH_FOLDER_NOT_SUPPORTED = "'arch' folder is no longer supported! See http://goo.gl/gfFJzU for more information"
+const MSG_BOARD_OPTIONS_CHANGED = "Board options changed ({0}), rebuilding {1}"
+const MSG_BOARD_OPTIONS_CHANGED_NOTHING_TO_REBUILD = "Board options changed ({0}), no compiled file is affected"
 const MSG_BOARD_UNKNOWN = "Board {0} (platform {1}, package {2}) is unknown"
 const MSG_BOOTLOADER_FILE_MISSING = "Bootloader file specified but missing: {0}"
+const MSG_BUILD_CANCELED = "Build canceled"
+const MSG_BUILD_EXPORTED = "Build exported to {0}"
 const MSG_BUILD_OPTIONS_CHANGED = "Build options changed, rebuilding all"
+const MSG_BUILD_PATH_LOCK_WAITING = "Waiting for process {0} ({1}) to stop using build path {2}"
+const MSG_BUILD_PATH_LOCKED = "Build path {0} is used by another build, process {1} on {2}: {3}"
+const MSG_BUILD_PATH_STALE_LOCK = "Process {0}, that locked build path {1}, is no longer running: taking over its lock"
+const MSG_BUILD_PROPERTIES_CHANGED = "Custom build properties changed, rebuilding the files they affect"
+const MSG_BUILD_STEP_INPUT_MISSING = "Build step {0} needs {1}, but no step produces it"
+const MSG_BUILD_STEPS_CYCLE = "Build steps {0} depend on each other"
+const MSG_BUILD_STEPS_SAME_OUTPUT = "Build steps {0} and {1} both produce {2}"
 const MSG_CANT_FIND_SKETCH_IN_PATH = "Unable to find {0} in {1}"
+const MSG_COMMAND_TIMEOUT = "Command didn''t complete within {0} and was stopped: {1}"
+const MSG_DRY_RUN_COMMAND = "  {0}"
+const MSG_DRY_RUN_PHASE = "{0}:"
+const MSG_EXPORTING_ARTIFACT = "Exporting {0} as {1}"
+const MSG_EXPORT_BUILD_FORMAT_UNKNOWN = "Unknown build export format {0}, use {1} or {2}"
 const MSG_FQBN_INVALID = "{0} is not a valid fully qualified board name. Required format is targetPackageName:targetPlatformName:targetBoardName."
+const MSG_INVALID_DEPENDENCY_FILE = "Invalid dependency file: {0}"
 const MSG_INVALID_QUOTING = "Invalid quoting: no closing [{0}] char found."
 const MSG_LIB_LEGACY = "(legacy)"
+const MSG_LIBRARIES_FOLDERS_CHANGED = "Libraries folders changed, rebuilding {0}"
 const MSG_LIBRARIES_MULTIPLE_LIBS_FOUND_FOR = "Multiple libraries were found for \"{0}\""
 const MSG_LIBRARIES_NOT_USED = " Not used: {0}"
 const MSG_LIBRARIES_USED = " Used: {0}"
 const MSG_LIBRARY_CAN_USE_SRC_AND_UTILITY_FOLDERS = "Library can't use both 'src' and 'utility' folders. Double check {0}"
 const MSG_LIBRARY_INCOMPATIBLE_ARCH = "WARNING: library {0} claims to run on {1} architecture(s) and may be incompatible with your current board which runs on {2} architecture(s)."
+const MSG_LICENSE_POLICY_DISALLOWED = "Library {0} ({1}), included as <{2}> by {3}, has license {4}, which the license policy doesn't allow"
+const MSG_LICENSE_POLICY_INVALID_ACTION = "{0}: invalid value {1} for {2}, use allow, warn or fail"
+const MSG_LICENSE_POLICY_UNKNOWN_KEY = "{0}: unknown key {1}, use allow, deny, disallowed or unspecified"
+const MSG_LICENSE_POLICY_UNSPECIFIED = "Library {0} ({1}), included as <{2}> by {3}, doesn't specify its license"
+const MSG_LICENSE_POLICY_VIOLATED = "{0} of the libraries used don't comply with the license policy {1}"
 const MSG_LOOKING_FOR_RECIPES = "Looking for recipes like {0}*{1}"
+const MSG_MATRIX_BOARD = "Board"
+const MSG_MATRIX_BUILD_FAILED = "Build for {0} failed: {1}"
+const MSG_MATRIX_BUILD_STARTED = "Building for {0} in {1}"
+const MSG_MATRIX_DATA_SIZE = "Data"
+const MSG_MATRIX_FAILED = "{0} of {1} builds failed"
+const MSG_MATRIX_FAILED_RESULT = "failed"
+const MSG_MATRIX_FILE_INVALID_LINE = "{0}:{1}: invalid line, an FQBN followed by menu options was expected: {2}"
+const MSG_MATRIX_PASSED = "passed"
+const MSG_MATRIX_PROGRAM_SIZE = "Program"
+const MSG_MATRIX_RESULT = "Result"
+const MSG_MATRIX_ROW = "{0}  {1}  {2}  {3}"
 const MSG_MISSING_BUILD_BOARD = "Warning: Board {0}:{1}:{2} doesn''t define a ''build.board'' preference. Auto-set to: {3}"
 const MSG_MISSING_CORE_FOR_BOARD = "Selected board depends on '{0}' core (not installed)."
 const MSG_MUST_BE_A_FOLDER = "{0} must be a folder"
+const MSG_OBJECT_CACHE_ERROR = "Object cache not available: {0}"
+const MSG_OUTPUT_NAME_UNSET_PROPERTY = "Output name {0}: {1} is not set"
 const MSG_PACKAGE_UNKNOWN = "{0}: Unknown package"
 const MSG_PATTERN_MISSING = "{0} pattern is missing"
 const MSG_PLATFORM_UNKNOWN = "Platform {0} (package {1}) is unknown"
 const MSG_PROGRESS = "Progress {0}"
 const MSG_PROP_IN_LIBRARY = "Missing '{0}' from library in {1}"
+const MSG_REPRODUCIBLE_ARTIFACT_DIFFERS = "{0} differs between the builds in {1} and {2}"
+const MSG_REPRODUCIBLE_ARTIFACT_MISSING = "{0} was only produced by the build in {1}"
+const MSG_REPRODUCIBLE_BUILDS_DIFFER = "{0} of the {1} artifacts differ between the two builds"
+const MSG_REPRODUCIBLE_BUILDS_IDENTICAL = "The {0} artifacts of the two builds are identical"
+const MSG_REPRODUCIBLE_SECOND_BUILD = "Building again in {0} to verify that the build is reproducible"
 const MSG_RUNNING_COMMAND = "Ts: {0} - Running: {1}"
 const MSG_RUNNING_RECIPE = "Running recipe: {0}"
+const MSG_SBOM_FORMAT_UNKNOWN = "Unknown SBOM format {0}, use {1} or {2}"
 const MSG_SETTING_BUILD_PATH = "Setting build path to {0}"
 const MSG_SIZER_TEXT_FULL = "Sketch uses {0} bytes ({2}%%) of program storage space. Maximum is {1} bytes."
 const MSG_SIZER_DATA_FULL = "Global variables use {0} bytes ({2}%%) of dynamic memory, leaving {3} bytes for local variables. Maximum is {1} bytes."
@@ -177,10 +249,25 @@ const MSG_SIZER_TEXT_TOO_BIG = "Sketch too big; see http://www.arduino.cc/en/Gui
 const MSG_SIZER_DATA_TOO_BIG = "Not enough memory; see http://www.arduino.cc/en/Guide/Troubleshooting#size for tips on reducing your footprint."
 const MSG_SIZER_LOW_MEMORY = "Low memory available, stability problems may occur."
 const MSG_SIZER_ERROR_NO_RULE = "Couldn't determine program size"
+const MSG_SKETCH_ARCHIVE_INVALID_PATH = "{0}: invalid path {1}"
+const MSG_SKETCH_ARCHIVE_NO_MAIN_FILE = "{0}: no sketch found in {1}, a .ino file was expected"
+const MSG_SKETCH_ARCHIVE_SEVERAL_MAIN_FILES = "{0}: several sketches found in {1} ({2}), but none of them is named after the folder"
+const MSG_SKETCH_ARCHIVE_TOO_LARGE = "{0}: {1} makes the sketch larger than {2} bytes"
 const MSG_SKETCH_CANT_BE_IN_BUILDPATH = "Sketch cannot be located in build path. Please specify a different build path"
 const MSG_SKIPPING_TAG_ALREADY_DEFINED = "Skipping tag {0} because prototype is already defined"
 const MSG_SKIPPING_TAG_BECAUSE_HAS_FIELD = "Skipping tag {0} because it has field {0}"
 const MSG_SKIPPING_TAG_WITH_REASON = "Skipping tag {0}. Reason: {1}"
+const MSG_SOURCE_DATE_EPOCH_INVALID = "SOURCE_DATE_EPOCH must be a number of seconds since the epoch, not {0}"
+const MSG_TRACE_INCLUDE_CACHE = "Include cache: {0} hits, {1} misses"
+const MSG_TRACE_OBJECT_CACHE = "Object cache: {0} hits, {1} misses"
+const MSG_TRACE_SLOWEST_FILES = "Slowest files:"
+const MSG_TRACE_SLOWEST_HOOKS = "Slowest hooks:"
+const MSG_TRACE_SLOWEST_PHASES = "Slowest phases:"
+const MSG_TRACE_SPAN = "  {0}: {1}"
+const MSG_WATCH_BUILD_FAILED = "Build {0} failed: {1}"
+const MSG_WATCH_BUILD_FINISHED = "Build {0} finished in {1}"
+const MSG_WATCH_BUILD_STARTED = "Build {0} started"
+const MSG_WATCH_WAITING = "Waiting for changes..."
 const MSG_UNHANDLED_TYPE_IN_CONTEXT = "Unhandled type {0} in context key {1}"
 const MSG_UNKNOWN_SKETCH_EXT = "Unknown sketch file extension: {0}"
 const MSG_USING_LIBRARY_AT_VERSION = "Using library {0} at version {1} in folder: {2} {3}"
@@ -188,6 +275,7 @@ const MSG_USING_LIBRARY = "Using library {0} in folder: {1} {2}"
 const MSG_USING_BOARD = "Using board '{0}' from platform in folder: {1}"
 const MSG_USING_CORE = "Using core '{0}' from platform in folder: {1}"
 const MSG_USING_PREVIOUS_COMPILED_FILE = "Using previously compiled file: {0}"
+const MSG_USING_OBJECT_CACHE_FILE = "Using file from the object cache: {0}"
 const MSG_USING_CACHED_INCLUDES = "Using cached library dependencies for file: {0}"
 const MSG_WARNING_LIB_INVALID_CATEGORY = "WARNING: Category '{0}' in library {1} is not valid. Setting to '{2}'"
 const MSG_WARNING_PLATFORM_MISSING_VALUE = "Warning: platform.txt from core '{0}' misses property '{1}', using default value '{2}'. Consider upgrading this core."
@@ -219,6 +307,8 @@ const RECIPE_S_PATTERN = "recipe.S.o.pattern"
 const RECIPE_SIZE_REGEXP = "recipe.size.regex"
 const RECIPE_SIZE_REGEXP_DATA = "recipe.size.regex.data"
 const RECIPE_SIZE_REGEXP_EEPROM = "recipe.size.regex.eeprom"
+const RECIPE_TIMEOUT = "recipe.timeout"
+const RECIPE_TIMEOUT_SUFFIX = ".timeout"
 const REWRITING_DISABLED = "disabled"
 const REWRITING = "rewriting"
 const SPACE = " "
@@ -50,10 +50,18 @@ func (s *ContainerAddPrototypes) Run(ctx *types.Context) error {
 		&PrototypesAdder{},
 		&SketchSaver{},
 	}
+	if ctx.DryRun {
+		// The preprocessed sketch isn't there to look for prototypes in
+		commands = []types.Command{
+			&GCCPreprocRunner{SourceFilePath: sourceFile, TargetFileName: constants.FILE_CTAGS_TARGET_FOR_GCC_MINUS_E, Includes: ctx.IncludeFolders},
+			&CTagsTargetFileSaver{Source: &ctx.Source, TargetFileName: constants.FILE_CTAGS_TARGET_FOR_GCC_MINUS_E},
+			&CTagsRunner{},
+			&SketchSaver{},
+		}
+	}
 
 	for _, command := range commands {
-		PrintRingNameIfDebug(ctx, command)
-		err := command.Run(ctx)
+		err := runCommand(ctx, command)
 		if err != nil {
 			return i18n.WrapError(err)
 		}
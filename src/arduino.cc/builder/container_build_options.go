@@ -43,10 +43,14 @@ func (s *ContainerBuildOptions) Run(ctx *types.Context) error {
 		&WipeoutBuildPathIfBuildOptionsChanged{},
 		&StoreBuildOptionsMap{},
 	}
+	if ctx.DryRun {
+		// Nothing is compiled, so the files in the build path still
+		// match the previous options
+		commands = commands[:1]
+	}
 
 	for _, command := range commands {
-		PrintRingNameIfDebug(ctx, command)
-		err := command.Run(ctx)
+		err := runCommand(ctx, command)
 		if err != nil {
 			return i18n.WrapError(err)
 		}
@@ -116,6 +116,7 @@ import (
 	"arduino.cc/builder/builder_utils"
 	"arduino.cc/builder/constants"
 	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/trace"
 	"arduino.cc/builder/types"
 	"arduino.cc/builder/utils"
 )
@@ -153,11 +154,14 @@ func (s *ContainerFindIncludes) Run(ctx *types.Context) error {
 		}
 	}
 
-	// Finalize the cache
+	// Finalize the cache. Includes found in dry runs may be wrong, so
+	// they are not cached
 	cache.ExpectEnd()
-	err = writeCache(cache, cachePath)
-	if err != nil {
-		return i18n.WrapError(err)
+	if !ctx.DryRun {
+		err = writeCache(cache, cachePath)
+		if err != nil {
+			return i18n.WrapError(err)
+		}
 	}
 
 	err = runCommand(ctx, &FailIfImportedLibraryIsWrong{})
@@ -178,15 +182,6 @@ func appendIncludeFolder(ctx *types.Context, cache *includeCache, sourceFilePath
 	cache.ExpectEntry(sourceFilePath, include, folder)
 }
 
-func runCommand(ctx *types.Context, command types.Command) error {
-	PrintRingNameIfDebug(ctx, command)
-	err := command.Run(ctx)
-	if err != nil {
-		return i18n.WrapError(err)
-	}
-	return nil
-}
-
 type includeCacheEntry struct {
 	Sourcefile  string
 	Include     string
@@ -318,15 +313,21 @@ func findIncludesUntilDone(ctx *types.Context, cache *includeCache, sourceFile t
 			includes = append(includes, library.UtilityFolder)
 		}
 		if unchanged && cache.valid {
+			ctx.Trace.Count(trace.COUNTER_INCLUDE_CACHE_HIT)
 			include = cache.Next().Include
 			if first && ctx.Verbose {
 				ctx.GetLogger().Println(constants.LOG_LEVEL_INFO, constants.MSG_USING_CACHED_INCLUDES, sourcePath)
 			}
 		} else {
+			ctx.Trace.Count(trace.COUNTER_INCLUDE_CACHE_MISS)
 			commands := []types.Command{
 				&GCCPreprocRunnerForDiscoveringIncludes{SourceFilePath: sourcePath, TargetFilePath: targetFilePath, Includes: includes},
 				&IncludesFinderWithRegExp{Source: &ctx.SourceGccMinusE},
 			}
+			if ctx.DryRun {
+				// The preprocessor isn't run, its output can't be used
+				commands[1] = &StaticIncludesFinder{SourceFilePath: sourcePath, Includes: includes}
+			}
 			for _, command := range commands {
 				err := runCommand(ctx, command)
 				if err != nil {
@@ -349,10 +350,22 @@ func findIncludesUntilDone(ctx *types.Context, cache *includeCache, sourceFile t
 			return i18n.WrapError(err)
 		}
 
+		i18n.LogEvent(ctx.GetLogger(), os.Stdout, constants.EVENT_LIBRARY_RESOLVED, map[string]interface{}{
+			"include": include,
+			"source":  sourcePath,
+			"library": library.Name,
+			"version": library.Version,
+			"folder":  library.Folder,
+		})
+
 		// Add this library to the list of libraries, the
 		// include path and queue its source files for further
 		// include scanning
 		ctx.ImportedLibraries = append(ctx.ImportedLibraries, library)
+		if ctx.ImportedLibrariesIncludes == nil {
+			ctx.ImportedLibrariesIncludes = make(map[*types.Library]types.LibraryInclude)
+		}
+		ctx.ImportedLibrariesIncludes[library] = types.LibraryInclude{Include: include, SourceFile: sourcePath}
 		appendIncludeFolder(ctx, cache, sourcePath, include, library.SrcFolder)
 		sourceFolders := types.LibraryToSourceFolder(library)
 		for _, sourceFolder := range sourceFolders {
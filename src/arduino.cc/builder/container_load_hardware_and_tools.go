@@ -0,0 +1,122 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package builder
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+	"arduino.cc/builder/utils"
+)
+
+// Deep enough to see platforms and tools being installed or removed.
+// Changes to boards.txt, platform.txt and the like are seen by stamping
+// the ones of the loaded platforms
+const HARDWARE_STAMP_DEPTH = 3
+
+var PLATFORM_FILES = []string{
+	constants.FILE_BOARDS_TXT,
+	constants.FILE_BOARDS_LOCAL_TXT,
+	constants.FILE_PLATFORM_TXT,
+	constants.FILE_PLATFORM_LOCAL_TXT,
+	constants.FILE_PROGRAMMERS_TXT,
+}
+
+type ContainerLoadHardwareAndTools struct{}
+
+func (s *ContainerLoadHardwareAndTools) Run(ctx *types.Context) error {
+	var key, stamp string
+	if ctx.LoaderCache != nil {
+		// Done by HardwareLoader too, and stored in the build
+		// options: they must not change when hardware is cached
+		hardwareFolders, err := utils.AbsolutizePaths(ctx.HardwareFolders)
+		if err != nil {
+			return i18n.WrapError(err)
+		}
+		ctx.HardwareFolders = hardwareFolders
+
+		folders := append(append([]string{}, ctx.HardwareFolders...), ctx.ToolsFolders...)
+		key = strings.Join(ctx.HardwareFolders, "\n") + "\n\n" + strings.Join(ctx.ToolsFolders, "\n")
+		stamp = utils.FoldersStamp(folders, HARDWARE_STAMP_DEPTH)
+		if loaded := ctx.LoaderCache.Hardware(key, stamp); loaded != nil && utils.FilesStamp(loaded.PlatformFiles) == loaded.PlatformFilesStamp {
+			ctx.Hardware = loaded.Hardware
+			ctx.Tools = loaded.Tools
+			ctx.PlatformKeyRewrites = loaded.PlatformKeyRewrites
+			ctx.HardwareRewriteResults = loaded.HardwareRewriteResults
+			return nil
+		}
+	}
+
+	commands := []types.Command{
+		&HardwareLoader{},
+		&PlatformKeysRewriteLoader{},
+		&RewriteHardwareKeys{},
+		&ToolsLoader{},
+		&AddBuildBoardPropertyIfMissing{},
+	}
+
+	for _, command := range commands {
+		err := runCommand(ctx, command)
+		if err != nil {
+			return i18n.WrapError(err)
+		}
+	}
+
+	if ctx.LoaderCache != nil {
+		platformFiles := loadedPlatformFiles(ctx.Hardware)
+		ctx.LoaderCache.SetHardware(key, stamp, &types.LoadedHardware{
+			Hardware:               ctx.Hardware,
+			Tools:                  ctx.Tools,
+			PlatformKeyRewrites:    ctx.PlatformKeyRewrites,
+			HardwareRewriteResults: ctx.HardwareRewriteResults,
+			PlatformFiles:          platformFiles,
+			PlatformFilesStamp:     utils.FilesStamp(platformFiles),
+		})
+	}
+
+	return nil
+}
+
+func loadedPlatformFiles(hardware *types.Packages) []string {
+	var files []string
+	for _, targetPackage := range hardware.Packages {
+		for _, platform := range targetPackage.Platforms {
+			for _, name := range PLATFORM_FILES {
+				files = append(files, filepath.Join(platform.Folder, name))
+			}
+		}
+	}
+	sort.Strings(files)
+	return files
+}
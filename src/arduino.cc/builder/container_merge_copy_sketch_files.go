@@ -44,8 +44,7 @@ func (s *ContainerMergeCopySketchFiles) Run(ctx *types.Context) error {
 	}
 
 	for _, command := range commands {
-		PrintRingNameIfDebug(ctx, command)
-		err := command.Run(ctx)
+		err := runCommand(ctx, command)
 		if err != nil {
 			return i18n.WrapError(err)
 		}
@@ -40,23 +40,19 @@ func (s *ContainerSetupHardwareToolsLibsSketchAndProps) Run(ctx *types.Context)
 	commands := []types.Command{
 		&AddAdditionalEntriesToContext{},
 		&FailIfBuildPathEqualsSketchPath{},
-		&HardwareLoader{},
-		&PlatformKeysRewriteLoader{},
-		&RewriteHardwareKeys{},
-		&ToolsLoader{},
+		&ContainerLoadHardwareAndTools{},
 		&TargetBoardResolver{},
-		&AddBuildBoardPropertyIfMissing{},
 		&LibrariesLoader{},
 		&SketchLoader{},
 		&SetupBuildProperties{},
 		&LoadVIDPIDSpecificProperties{},
 		&SetCustomBuildProperties{},
 		&AddMissingBuildPropertiesFromParentPlatformTxtFiles{},
+		&SetReproducibleBuildProperties{},
 	}
 
 	for _, command := range commands {
-		PrintRingNameIfDebug(ctx, command)
-		err := command.Run(ctx)
+		err := runCommand(ctx, command)
 		if err != nil {
 			return i18n.WrapError(err)
 		}
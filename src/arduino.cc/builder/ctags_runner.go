@@ -30,11 +30,14 @@
 package builder
 
 import (
-	"fmt"
+	"bytes"
+	"os"
 
+	"arduino.cc/builder/builder_utils"
 	"arduino.cc/builder/constants"
 	"arduino.cc/builder/ctags"
 	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/trace"
 	"arduino.cc/builder/types"
 	"arduino.cc/builder/utils"
 )
@@ -63,15 +66,24 @@ func (s *CTagsRunner) Run(ctx *types.Context) error {
 
 	verbose := ctx.Verbose
 	if verbose {
-		fmt.Println(commandLine)
+		builder_utils.PrintCommandLine(logger, os.Stdout, commandLine)
 	}
 
-	sourceBytes, err := command.Output()
+	timeout, err := builder_utils.RecipeTimeout(buildProperties, constants.BUILD_PROPERTIES_TOOLS_KEY+"."+constants.CTAGS+"."+constants.BUILD_PROPERTIES_PATTERN)
 	if err != nil {
 		return i18n.WrapError(err)
 	}
 
-	ctx.CTagsOutput = string(sourceBytes)
+	output := &bytes.Buffer{}
+	command.Stdout = output
+	span := ctx.Trace.Begin(trace.CATEGORY_RECIPE, constants.CTAGS, map[string]interface{}{"command": commandLine})
+	err = utils.RunCommand(ctx, command, timeout)
+	span.End()
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+
+	ctx.CTagsOutput = string(output.Bytes())
 
 	parser := &ctags.CTagsParser{}
 	ctx.CTagsOfPreprocessedSource = parser.Parse(ctx.CTagsOutput)
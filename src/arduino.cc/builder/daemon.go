@@ -0,0 +1,117 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package builder
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+)
+
+const DAEMON_PATH_COMPILE = "/compile"
+const DAEMON_PATH_DUMP_PREFS = "/dump-prefs"
+const DAEMON_PATH_PREPROCESS = "/preprocess"
+
+// Serves builds over HTTP, keeping hardware, tools and libraries loaded
+// between them: they're loaded again only when their folders change.
+//
+// Requests are CompileOptions, POSTed as JSON to DAEMON_PATH_COMPILE,
+// DAEMON_PATH_PREPROCESS or DAEMON_PATH_DUMP_PREFS, and are served
+// concurrently. A build is canceled if its client goes away.
+type Daemon struct {
+	LoaderCache *types.LoaderCache
+}
+
+type DaemonResponse struct {
+	Success  bool                      `json:"success"`
+	Error    string                    `json:"error,omitempty"`
+	Messages []i18n.AccumulatedMessage `json:"messages"`
+	// Compile only
+	Result *BuildResult `json:"result,omitempty"`
+	// Preprocess only
+	Source string `json:"source,omitempty"`
+	// Dump prefs only
+	BuildProperties map[string]string `json:"build_properties,omitempty"`
+}
+
+func NewDaemon() *Daemon {
+	return &Daemon{LoaderCache: types.NewLoaderCache()}
+}
+
+func (d *Daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case DAEMON_PATH_COMPILE, DAEMON_PATH_PREPROCESS, DAEMON_PATH_DUMP_PREFS:
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	options := &CompileOptions{}
+	if err := json.NewDecoder(r.Body).Decode(options); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logger := &i18n.AccumulatorLogger{}
+	options.Logger = logger
+	options.Context = r.Context()
+	ctx := options.newContext()
+	ctx.LoaderCache = d.LoaderCache
+
+	response := &DaemonResponse{}
+	var err error
+	switch r.URL.Path {
+	case DAEMON_PATH_COMPILE:
+		command := &Builder{}
+		err = command.Run(ctx)
+		response.Result = command.Result
+	case DAEMON_PATH_PREPROCESS:
+		defer unlockBuildPath(ctx)
+		err = runCommands(ctx, preprocessCommands(), false)
+		response.Source = ctx.SourceGccMinusE
+	case DAEMON_PATH_DUMP_PREFS:
+		err = runCommands(ctx, parseHardwareCommands(), false)
+		response.BuildProperties = ctx.BuildProperties
+	}
+
+	response.Success = err == nil
+	if err != nil {
+		response.Error = err.Error()
+	}
+	response.Messages = logger.Messages()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
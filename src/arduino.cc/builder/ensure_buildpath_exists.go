@@ -43,5 +43,10 @@ func (s *EnsureBuildPathExists) Run(ctx *types.Context) error {
 		return i18n.WrapError(err)
 	}
 
+	err = lockBuildPath(ctx)
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+
 	return nil
 }
@@ -0,0 +1,311 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+	"arduino.cc/builder/utils"
+)
+
+const EXPORT_BUILD_MAKE = "make"
+const EXPORT_BUILD_NINJA = "ninja"
+
+const EXPORT_STAMP_SUFFIX = ".stamp"
+const EXPORT_PREBUILD_STAMP_SUFFIX = ".prebuild.stamp"
+
+// Runs the preprocessing steps of the build, then, instead of compiling,
+// writes the commands of the other steps in a build.ninja or Makefile in
+// the build path. Each step ends by touching a stamp file in the build
+// path, the steps depending on it are run again when it changes.
+type ExportBuild struct {
+	Format string
+}
+
+type exportWriter func(w io.Writer, ctx *types.Context, steps []*exportedStep)
+
+var exportWriters = map[string]struct {
+	file  string
+	write exportWriter
+}{
+	EXPORT_BUILD_MAKE:  {constants.FILE_MAKEFILE, writeMakefile},
+	EXPORT_BUILD_NINJA: {constants.FILE_BUILD_NINJA, writeBuildNinja},
+}
+
+// The commands of a build step: the ones run before the first file is
+// compiled, the compilations and the ones run after
+type exportedStep struct {
+	name         string
+	preCommands  []string
+	compiles     []types.CompileCommand
+	postCommands []string
+	// Names of the exported steps this one depends on
+	dependencies []string
+}
+
+func (s *ExportBuild) Run(ctx *types.Context) error {
+	writer, ok := exportWriters[s.Format]
+	if !ok {
+		return i18n.ErrorfWithLogger(ctx.GetLogger(), constants.MSG_EXPORT_BUILD_FORMAT_UNKNOWN, s.Format, EXPORT_BUILD_MAKE, EXPORT_BUILD_NINJA)
+	}
+	defer unlockBuildPath(ctx)
+
+	graph := newBuilderGraph()
+	preprocessing := &BuildGraph{}
+	prepared := make(map[string]bool)
+	for _, step := range graph.Steps {
+		if step.Preprocess {
+			preprocessing.Steps = append(preprocessing.Steps, step)
+			for _, output := range step.Outputs {
+				prepared[output] = true
+			}
+		}
+	}
+
+	// Inputs produced by preprocessing are available to the exported steps
+	exported := &BuildGraph{}
+	for _, step := range graph.Steps {
+		if step.Preprocess {
+			continue
+		}
+		exportedStep := *step
+		exportedStep.Inputs = nil
+		for _, input := range step.Inputs {
+			if !prepared[input] {
+				exportedStep.Inputs = append(exportedStep.Inputs, input)
+			}
+		}
+		exported.Steps = append(exported.Steps, &exportedStep)
+	}
+
+	err := preprocessing.Run(ctx, true)
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+
+	ctx.DryRun = true
+	err = exported.Run(ctx, false)
+	ctx.DryRun = false
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+
+	var buffer bytes.Buffer
+	writer.write(&buffer, ctx, exportedSteps(ctx, exported))
+	path := filepath.Join(ctx.BuildPath, writer.file)
+	err = utils.WriteFileBytes(path, buffer.Bytes())
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+
+	ctx.GetLogger().Println(constants.LOG_LEVEL_INFO, constants.MSG_BUILD_EXPORTED, path)
+
+	return nil
+}
+
+// Sort the commands recorded while dry running graph by step
+func exportedSteps(ctx *types.Context, graph *BuildGraph) []*exportedStep {
+	compiles := make(map[string]types.CompileCommand)
+	for _, compile := range ctx.CompileCommands() {
+		compiles[utils.CommandLine(compile.Arguments)] = compile
+	}
+
+	producers := make(map[string]string)
+	for _, step := range graph.Steps {
+		for _, output := range step.Outputs {
+			producers[output] = step.Name
+		}
+	}
+
+	steps := []*exportedStep{}
+	byName := make(map[string]*exportedStep)
+	for _, step := range graph.Steps {
+		exported := &exportedStep{name: step.Name}
+		for _, input := range step.Inputs {
+			exported.dependencies = append(exported.dependencies, producers[input])
+		}
+		steps = append(steps, exported)
+		byName[step.Name] = exported
+	}
+
+	for _, command := range ctx.DryRunCommands() {
+		step := byName[command.Phase]
+		if compile, ok := compiles[command.CommandLine]; ok {
+			step.compiles = append(step.compiles, compile)
+		} else if len(step.compiles) == 0 {
+			step.preCommands = append(step.preCommands, command.CommandLine)
+		} else {
+			step.postCommands = append(step.postCommands, command.CommandLine)
+		}
+	}
+
+	// Without compilations, all of the commands are run together
+	for _, step := range steps {
+		if len(step.compiles) == 0 {
+			step.postCommands = append(step.preCommands, step.postCommands...)
+			step.preCommands = nil
+		}
+	}
+
+	return steps
+}
+
+func stampFile(ctx *types.Context, step string, suffix string) string {
+	return filepath.Join(ctx.BuildPath, step+suffix)
+}
+
+func dependencyStamps(ctx *types.Context, step *exportedStep) []string {
+	stamps := []string{}
+	for _, dependency := range step.dependencies {
+		stamps = append(stamps, stampFile(ctx, dependency, EXPORT_STAMP_SUFFIX))
+	}
+	return stamps
+}
+
+// Return the dependency file written by the compiler, if it's asked to
+func depFile(compile types.CompileCommand) string {
+	for _, argument := range compile.Arguments {
+		if argument == "-MMD" || argument == "-MD" {
+			return strings.TrimSuffix(compile.Output, filepath.Ext(compile.Output)) + ".d"
+		}
+	}
+	return ""
+}
+
+func writeBuildNinja(w io.Writer, ctx *types.Context, steps []*exportedStep) {
+	fmt.Fprintf(w, "# Generated by arduino-builder for %s (%s)\n\n", ctx.SketchLocation, ctx.FQBN)
+	fmt.Fprintf(w, "rule run\n  command = $cmd\n")
+
+	defaults := []string{}
+	for _, step := range steps {
+		inputs := dependencyStamps(ctx, step)
+		stamp := stampFile(ctx, step.name, EXPORT_STAMP_SUFFIX)
+		defaults = append(defaults, stamp)
+
+		orderOnly := inputs
+		if len(step.preCommands) > 0 {
+			prebuildStamp := stampFile(ctx, step.name, EXPORT_PREBUILD_STAMP_SUFFIX)
+			writeNinjaEdge(w, prebuildStamp, inputs, nil, append(step.preCommands, utils.CommandLine([]string{"touch", prebuildStamp})), "")
+			orderOnly = []string{prebuildStamp}
+		}
+
+		objects := []string{}
+		for _, compile := range step.compiles {
+			writeNinjaEdge(w, compile.Output, []string{compile.File}, orderOnly, []string{utils.CommandLine(compile.Arguments)}, depFile(compile))
+			objects = append(objects, compile.Output)
+		}
+
+		writeNinjaEdge(w, stamp, append(objects, orderOnly...), nil, append(step.postCommands, utils.CommandLine([]string{"touch", stamp})), "")
+	}
+
+	fmt.Fprintf(w, "\ndefault %s\n", strings.Join(utils.Map(defaults, ninjaPath), " "))
+}
+
+func writeNinjaEdge(w io.Writer, output string, inputs []string, orderOnly []string, commands []string, depFile string) {
+	fmt.Fprintf(w, "\nbuild %s: run", ninjaPath(output))
+	for _, input := range inputs {
+		fmt.Fprintf(w, " %s", ninjaPath(input))
+	}
+	if len(orderOnly) > 0 {
+		fmt.Fprintf(w, " ||")
+		for _, input := range orderOnly {
+			fmt.Fprintf(w, " %s", ninjaPath(input))
+		}
+	}
+	fmt.Fprintf(w, "\n  cmd = %s\n", strings.Replace(strings.Join(commands, " && "), "$", "$$", -1))
+	if depFile != "" {
+		fmt.Fprintf(w, "  depfile = %s\n  deps = gcc\n", strings.Replace(depFile, "$", "$$", -1))
+	}
+}
+
+func ninjaPath(path string) string {
+	return strings.NewReplacer("$", "$$", " ", "$ ", ":", "$:").Replace(path)
+}
+
+func writeMakefile(w io.Writer, ctx *types.Context, steps []*exportedStep) {
+	fmt.Fprintf(w, "# Generated by arduino-builder for %s (%s)\n\n", ctx.SketchLocation, ctx.FQBN)
+
+	stamps := []string{}
+	for _, step := range steps {
+		stamps = append(stamps, stampFile(ctx, step.name, EXPORT_STAMP_SUFFIX))
+	}
+	fmt.Fprintf(w, "all: %s\n\n.PHONY: all\n", strings.Join(utils.Map(stamps, makePath), " "))
+
+	for _, step := range steps {
+		inputs := dependencyStamps(ctx, step)
+		stamp := stampFile(ctx, step.name, EXPORT_STAMP_SUFFIX)
+
+		orderOnly := inputs
+		if len(step.preCommands) > 0 {
+			prebuildStamp := stampFile(ctx, step.name, EXPORT_PREBUILD_STAMP_SUFFIX)
+			writeMakeRule(w, prebuildStamp, inputs, nil, append(step.preCommands, utils.CommandLine([]string{"touch", prebuildStamp})))
+			orderOnly = []string{prebuildStamp}
+		}
+
+		objects := []string{}
+		for _, compile := range step.compiles {
+			writeMakeRule(w, compile.Output, []string{compile.File}, orderOnly, []string{utils.CommandLine(compile.Arguments)})
+			if depFile := depFile(compile); depFile != "" {
+				fmt.Fprintf(w, "-include %s\n", makePath(depFile))
+			}
+			objects = append(objects, compile.Output)
+		}
+
+		writeMakeRule(w, stamp, append(objects, orderOnly...), nil, append(step.postCommands, utils.CommandLine([]string{"touch", stamp})))
+	}
+}
+
+func writeMakeRule(w io.Writer, target string, prerequisites []string, orderOnly []string, commands []string) {
+	fmt.Fprintf(w, "\n%s:", makePath(target))
+	for _, prerequisite := range prerequisites {
+		fmt.Fprintf(w, " %s", makePath(prerequisite))
+	}
+	if len(orderOnly) > 0 {
+		fmt.Fprintf(w, " |")
+		for _, prerequisite := range orderOnly {
+			fmt.Fprintf(w, " %s", makePath(prerequisite))
+		}
+	}
+	fmt.Fprintf(w, "\n")
+	for _, command := range commands {
+		fmt.Fprintf(w, "\t%s\n", strings.Replace(command, "$", "$$", -1))
+	}
+}
+
+func makePath(path string) string {
+	return strings.NewReplacer("$", "$$", " ", "\\ ").Replace(path)
+}
@@ -39,7 +39,8 @@ import (
 type FailIfBuildPathEqualsSketchPath struct{}
 
 func (s *FailIfBuildPathEqualsSketchPath) Run(ctx *types.Context) error {
-	if ctx.BuildPath == "" || ctx.SketchLocation == "" {
+	// Archives are unpacked in a folder of their own
+	if ctx.BuildPath == "" || ctx.SketchLocation == "" || IsSketchArchive(ctx.SketchLocation) {
 		return nil
 	}
 
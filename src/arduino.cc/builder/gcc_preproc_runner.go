@@ -59,8 +59,7 @@ func (s *GCCPreprocRunner) Run(ctx *types.Context) error {
 	}
 
 	verbose := ctx.Verbose
-	logger := ctx.GetLogger()
-	_, err = builder_utils.ExecRecipe(properties, constants.RECIPE_PREPROC_MACROS, true, verbose, false, logger)
+	_, err = builder_utils.ExecRecipe(ctx, properties, constants.RECIPE_PREPROC_MACROS, true, verbose, false)
 	if err != nil {
 		return i18n.WrapError(err)
 	}
@@ -83,14 +82,13 @@ func (s *GCCPreprocRunnerForDiscoveringIncludes) Run(ctx *types.Context) error {
 	}
 
 	verbose := ctx.Verbose
-	logger := ctx.GetLogger()
 
 	if properties[constants.RECIPE_PREPROC_MACROS] == constants.EMPTY_STRING {
 		//generate PREPROC_MACROS from RECIPE_CPP_PATTERN
 		properties[constants.RECIPE_PREPROC_MACROS] = GeneratePreprocPatternFromCompile(properties[constants.RECIPE_CPP_PATTERN])
 	}
 
-	stderr, err := builder_utils.ExecRecipeCollectStdErr(properties, constants.RECIPE_PREPROC_MACROS, true, verbose, false, logger)
+	stderr, err := builder_utils.ExecRecipeCollectStdErr(ctx, properties, constants.RECIPE_PREPROC_MACROS, true, verbose, false)
 	if err != nil {
 		return i18n.WrapError(err)
 	}
@@ -5,7 +5,7 @@ import "github.com/go-errors/errors"
 import "os"
 
 func ErrorfWithLogger(logger Logger, format string, a ...interface{}) *errors.Error {
-	if logger.Name() == "machine" {
+	if logger.Name() == "machine" || logger.Name() == "json" {
 		logger.Fprintln(os.Stderr, constants.LOG_LEVEL_ERROR, format, a...)
 		return errors.Errorf("")
 	}
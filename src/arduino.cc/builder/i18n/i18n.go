@@ -30,6 +30,9 @@
 package i18n
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/url"
@@ -38,6 +41,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 var PLACEHOLDER = regexp.MustCompile("{(\\d)}")
@@ -72,6 +76,38 @@ func (s HumanLogger) Name() string {
 	return "human"
 }
 
+// Keeps the messages in memory instead of printing them, so that they
+// can be given back to whoever asked for the build
+type AccumulatorLogger struct {
+	lock     sync.Mutex
+	messages []AccumulatedMessage
+}
+
+type AccumulatedMessage struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+func (s *AccumulatorLogger) Fprintln(w io.Writer, level string, format string, a ...interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.messages = append(s.messages, AccumulatedMessage{Level: level, Message: Format(format, a...)})
+}
+
+func (s *AccumulatorLogger) Println(level string, format string, a ...interface{}) {
+	s.Fprintln(nil, level, format, a...)
+}
+
+func (s *AccumulatorLogger) Name() string {
+	return "accumulator"
+}
+
+func (s *AccumulatorLogger) Messages() []AccumulatedMessage {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]AccumulatedMessage{}, s.messages...)
+}
+
 type MachineLogger struct{}
 
 func (s MachineLogger) printWithoutFormatting(w io.Writer, level string, format string, a []interface{}) {
@@ -98,6 +134,108 @@ func (s MachineLogger) Name() string {
 	return "machine"
 }
 
+// Loggers that, besides messages, can report structured events about
+// the build (e.g. a file being compiled). Events are written to w, like
+// messages are.
+type EventLogger interface {
+	Event(w io.Writer, event string, data map[string]interface{})
+}
+
+// Report the given event if the logger supports events
+func LogEvent(logger Logger, w io.Writer, event string, data map[string]interface{}) {
+	if eventLogger, ok := logger.(EventLogger); ok {
+		eventLogger.Event(w, event, data)
+	}
+}
+
+// Writes one JSON object per line, either a message:
+//
+//	{"type":"message","id":"msg_6d3bc0a4","level":"info","message":"Using library SPI in folder: ...","format":"Using library {0} in folder: {1} {2}","args":["SPI","...",""]}
+//
+// or an event:
+//
+//	{"type":"event","event":"file.compiled","data":{"source":"...","object":"...","cached":false}}
+//
+// Message ids are derived from the untranslated format string, so they
+// stay the same as long as the message doesn't change.
+type JSONLogger struct{}
+
+var jsonLoggerLock sync.Mutex
+
+type jsonLoggerEntry struct {
+	Type    string                 `json:"type"`
+	Id      string                 `json:"id,omitempty"`
+	Level   string                 `json:"level,omitempty"`
+	Message string                 `json:"message,omitempty"`
+	Format  string                 `json:"format,omitempty"`
+	Args    []interface{}          `json:"args,omitempty"`
+	Event   string                 `json:"event,omitempty"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+}
+
+func (s JSONLogger) write(w io.Writer, entry jsonLoggerEntry) {
+	bytes, err := json.Marshal(entry)
+	if err != nil {
+		// Something in the args or data can't be marshalled, fall
+		// back to their string representation. The data belongs to
+		// the caller, so it's copied
+		entry.Args = jsonFriendly(entry.Args)
+		data := make(map[string]interface{}, len(entry.Data))
+		for key, value := range entry.Data {
+			data[key] = fmt.Sprint(value)
+		}
+		entry.Data = data
+		bytes, _ = json.Marshal(entry)
+	}
+	bytes = append(bytes, '\n')
+
+	jsonLoggerLock.Lock()
+	defer jsonLoggerLock.Unlock()
+	w.Write(bytes)
+}
+
+func jsonFriendly(a []interface{}) []interface{} {
+	result := make([]interface{}, len(a))
+	for idx, value := range a {
+		switch value.(type) {
+		case string, bool, int, int32, int64, float32, float64:
+			result[idx] = value
+		default:
+			result[idx] = fmt.Sprint(value)
+		}
+	}
+	return result
+}
+
+func (s JSONLogger) Fprintln(w io.Writer, level string, format string, a ...interface{}) {
+	s.write(w, jsonLoggerEntry{
+		Type:    "message",
+		Id:      MessageId(format),
+		Level:   level,
+		Message: Format(format, a...),
+		Format:  format,
+		Args:    jsonFriendly(a),
+	})
+}
+
+func (s JSONLogger) Println(level string, format string, a ...interface{}) {
+	s.Fprintln(os.Stdout, level, format, a...)
+}
+
+func (s JSONLogger) Event(w io.Writer, event string, data map[string]interface{}) {
+	s.write(w, jsonLoggerEntry{Type: "event", Event: event, Data: data})
+}
+
+func (s JSONLogger) Name() string {
+	return "json"
+}
+
+// Return an id identifying the given message format
+func MessageId(format string) string {
+	hash := sha1.Sum([]byte(format))
+	return "msg_" + hex.EncodeToString(hash[:4])
+}
+
 func FromJavaToGoSyntax(s string) string {
 	submatches := PLACEHOLDER.FindAllStringSubmatch(s, -1)
 	for _, submatch := range submatches {
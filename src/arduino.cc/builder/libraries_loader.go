@@ -41,6 +41,10 @@ import (
 	"arduino.cc/properties"
 )
 
+// Deep enough to see changes to library.properties files and to the
+// headers of the libraries
+const LIBRARIES_STAMP_DEPTH = 2
+
 type LibrariesLoader struct{}
 
 func (s *LibrariesLoader) Run(ctx *types.Context) error {
@@ -74,6 +78,16 @@ func (s *LibrariesLoader) Run(ctx *types.Context) error {
 
 	var libraries []*types.Library
 	for _, libraryFolder := range sortedLibrariesFolders {
+		var stamp string
+		if ctx.LoaderCache != nil {
+			stamp = utils.FoldersStamp([]string{libraryFolder}, LIBRARIES_STAMP_DEPTH)
+			if cached, ok := ctx.LoaderCache.Libraries(libraryFolder, stamp); ok {
+				libraries = append(libraries, cached...)
+				continue
+			}
+		}
+
+		var folderLibraries []*types.Library
 		subFolders, err := utils.ReadDirFiltered(libraryFolder, utils.FilterDirs)
 		if err != nil {
 			return i18n.WrapError(err)
@@ -83,8 +97,10 @@ func (s *LibrariesLoader) Run(ctx *types.Context) error {
 			if err != nil {
 				return i18n.WrapError(err)
 			}
-			libraries = append(libraries, library)
+			folderLibraries = append(folderLibraries, library)
 		}
+		ctx.LoaderCache.SetLibraries(libraryFolder, stamp, folderLibraries)
+		libraries = append(libraries, folderLibraries...)
 	}
 
 	ctx.Libraries = libraries
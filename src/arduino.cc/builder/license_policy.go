@@ -0,0 +1,250 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package builder
+
+import (
+	"path"
+	"strconv"
+	"strings"
+
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+	"arduino.cc/properties"
+)
+
+const LICENSE_POLICY_ALLOW = "allow"
+const LICENSE_POLICY_DENY = "deny"
+const LICENSE_POLICY_DISALLOWED = "disallowed"
+const LICENSE_POLICY_UNSPECIFIED = "unspecified"
+
+const LICENSE_POLICY_ACTION_ALLOW = "allow"
+const LICENSE_POLICY_ACTION_WARN = "warn"
+const LICENSE_POLICY_ACTION_FAIL = "fail"
+
+// Which licenses the imported libraries may have, read from a file like
+//
+//	# Comma separated, * matches any characters, case doesn't matter
+//	allow=MIT, BSD-*, Apache-2.0
+//	deny=GPL-*, AGPL-*
+//	# What to do with libraries whose license isn't allowed: warn or fail
+//	disallowed=fail
+//	# and with libraries without a license: allow, warn or fail
+//	unspecified=warn
+//
+// Denied licenses aren't allowed even when matched by allow. Any license
+// not denied is allowed when allow is empty.
+type LicensePolicy struct {
+	Allow       []string
+	Deny        []string
+	Disallowed  string
+	Unspecified string
+}
+
+func LoadLicensePolicy(file string, logger i18n.Logger) (*LicensePolicy, error) {
+	policyProperties, err := properties.Load(file, logger)
+	if err != nil {
+		return nil, i18n.WrapError(err)
+	}
+
+	policy := &LicensePolicy{
+		Allow:       licensePatterns(policyProperties[LICENSE_POLICY_ALLOW]),
+		Deny:        licensePatterns(policyProperties[LICENSE_POLICY_DENY]),
+		Disallowed:  LICENSE_POLICY_ACTION_FAIL,
+		Unspecified: LICENSE_POLICY_ACTION_WARN,
+	}
+	actions := map[string]*string{
+		LICENSE_POLICY_DISALLOWED:  &policy.Disallowed,
+		LICENSE_POLICY_UNSPECIFIED: &policy.Unspecified,
+	}
+	for key, value := range policyProperties {
+		value = strings.TrimSpace(value)
+		switch key {
+		case LICENSE_POLICY_ALLOW, LICENSE_POLICY_DENY:
+		case LICENSE_POLICY_DISALLOWED, LICENSE_POLICY_UNSPECIFIED:
+			if value != LICENSE_POLICY_ACTION_ALLOW && value != LICENSE_POLICY_ACTION_WARN && value != LICENSE_POLICY_ACTION_FAIL {
+				return nil, i18n.ErrorfWithLogger(logger, constants.MSG_LICENSE_POLICY_INVALID_ACTION, file, value, key)
+			}
+			*actions[key] = value
+		default:
+			return nil, i18n.ErrorfWithLogger(logger, constants.MSG_LICENSE_POLICY_UNKNOWN_KEY, file, key)
+		}
+	}
+	return policy, nil
+}
+
+func licensePatterns(value string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != constants.EMPTY_STRING {
+			patterns = append(patterns, strings.ToLower(pattern))
+		}
+	}
+	return patterns
+}
+
+// Whether license, an SPDX license expression or the free text found in
+// library.properties, is allowed. An OR expression is allowed when one of
+// its sides is, an AND expression when both are. A malformed expression
+// isn't allowed
+func (policy *LicensePolicy) Allows(license string) bool {
+	tokens := strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ").Replace(license))
+	expression := &licenseExpression{tokens: tokens, allows: policy.allowsLicense}
+	allowed := expression.or()
+	return allowed && !expression.malformed && len(expression.tokens) == 0
+}
+
+// A license made of several words is denied if any of them is, so that
+// a denied license isn't let through by writing it next to another one
+// (e.g. "MIT GPL-3.0")
+func (policy *LicensePolicy) allowsLicense(license string) bool {
+	license = strings.ToLower(license)
+	for _, pattern := range policy.Deny {
+		if matched, _ := path.Match(pattern, license); matched {
+			return false
+		}
+		for _, word := range strings.Fields(license) {
+			if matched, _ := path.Match(pattern, word); matched {
+				return false
+			}
+		}
+	}
+	if len(policy.Allow) == 0 {
+		return true
+	}
+	for _, pattern := range policy.Allow {
+		if matched, _ := path.Match(pattern, license); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluates the tokens of a license expression. Consecutive words, like
+// "GPL-2.0 WITH Classpath-exception-2.0" or "GNU Lesser General Public
+// License", are a single license
+type licenseExpression struct {
+	tokens []string
+	allows func(license string) bool
+	// Set on a missing parenthesis or license
+	malformed bool
+}
+
+func (e *licenseExpression) or() bool {
+	allowed := e.and()
+	for e.next("OR") {
+		allowed = e.and() || allowed
+	}
+	return allowed
+}
+
+func (e *licenseExpression) and() bool {
+	allowed := e.license()
+	for e.next("AND") {
+		allowed = e.license() && allowed
+	}
+	return allowed
+}
+
+func (e *licenseExpression) license() bool {
+	if e.next("(") {
+		allowed := e.or()
+		if !e.next(")") {
+			e.malformed = true
+		}
+		return allowed
+	}
+	var words []string
+	for len(e.tokens) > 0 && !isLicenseOperator(e.tokens[0]) {
+		words = append(words, e.tokens[0])
+		e.tokens = e.tokens[1:]
+	}
+	if len(words) == 0 {
+		e.malformed = true
+		return false
+	}
+	return e.allows(strings.Join(words, constants.SPACE))
+}
+
+func (e *licenseExpression) next(token string) bool {
+	if len(e.tokens) > 0 && strings.EqualFold(e.tokens[0], token) {
+		e.tokens = e.tokens[1:]
+		return true
+	}
+	return false
+}
+
+func isLicenseOperator(token string) bool {
+	return token == "(" || token == ")" || strings.EqualFold(token, "AND") || strings.EqualFold(token, "OR")
+}
+
+// Checks the licenses of the imported libraries against the policy in
+// ctx.LicensePolicyFile, if any, reporting the include that made each
+// offending library be used
+type CheckLicensePolicy struct{}
+
+func (s *CheckLicensePolicy) Run(ctx *types.Context) error {
+	if ctx.LicensePolicyFile == constants.EMPTY_STRING {
+		return nil
+	}
+	logger := ctx.GetLogger()
+	policy, err := LoadLicensePolicy(ctx.LicensePolicyFile, logger)
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+
+	failures := 0
+	for _, library := range ctx.ImportedLibraries {
+		action := LICENSE_POLICY_ACTION_ALLOW
+		message := constants.MSG_LICENSE_POLICY_DISALLOWED
+		if library.License == constants.EMPTY_STRING || library.License == constants.LIB_LICENSE_UNSPECIFIED {
+			action = policy.Unspecified
+			message = constants.MSG_LICENSE_POLICY_UNSPECIFIED
+		} else if !policy.Allows(library.License) {
+			action = policy.Disallowed
+		}
+
+		if action == LICENSE_POLICY_ACTION_ALLOW {
+			continue
+		}
+		level := constants.LOG_LEVEL_WARN
+		if action == LICENSE_POLICY_ACTION_FAIL {
+			level = constants.LOG_LEVEL_ERROR
+			failures++
+		}
+		include := ctx.ImportedLibrariesIncludes[library]
+		logger.Println(level, message, library.Name, library.Folder, include.Include, include.SourceFile, library.License)
+	}
+
+	if failures > 0 {
+		return i18n.ErrorfWithLogger(logger, constants.MSG_LICENSE_POLICY_VIOLATED, strconv.Itoa(failures), ctx.LicensePolicyFile)
+	}
+	return nil
+}
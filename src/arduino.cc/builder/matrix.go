@@ -0,0 +1,259 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package builder
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+	"arduino.cc/builder/utils"
+)
+
+var MATRIX_FOLDER_INVALID_CHARS = regexp.MustCompile("[^A-Za-z0-9._-]+")
+
+// Builds the sketch for each of FQBNs, each one in its own subfolder of
+// the build path. Hardware and libraries are only loaded once. A build
+// failing doesn't stop the next ones: a table of the results is printed
+// at the end, and an error returned if any of them failed
+type Matrix struct {
+	FQBNs []string
+	// Set once the builds are done, in the order of FQBNs
+	Results []*BuildResult
+}
+
+func (s *Matrix) Run(ctx *types.Context) error {
+	logger := ctx.GetLogger()
+	if ctx.LoaderCache == nil {
+		ctx.LoaderCache = types.NewLoaderCache()
+	}
+	defer unlockBuildPath(ctx)
+
+	commands := []types.Command{
+		&GenerateBuildPathIfMissing{},
+		&EnsureBuildPathExists{},
+	}
+	if err := runCommands(ctx, commands, false); err != nil {
+		return i18n.WrapError(err)
+	}
+
+	// stdin can only be read once
+	if ctx.SketchLocation == SKETCH_ARCHIVE_STDIN {
+		mainFile, err := unpackSketchArchive(ctx, ctx.SketchLocation)
+		if err != nil {
+			return i18n.WrapError(err)
+		}
+		ctx.SketchLocation = mainFile
+	}
+
+	s.Results = nil
+	folders := make(map[string]bool)
+	failed := 0
+	for _, fqbn := range s.FQBNs {
+		if ctx.CancelContext().Err() != nil {
+			return i18n.ErrorfWithLogger(logger, constants.MSG_BUILD_CANCELED)
+		}
+
+		buildCtx := ctx.NewBuildContext()
+		buildCtx.FQBN = fqbn
+		folder := matrixBuildFolder(fqbn, folders)
+		buildCtx.BuildPath = filepath.Join(ctx.BuildPath, folder)
+		// Every build writes its own, instead of overwriting the others'
+		buildCtx.CompilationDatabasePath = ""
+		if ctx.OutputDir != "" {
+			buildCtx.OutputDir = filepath.Join(ctx.OutputDir, folder)
+		}
+		if err := utils.EnsureFolderExists(buildCtx.BuildPath); err != nil {
+			return i18n.WrapError(err)
+		}
+
+		logger.Println(constants.LOG_LEVEL_INFO, constants.MSG_MATRIX_BUILD_STARTED, fqbn, buildCtx.BuildPath)
+		builder := &Builder{}
+		err := builder.Run(buildCtx)
+		if ctx.CancelContext().Err() != nil {
+			return i18n.ErrorfWithLogger(logger, constants.MSG_BUILD_CANCELED)
+		}
+		if err != nil {
+			failed++
+			logger.Fprintln(os.Stderr, constants.LOG_LEVEL_ERROR, constants.MSG_MATRIX_BUILD_FAILED, fqbn, err.Error())
+		}
+		s.Results = append(s.Results, builder.Result)
+	}
+
+	printMatrixResults(logger, s.Results)
+
+	bytes, err := json.MarshalIndent(s.Results, "", "  ")
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+	if err := utils.WriteFileBytes(filepath.Join(ctx.BuildPath, constants.FILE_MATRIX_RESULT), bytes); err != nil {
+		return i18n.WrapError(err)
+	}
+
+	if failed > 0 {
+		return i18n.ErrorfWithLogger(logger, constants.MSG_MATRIX_FAILED, strconv.Itoa(failed), strconv.Itoa(len(s.FQBNs)))
+	}
+	return nil
+}
+
+// The subfolder of the build path the build for fqbn goes in, named
+// after it. used holds the names already taken
+func matrixBuildFolder(fqbn string, used map[string]bool) string {
+	name := strings.Trim(MATRIX_FOLDER_INVALID_CHARS.ReplaceAllString(fqbn, "_"), "_")
+	folder := name
+	for i := 2; used[folder]; i++ {
+		folder = name + "_" + strconv.Itoa(i)
+	}
+	used[folder] = true
+	return folder
+}
+
+func printMatrixResults(logger i18n.Logger, results []*BuildResult) {
+	if _, ok := logger.(i18n.EventLogger); ok {
+		for _, result := range results {
+			i18n.LogEvent(logger, os.Stdout, constants.EVENT_MATRIX_RESULT, map[string]interface{}{
+				"fqbn":       result.FQBN,
+				"success":    result.Success,
+				"error":      result.Error,
+				"build_path": result.BuildPath,
+				"size":       result.Size,
+			})
+		}
+		return
+	}
+
+	rows := [][]string{{constants.MSG_MATRIX_BOARD, constants.MSG_MATRIX_RESULT, constants.MSG_MATRIX_PROGRAM_SIZE, constants.MSG_MATRIX_DATA_SIZE}}
+	for _, result := range results {
+		row := []string{result.FQBN, constants.MSG_MATRIX_PASSED, "-", "-"}
+		if !result.Success {
+			row[1] = constants.MSG_MATRIX_FAILED_RESULT
+		}
+		if size := result.Size; size != nil {
+			row[2] = matrixSize(size.Text, size.MaxText)
+			if size.Data >= 0 {
+				row[3] = matrixSize(size.Data, size.MaxData)
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for idx, cell := range row {
+			if width := utf8.RuneCountInString(cell); width > widths[idx] {
+				widths[idx] = width
+			}
+		}
+	}
+	for _, row := range rows {
+		cells := make([]interface{}, len(row))
+		for idx, cell := range row {
+			if idx < len(row)-1 {
+				cell += strings.Repeat(" ", widths[idx]-utf8.RuneCountInString(cell))
+			}
+			cells[idx] = cell
+		}
+		logger.Println(constants.LOG_LEVEL_INFO, constants.MSG_MATRIX_ROW, cells...)
+	}
+}
+
+func matrixSize(size int, maxSize int) string {
+	if maxSize > 0 {
+		return fmt.Sprintf("%d/%d", size, maxSize)
+	}
+	return strconv.Itoa(size)
+}
+
+// Reads the boards to build for from a matrix file. Each line holds an
+// FQBN, optionally followed by menu options separated by spaces:
+//
+//	arduino:avr:uno
+//	arduino:avr:mega cpu=atmega1280
+//	# arduino:avr:nano:cpu=atmega328old
+//
+// Empty lines and lines starting with # are ignored
+func ReadMatrixFile(path string, logger i18n.Logger) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, i18n.WrapError(err)
+	}
+	defer file.Close()
+
+	fqbns := []string{}
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		fqbn := fields[0]
+		parts := strings.Split(fqbn, ":")
+		if len(parts) < 3 || len(parts) > 4 {
+			return nil, i18n.ErrorfWithLogger(logger, constants.MSG_MATRIX_FILE_INVALID_LINE, path, strconv.Itoa(lineNumber), line)
+		}
+		for idx, option := range fields[1:] {
+			if !strings.Contains(option, "=") {
+				return nil, i18n.ErrorfWithLogger(logger, constants.MSG_MATRIX_FILE_INVALID_LINE, path, strconv.Itoa(lineNumber), line)
+			}
+			if idx == 0 && len(parts) == 3 {
+				fqbn += ":" + option
+			} else {
+				fqbn += "," + option
+			}
+		}
+		fqbns = append(fqbns, fqbn)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, i18n.WrapError(err)
+	}
+
+	return fqbns, nil
+}
+
+func RunMatrix(ctx *types.Context, fqbns []string) error {
+	command := Matrix{FQBNs: fqbns}
+	return command.Run(ctx)
+}
+
+func RunMatrixWithContext(cancelContext context.Context, ctx *types.Context, fqbns []string) error {
+	ctx.SetCancelContext(cancelContext)
+	return RunMatrix(ctx, fqbns)
+}
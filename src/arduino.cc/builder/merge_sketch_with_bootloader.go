@@ -42,6 +42,9 @@ import (
 type MergeSketchWithBootloader struct{}
 
 func (s *MergeSketchWithBootloader) Run(ctx *types.Context) error {
+	if ctx.DryRun {
+		return nil
+	}
 	buildProperties := ctx.BuildProperties
 	if !utils.MapStringStringHas(buildProperties, constants.BUILD_PROPERTIES_BOOTLOADER_NOBLINK) && !utils.MapStringStringHas(buildProperties, constants.BUILD_PROPERTIES_BOOTLOADER_FILE) {
 		return nil
@@ -81,8 +84,12 @@ func (s *MergeSketchWithBootloader) Run(ctx *types.Context) error {
 	mergedSketchPath := filepath.Join(filepath.Dir(builtSketchPath), sketchFileName+".with_bootloader.hex")
 
 	err := merge(builtSketchPath, bootloaderPath, mergedSketchPath)
+	if err != nil {
+		return err
+	}
+	ctx.AddProjectOutput(mergedSketchPath)
 
-	return err
+	return nil
 }
 
 func hexLineOnlyContainsFF(line string) bool {
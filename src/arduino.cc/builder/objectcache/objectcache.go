@@ -0,0 +1,212 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+/*
+
+Object cache
+
+A Cache stores what compiling produces, object files and archives, under
+a key computed from everything that went into producing it, so that
+builds in other build paths, or on other machines, reuse it instead of
+compiling again.
+
+Caches are either a local folder (see Dir), possibly shared by many
+builds at once, or a server storing files with HTTP GET and PUT
+requests (see HTTP). Open picks one from a location.
+
+*/
+
+package objectcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type Cache interface {
+	// Returns the data stored under key, or nil if there is none
+	Get(key string) ([]byte, error)
+	Put(key string, data []byte) error
+}
+
+// Returns the cache at location: either an http:// or https:// URL, or
+// a folder
+func Open(location string) Cache {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		return &HTTP{URL: location}
+	}
+	return &Dir{Folder: location}
+}
+
+// Computes keys: every value written is part of the key
+type KeyHash struct {
+	hash hash.Hash
+}
+
+func NewKeyHash() *KeyHash {
+	return &KeyHash{hash: sha256.New()}
+}
+
+func (h *KeyHash) WriteString(value string) {
+	// Values are separated, so that "ab", "c" and "a", "bc" differ
+	fmt.Fprintf(h.hash, "%d:%s", len(value), value)
+}
+
+func (h *KeyHash) WriteFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(h.hash, "%d:", info.Size())
+	_, err = io.Copy(h.hash, file)
+	return err
+}
+
+func (h *KeyHash) Key() string {
+	return hex.EncodeToString(h.hash.Sum(nil))
+}
+
+// Stores files in Folder, in subfolders named after the first two
+// characters of their keys
+type Dir struct {
+	Folder string
+}
+
+func (c *Dir) Get(key string) ([]byte, error) {
+	data, err := ioutil.ReadFile(c.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (c *Dir) Put(key string, data []byte) error {
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), os.FileMode(0755)); err != nil {
+		return err
+	}
+
+	// Other builds may be reading it: it's replaced once complete
+	file, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), os.FileMode(0644))
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+func (c *Dir) path(key string) string {
+	if len(key) < 2 {
+		return filepath.Join(c.Folder, key)
+	}
+	return filepath.Join(c.Folder, key[:2], key)
+}
+
+const HTTP_TIMEOUT = 30 * time.Second
+
+// Stores files on a server: the data of a key is read with a GET
+// request to URL/key, which returns 404 if there's none, and written
+// with a PUT request to the same URL
+type HTTP struct {
+	URL string
+	// A client with HTTP_TIMEOUT is used if nil
+	Client *http.Client
+}
+
+func (c *HTTP) Get(key string) ([]byte, error) {
+	response, err := c.client().Get(c.keyURL(key))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", c.keyURL(key), response.Status)
+	}
+	return ioutil.ReadAll(response.Body)
+}
+
+func (c *HTTP) Put(key string, data []byte) error {
+	request, err := http.NewRequest(http.MethodPut, c.keyURL(key), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	response, err := c.client().Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("PUT %s: %s", c.keyURL(key), response.Status)
+	}
+	return nil
+}
+
+func (c *HTTP) keyURL(key string) string {
+	return strings.TrimSuffix(c.URL, "/") + "/" + key
+}
+
+func (c *HTTP) client() *http.Client {
+	if c.Client != nil {
+		return c.Client
+	}
+	return &http.Client{Timeout: HTTP_TIMEOUT}
+}
@@ -0,0 +1,191 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+	"arduino.cc/builder/utils"
+)
+
+const DEFAULT_OUTPUT_NAME = "{project}"
+
+const OUTPUT_NAME_PROJECT = "project"
+const OUTPUT_NAME_BOARD = "board"
+const OUTPUT_NAME_VENDOR = "vendor"
+const OUTPUT_NAME_ARCH = "arch"
+
+var OUTPUT_NAME_INVALID_CHARS = regexp.MustCompile(`[/\\:*?"<>|]+`)
+var OUTPUT_NAME_UNSET_PROPERTY = regexp.MustCompile(`{[^{}]*}`)
+
+// Copies the artifacts of the build, the files named after
+// build.project_name that the link and objcopy recipes wrote in the
+// build path, to ctx.OutputDir, naming them after the ctx.OutputName
+// template instead, and lists them in the manifest.json of ctx.OutputDir.
+// Files left by previous builds, and the ones describing the build like
+// the bill of materials, aren't exported
+type OutputDirExporter struct {
+	SketchError bool
+}
+
+// The files exported to an output folder, by any build
+type OutputDirManifest struct {
+	Files []*OutputDirManifestFile `json:"files"`
+}
+
+type OutputDirManifestFile struct {
+	// Relative to the output folder
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	Sketch string `json:"sketch"`
+	FQBN   string `json:"fqbn"`
+}
+
+func (s *OutputDirExporter) Run(ctx *types.Context) error {
+	if ctx.OutputDir == constants.EMPTY_STRING || s.SketchError || ctx.DryRun {
+		return nil
+	}
+	logger := ctx.GetLogger()
+
+	name, err := OutputName(ctx)
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+	if err := utils.EnsureFolderExists(ctx.OutputDir); err != nil {
+		return i18n.WrapError(err)
+	}
+
+	projectName := ctx.BuildProperties[constants.BUILD_PROPERTIES_BUILD_PROJECT_NAME]
+	var exported []*OutputDirManifestFile
+	for _, artifact := range ctx.ProjectOutputs() {
+		bytes, err := ioutil.ReadFile(artifact)
+		if err != nil {
+			return i18n.WrapError(err)
+		}
+		exportedName := name + strings.TrimPrefix(filepath.Base(artifact), projectName)
+		if ctx.Verbose {
+			logger.Println(constants.LOG_LEVEL_INFO, constants.MSG_EXPORTING_ARTIFACT, artifact, filepath.Join(ctx.OutputDir, exportedName))
+		}
+		if err := utils.WriteFileBytes(filepath.Join(ctx.OutputDir, exportedName), bytes); err != nil {
+			return i18n.WrapError(err)
+		}
+		hash := sha256.Sum256(bytes)
+		exported = append(exported, &OutputDirManifestFile{
+			Name:   exportedName,
+			Size:   int64(len(bytes)),
+			SHA256: hex.EncodeToString(hash[:]),
+			Sketch: ctx.SketchLocation,
+			FQBN:   ctx.FQBN,
+		})
+	}
+
+	return i18n.WrapError(updateOutputDirManifest(ctx.OutputDir, exported))
+}
+
+// The name the artifacts are exported with, before their extension:
+// ctx.OutputName, DEFAULT_OUTPUT_NAME if empty, with the build properties
+// and {project} (the sketch name), {board}, {vendor} and {arch} expanded
+func OutputName(ctx *types.Context) (string, error) {
+	template := ctx.OutputName
+	if template == constants.EMPTY_STRING {
+		template = DEFAULT_OUTPUT_NAME
+	}
+
+	projectName := ctx.BuildProperties[constants.BUILD_PROPERTIES_BUILD_PROJECT_NAME]
+	properties := ctx.BuildProperties.Clone()
+	properties[OUTPUT_NAME_PROJECT] = strings.TrimSuffix(projectName, filepath.Ext(projectName))
+	if ctx.TargetBoard != nil {
+		properties[OUTPUT_NAME_BOARD] = ctx.TargetBoard.BoardId
+	}
+	if ctx.TargetPackage != nil {
+		properties[OUTPUT_NAME_VENDOR] = ctx.TargetPackage.PackageId
+	}
+	if ctx.TargetPlatform != nil {
+		properties[OUTPUT_NAME_ARCH] = ctx.TargetPlatform.PlatformId
+	}
+
+	name := properties.ExpandPropsInString(template)
+	if unset := OUTPUT_NAME_UNSET_PROPERTY.FindString(name); unset != constants.EMPTY_STRING {
+		return constants.EMPTY_STRING, i18n.ErrorfWithLogger(ctx.GetLogger(), constants.MSG_OUTPUT_NAME_UNSET_PROPERTY, template, unset)
+	}
+	return OUTPUT_NAME_INVALID_CHARS.ReplaceAllString(name, "_"), nil
+}
+
+// Add the exported files to the manifest of outputDir, replacing the
+// entries with the same names, and dropping the ones of deleted files
+func updateOutputDirManifest(outputDir string, exported []*OutputDirManifestFile) error {
+	manifestPath := filepath.Join(outputDir, constants.FILE_OUTPUT_DIR_MANIFEST)
+	manifest := &OutputDirManifest{}
+	if bytes, err := ioutil.ReadFile(manifestPath); err == nil {
+		// A manifest that can't be read is replaced
+		json.Unmarshal(bytes, manifest)
+	} else if !os.IsNotExist(err) {
+		return i18n.WrapError(err)
+	}
+
+	files := make(map[string]*OutputDirManifestFile)
+	for _, file := range manifest.Files {
+		if file == nil {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(outputDir, file.Name)); err == nil {
+			files[file.Name] = file
+		}
+	}
+	for _, file := range exported {
+		files[file.Name] = file
+	}
+
+	manifest.Files = []*OutputDirManifestFile{}
+	for _, file := range files {
+		manifest.Files = append(manifest.Files, file)
+	}
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Name < manifest.Files[j].Name
+	})
+
+	bytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+	return utils.WriteFileBytes(manifestPath, bytes)
+}
@@ -0,0 +1,144 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package phases
+
+import (
+	"debug/elf"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+	"arduino.cc/properties"
+)
+
+// The sections counted in each size on AVR, the same ones as the
+// recipe.size.regex of the AVR platform
+var AVR_SIZE_SECTIONS_TEXT = []string{".text", ".data", ".bootloader"}
+var AVR_SIZE_SECTIONS_DATA = []string{".data", ".bss", ".noinit"}
+var AVR_SIZE_SECTIONS_EEPROM = []string{".eeprom"}
+
+// The sections counted in each size on ARM: the .heap and .stack ones
+// that linker scripts reserve aren't counted as used RAM
+var ARM_SIZE_SECTIONS_TEXT = []string{".text", ".data", ".rodata", ".ARM.exidx"}
+var ARM_SIZE_SECTIONS_DATA = []string{".data", ".bss"}
+
+// Whether the size is read from the .elf file by the builder instead of
+// by recipe.size.pattern: when build.size.native is true, or the recipe
+// or its regexp are missing
+func useELFSizer(properties properties.Map) bool {
+	return properties[constants.BUILD_PROPERTIES_BUILD_SIZE_NATIVE] == "true" ||
+		properties[constants.RECIPE_SIZE_PATTERN] == constants.EMPTY_STRING ||
+		properties[constants.RECIPE_SIZE_REGEXP] == constants.EMPTY_STRING
+}
+
+// Read the sizes of the sections of the linked .elf file. The sections
+// counted in the text (flash), data (RAM) and eeprom sizes are the ones
+// listed in build.size.sections.text, .data and .eeprom, separated by
+// spaces or commas, with * matching any characters. When not listed, AVR
+// and ARM ones are counted like their platforms do, while on other
+// architectures allocated sections with contents count as text and
+// writable ones as data
+func elfSize(ctx *types.Context, properties properties.Map) (*types.SketchSize, error) {
+	elfFile := filepath.Join(properties[constants.BUILD_PROPERTIES_BUILD_PATH], properties[constants.BUILD_PROPERTIES_BUILD_PROJECT_NAME]+".elf")
+	file, err := elf.Open(elfFile)
+	if err != nil {
+		return nil, i18n.WrapError(err)
+	}
+	defer file.Close()
+
+	var textDefault, dataDefault, eepromDefault []string
+	switch file.Machine {
+	case elf.EM_AVR:
+		textDefault, dataDefault, eepromDefault = AVR_SIZE_SECTIONS_TEXT, AVR_SIZE_SECTIONS_DATA, AVR_SIZE_SECTIONS_EEPROM
+	case elf.EM_ARM:
+		textDefault, dataDefault = ARM_SIZE_SECTIONS_TEXT, ARM_SIZE_SECTIONS_DATA
+	}
+	textSections := sizeSections(properties[constants.BUILD_PROPERTIES_BUILD_SIZE_SECTIONS_TEXT], textDefault)
+	dataSections := sizeSections(properties[constants.BUILD_PROPERTIES_BUILD_SIZE_SECTIONS_DATA], dataDefault)
+	eepromSections := sizeSections(properties[constants.BUILD_PROPERTIES_BUILD_SIZE_SECTIONS_EEPROM], eepromDefault)
+
+	size := &types.SketchSize{}
+	for _, section := range file.Sections {
+		if section.Type == elf.SHT_NULL {
+			continue
+		}
+		allocated := section.Flags&elf.SHF_ALLOC != 0
+		sectionSize := types.SectionSize{
+			Name:    section.Name,
+			Address: section.Addr,
+			Size:    int(section.Size),
+			Text:    matchesSizeSections(section.Name, textSections, allocated && section.Type != elf.SHT_NOBITS),
+			Data:    matchesSizeSections(section.Name, dataSections, allocated && section.Flags&elf.SHF_WRITE != 0),
+			Eeprom:  matchesSizeSections(section.Name, eepromSections, false),
+		}
+		if !sectionSize.Text && !sectionSize.Data && !sectionSize.Eeprom {
+			continue
+		}
+		if sectionSize.Text {
+			size.Text += sectionSize.Size
+		}
+		if sectionSize.Data {
+			size.Data += sectionSize.Size
+		}
+		if sectionSize.Eeprom {
+			size.Eeprom += sectionSize.Size
+		}
+		size.Sections = append(size.Sections, sectionSize)
+	}
+	return size, nil
+}
+
+// The section name patterns listed in value, byDefault if empty
+func sizeSections(value string, byDefault []string) []string {
+	sections := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+	if len(sections) > 0 {
+		return sections
+	}
+	return byDefault
+}
+
+// Whether name matches one of the patterns, or byDefault when there are
+// none
+func matchesSizeSections(name string, patterns []string, byDefault bool) bool {
+	if len(patterns) == 0 {
+		return byDefault
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
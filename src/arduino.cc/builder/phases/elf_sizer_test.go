@@ -0,0 +1,220 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package phases
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/types"
+	"arduino.cc/properties"
+	"github.com/stretchr/testify/require"
+)
+
+// Compiles, with the gcc of the host, an object file with 12 bytes of
+// data, 20 of bss, 8 of read-only data and 10 in an .eeprom section, no
+// code, as {build.path}/sketch.ino.elf
+func compileSizerTestElf(t *testing.T) properties.Map {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	buildPath, err := ioutil.TempDir("", "elf_sizer")
+	require.NoError(t, err)
+
+	source := filepath.Join(buildPath, "sketch.c")
+	require.NoError(t, ioutil.WriteFile(source, []byte(`
+int data[3] = {1, 2, 3};
+int bss[5];
+const int rodata[2] = {1, 2};
+__attribute__((section(".eeprom"))) char eeprom[10] = {1};
+`), os.FileMode(0644)))
+	output, err := exec.Command("gcc", "-c", "-O0", "-fno-common", "-fno-asynchronous-unwind-tables", source, "-o", filepath.Join(buildPath, "sketch.ino.elf")).CombinedOutput()
+	require.NoError(t, err, string(output))
+
+	return properties.Map{
+		constants.BUILD_PROPERTIES_BUILD_PATH:         buildPath,
+		constants.BUILD_PROPERTIES_BUILD_PROJECT_NAME: "sketch.ino",
+	}
+}
+
+func TestELFSizerWithSections(t *testing.T) {
+	buildProperties := compileSizerTestElf(t)
+	defer os.RemoveAll(buildProperties[constants.BUILD_PROPERTIES_BUILD_PATH])
+	buildProperties[constants.BUILD_PROPERTIES_BUILD_SIZE_SECTIONS_TEXT] = ".text, .data, .ro*"
+	buildProperties[constants.BUILD_PROPERTIES_BUILD_SIZE_SECTIONS_DATA] = ".data .bss .noinit"
+	buildProperties[constants.BUILD_PROPERTIES_BUILD_SIZE_SECTIONS_EEPROM] = ".eeprom"
+
+	size, err := elfSize(&types.Context{}, buildProperties)
+	require.NoError(t, err)
+	require.Equal(t, 20, size.Text)
+	require.Equal(t, 32, size.Data)
+	require.Equal(t, 10, size.Eeprom)
+
+	sections := make(map[string]types.SectionSize)
+	for _, section := range size.Sections {
+		sections[section.Name] = section
+	}
+	require.Equal(t, types.SectionSize{Name: ".data", Size: 12, Text: true, Data: true}, sections[".data"])
+	require.Equal(t, types.SectionSize{Name: ".eeprom", Size: 10, Eeprom: true}, sections[".eeprom"])
+	require.NotContains(t, sections, ".comment")
+}
+
+func TestELFSizerDefaultSections(t *testing.T) {
+	buildProperties := compileSizerTestElf(t)
+	defer os.RemoveAll(buildProperties[constants.BUILD_PROPERTIES_BUILD_PATH])
+
+	size, err := elfSize(&types.Context{}, buildProperties)
+	require.NoError(t, err)
+	// Writable sections are data, the .eeprom one too when it isn't
+	// an AVR .elf
+	require.Equal(t, 42, size.Data)
+	require.Equal(t, 0, size.Eeprom)
+
+	sections := make(map[string]types.SectionSize)
+	for _, section := range size.Sections {
+		sections[section.Name] = section
+	}
+	require.True(t, sections[".rodata"].Text)
+	require.False(t, sections[".rodata"].Data)
+	require.True(t, sections[".data"].Text)
+	require.True(t, sections[".data"].Data)
+	require.False(t, sections[".bss"].Text)
+	require.True(t, sections[".bss"].Data)
+	require.NotContains(t, sections, ".comment")
+}
+
+// Not defined by debug/elf
+const SHT_ARM_EXIDX = elf.SectionType(0x70000001)
+
+type testELFSection struct {
+	name  string
+	kind  elf.SectionType
+	flags elf.SectionFlag
+	size  uint32
+}
+
+// Writes, as {build.path}/sketch.ino.elf, a 32 bits little endian .elf
+// file for machine with only the given sections, filled with zeros
+func writeSizerTestElf(t *testing.T, machine elf.Machine, sections []testELFSection) properties.Map {
+	buildPath, err := ioutil.TempDir("", "elf_sizer")
+	require.NoError(t, err)
+
+	sections = append([]testELFSection{{}}, append(sections, testELFSection{name: ".shstrtab", kind: elf.SHT_STRTAB})...)
+	names := []byte{0}
+	headers := make([]elf.Section32, len(sections))
+	for i, section := range sections[1:] {
+		headers[i+1].Name = uint32(len(names))
+		names = append(append(names, section.name...), 0)
+	}
+	sections[len(sections)-1].size = uint32(len(names))
+
+	var contents bytes.Buffer
+	offset := uint32(binary.Size(elf.Header32{}))
+	for i, section := range sections[1:] {
+		headers[i+1].Type = uint32(section.kind)
+		headers[i+1].Flags = uint32(section.flags)
+		headers[i+1].Off = offset + uint32(contents.Len())
+		headers[i+1].Size = section.size
+		switch section.kind {
+		case elf.SHT_NOBITS:
+		case elf.SHT_STRTAB:
+			contents.Write(names)
+		default:
+			contents.Write(make([]byte, section.size))
+		}
+	}
+
+	header := elf.Header32{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(machine),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     offset + uint32(contents.Len()),
+		Ehsize:    uint16(offset),
+		Shentsize: uint16(binary.Size(elf.Section32{})),
+		Shnum:     uint16(len(headers)),
+		Shstrndx:  uint16(len(headers) - 1),
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS32)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	var file bytes.Buffer
+	require.NoError(t, binary.Write(&file, binary.LittleEndian, header))
+	file.Write(contents.Bytes())
+	require.NoError(t, binary.Write(&file, binary.LittleEndian, headers))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(buildPath, "sketch.ino.elf"), file.Bytes(), os.FileMode(0644)))
+
+	return properties.Map{
+		constants.BUILD_PROPERTIES_BUILD_PATH:         buildPath,
+		constants.BUILD_PROPERTIES_BUILD_PROJECT_NAME: "sketch.ino",
+	}
+}
+
+func TestELFSizerARMDefaultSections(t *testing.T) {
+	buildProperties := writeSizerTestElf(t, elf.EM_ARM, []testELFSection{
+		{".text", elf.SHT_PROGBITS, elf.SHF_ALLOC | elf.SHF_EXECINSTR, 100},
+		{".ARM.exidx", SHT_ARM_EXIDX, elf.SHF_ALLOC | elf.SHF_LINK_ORDER, 8},
+		{".rodata", elf.SHT_PROGBITS, elf.SHF_ALLOC, 16},
+		{".data", elf.SHT_PROGBITS, elf.SHF_ALLOC | elf.SHF_WRITE, 12},
+		{".bss", elf.SHT_NOBITS, elf.SHF_ALLOC | elf.SHF_WRITE, 20},
+		{".heap", elf.SHT_NOBITS, elf.SHF_ALLOC | elf.SHF_WRITE, 4096},
+		{".stack", elf.SHT_NOBITS, elf.SHF_ALLOC | elf.SHF_WRITE, 2048},
+	})
+	defer os.RemoveAll(buildProperties[constants.BUILD_PROPERTIES_BUILD_PATH])
+
+	size, err := elfSize(&types.Context{}, buildProperties)
+	require.NoError(t, err)
+	require.Equal(t, 136, size.Text)
+	require.Equal(t, 32, size.Data)
+	require.Equal(t, 0, size.Eeprom)
+
+	sections := make(map[string]types.SectionSize)
+	for _, section := range size.Sections {
+		sections[section.Name] = section
+	}
+	require.True(t, sections[".ARM.exidx"].Text)
+	require.NotContains(t, sections, ".heap")
+	require.NotContains(t, sections, ".stack")
+}
+
+func TestELFSizerIsUsedWithoutSizeRecipe(t *testing.T) {
+	require.True(t, useELFSizer(properties.Map{}))
+	require.True(t, useELFSizer(properties.Map{constants.RECIPE_SIZE_PATTERN: "size"}))
+	require.False(t, useELFSizer(properties.Map{constants.RECIPE_SIZE_PATTERN: "size", constants.RECIPE_SIZE_REGEXP: "text"}))
+	require.True(t, useELFSizer(properties.Map{constants.RECIPE_SIZE_PATTERN: "size", constants.RECIPE_SIZE_REGEXP: "text", constants.BUILD_PROPERTIES_BUILD_SIZE_NATIVE: "true"}))
+}
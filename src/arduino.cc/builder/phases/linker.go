@@ -31,6 +31,7 @@ package phases
 
 import (
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"arduino.cc/builder/builder_utils"
@@ -49,9 +50,14 @@ func (s *Linker) Run(ctx *types.Context) error {
 	objectFilesCore := ctx.CoreObjectsFiles
 
 	var objectFiles []string
-	objectFiles = append(objectFiles, objectFilesSketch...)
-	objectFiles = append(objectFiles, objectFilesLibraries...)
-	objectFiles = append(objectFiles, objectFilesCore...)
+	for _, files := range [][]string{objectFilesSketch, objectFilesLibraries, objectFilesCore} {
+		files = append([]string(nil), files...)
+		if ctx.Reproducible {
+			// Not in the order they were found
+			sort.Strings(files)
+		}
+		objectFiles = append(objectFiles, files...)
+	}
 
 	coreArchiveFilePath := ctx.CoreArchiveFilePath
 	buildPath := ctx.BuildPath
@@ -61,11 +67,10 @@ func (s *Linker) Run(ctx *types.Context) error {
 	}
 
 	buildProperties := ctx.BuildProperties
-	verbose := ctx.Verbose
-	warningsLevel := ctx.WarningsLevel
-	logger := ctx.GetLogger()
 
-	err = link(objectFiles, coreDotARelPath, coreArchiveFilePath, buildProperties, verbose, warningsLevel, logger)
+	err = builder_utils.RunWithTemporaryProjectName(ctx, buildProperties, nil, func(buildProperties properties.Map) error {
+		return link(ctx, objectFiles, coreDotARelPath, coreArchiveFilePath, buildProperties)
+	})
 	if err != nil {
 		return i18n.WrapError(err)
 	}
@@ -73,7 +78,7 @@ func (s *Linker) Run(ctx *types.Context) error {
 	return nil
 }
 
-func link(objectFiles []string, coreDotARelPath string, coreArchiveFilePath string, buildProperties properties.Map, verbose bool, warningsLevel string, logger i18n.Logger) error {
+func link(ctx *types.Context, objectFiles []string, coreDotARelPath string, coreArchiveFilePath string, buildProperties properties.Map) error {
 	optRelax := addRelaxTrickIfATMEGA2560(buildProperties)
 
 	objectFiles = utils.Map(objectFiles, wrapWithDoubleQuotes)
@@ -81,12 +86,12 @@ func link(objectFiles []string, coreDotARelPath string, coreArchiveFilePath stri
 
 	properties := buildProperties.Clone()
 	properties[constants.BUILD_PROPERTIES_COMPILER_C_ELF_FLAGS] = properties[constants.BUILD_PROPERTIES_COMPILER_C_ELF_FLAGS] + optRelax
-	properties[constants.BUILD_PROPERTIES_COMPILER_WARNING_FLAGS] = properties[constants.BUILD_PROPERTIES_COMPILER_WARNING_FLAGS+"."+warningsLevel]
+	properties[constants.BUILD_PROPERTIES_COMPILER_WARNING_FLAGS] = properties[constants.BUILD_PROPERTIES_COMPILER_WARNING_FLAGS+"."+ctx.WarningsLevel]
 	properties[constants.BUILD_PROPERTIES_ARCHIVE_FILE] = coreDotARelPath
 	properties[constants.BUILD_PROPERTIES_ARCHIVE_FILE_PATH] = coreArchiveFilePath
 	properties[constants.BUILD_PROPERTIES_OBJECT_FILES] = objectFileList
 
-	_, err := builder_utils.ExecRecipe(properties, constants.RECIPE_C_COMBINE_PATTERN, false, verbose, verbose, logger)
+	_, err := builder_utils.ExecRecipe(ctx, properties, constants.RECIPE_C_COMBINE_PATTERN, false, ctx.Verbose, ctx.Verbose)
 	return err
 }
 
@@ -31,6 +31,7 @@ package phases
 
 import (
 	"errors"
+	"os"
 	"regexp"
 	"strconv"
 
@@ -52,11 +53,8 @@ func (s *Sizer) Run(ctx *types.Context) error {
 	}
 
 	buildProperties := ctx.BuildProperties
-	verbose := ctx.Verbose
-	warningsLevel := ctx.WarningsLevel
-	logger := ctx.GetLogger()
 
-	err := checkSize(buildProperties, verbose, warningsLevel, logger)
+	err := checkSize(ctx, buildProperties)
 	if err != nil {
 		return i18n.WrapError(err)
 	}
@@ -64,10 +62,11 @@ func (s *Sizer) Run(ctx *types.Context) error {
 	return nil
 }
 
-func checkSize(buildProperties properties.Map, verbose bool, warningsLevel string, logger i18n.Logger) error {
+func checkSize(ctx *types.Context, buildProperties properties.Map) error {
+	logger := ctx.GetLogger()
 
 	properties := buildProperties.Clone()
-	properties[constants.BUILD_PROPERTIES_COMPILER_WARNING_FLAGS] = properties[constants.BUILD_PROPERTIES_COMPILER_WARNING_FLAGS+"."+warningsLevel]
+	properties[constants.BUILD_PROPERTIES_COMPILER_WARNING_FLAGS] = properties[constants.BUILD_PROPERTIES_COMPILER_WARNING_FLAGS+"."+ctx.WarningsLevel]
 
 	maxTextSizeString := properties[constants.PROPERTY_UPLOAD_MAX_SIZE]
 	maxDataSizeString := properties[constants.PROPERTY_UPLOAD_MAX_DATA_SIZE]
@@ -89,11 +88,40 @@ func checkSize(buildProperties properties.Map, verbose bool, warningsLevel strin
 		}
 	}
 
-	textSize, dataSize, _, err := execSizeReceipe(properties, logger)
+	var size *types.SketchSize
+	if useELFSizer(properties) {
+		if ctx.DryRun {
+			return nil
+		}
+		size, err = elfSize(ctx, properties)
+	} else {
+		size = &types.SketchSize{}
+		size.Text, size.Data, size.Eeprom, err = execSizeReceipe(ctx, properties)
+		if ctx.DryRun {
+			return nil
+		}
+	}
 	if err != nil {
 		logger.Println(constants.LOG_LEVEL_WARN, constants.MSG_SIZER_ERROR_NO_RULE)
 		return nil
 	}
+	textSize, dataSize := size.Text, size.Data
+	size.MaxText = maxTextSize
+	size.MaxData = maxDataSize
+
+	ctx.SketchSize = size
+
+	event := map[string]interface{}{
+		"text":     textSize,
+		"max_text": maxTextSize,
+		"data":     dataSize,
+		"max_data": maxDataSize,
+		"eeprom":   size.Eeprom,
+	}
+	if size.Sections != nil {
+		event["sections"] = size.Sections
+	}
+	i18n.LogEvent(logger, os.Stdout, constants.EVENT_SIZE, event)
 
 	logger.Println(constants.LOG_LEVEL_INFO, constants.MSG_SIZER_TEXT_FULL, strconv.Itoa(textSize), strconv.Itoa(maxTextSize), strconv.Itoa(textSize*100/maxTextSize))
 	if dataSize >= 0 {
@@ -127,8 +155,8 @@ func checkSize(buildProperties properties.Map, verbose bool, warningsLevel strin
 	return nil
 }
 
-func execSizeReceipe(properties properties.Map, logger i18n.Logger) (textSize int, dataSize int, eepromSize int, resErr error) {
-	out, err := builder_utils.ExecRecipe(properties, constants.RECIPE_SIZE_PATTERN, false, false, false, logger)
+func execSizeReceipe(ctx *types.Context, properties properties.Map) (textSize int, dataSize int, eepromSize int, resErr error) {
+	out, err := builder_utils.ExecRecipe(ctx, properties, constants.RECIPE_SIZE_PATTERN, false, false, false)
 	if err != nil {
 		resErr = errors.New("Error while determining sketch size: " + err.Error())
 		return
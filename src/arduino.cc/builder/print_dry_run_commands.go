@@ -0,0 +1,66 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package builder
+
+import (
+	"os"
+
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+)
+
+// Print the commands recorded in a dry run, grouped by build phase
+type PrintDryRunCommands struct{}
+
+func (s *PrintDryRunCommands) Run(ctx *types.Context) error {
+	if !ctx.DryRun {
+		return nil
+	}
+
+	logger := ctx.GetLogger()
+	if _, ok := logger.(i18n.EventLogger); ok {
+		for _, command := range ctx.DryRunCommands() {
+			i18n.LogEvent(logger, os.Stdout, constants.EVENT_DRY_RUN_COMMAND, map[string]interface{}{"phase": command.Phase, "command_line": command.CommandLine})
+		}
+		return nil
+	}
+
+	phase := ""
+	for idx, command := range ctx.DryRunCommands() {
+		if idx == 0 || command.Phase != phase {
+			phase = command.Phase
+			logger.Fprintln(os.Stdout, constants.LOG_LEVEL_INFO, constants.MSG_DRY_RUN_PHASE, phase)
+		}
+		logger.Fprintln(os.Stdout, constants.LOG_LEVEL_INFO, constants.MSG_DRY_RUN_COMMAND, command.CommandLine)
+	}
+
+	return nil
+}
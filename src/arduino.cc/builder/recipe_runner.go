@@ -34,6 +34,7 @@ import (
 	"arduino.cc/builder/constants"
 	"arduino.cc/builder/i18n"
 	"arduino.cc/builder/types"
+	"arduino.cc/properties"
 	"os"
 	"sort"
 	"strings"
@@ -42,6 +43,10 @@ import (
 type RecipeByPrefixSuffixRunner struct {
 	Prefix string
 	Suffix string
+	// When set, the files named after the project that the recipes
+	// write are renamed into place once they all succeed. These are the
+	// extensions of the ones they read
+	ProjectInputs []string
 }
 
 func (s *RecipeByPrefixSuffixRunner) Run(ctx *types.Context) error {
@@ -55,19 +60,23 @@ func (s *RecipeByPrefixSuffixRunner) Run(ctx *types.Context) error {
 
 	recipes := findRecipes(buildProperties, s.Prefix, s.Suffix)
 
-	properties := buildProperties.Clone()
-	for _, recipe := range recipes {
-		if ctx.DebugLevel >= 10 {
-			logger.Fprintln(os.Stdout, constants.LOG_LEVEL_DEBUG, constants.MSG_RUNNING_RECIPE, recipe)
-		}
-		_, err := builder_utils.ExecRecipe(properties, recipe, false, verbose, verbose, logger)
-		if err != nil {
-			return i18n.WrapError(err)
+	run := func(properties properties.Map) error {
+		for _, recipe := range recipes {
+			if ctx.DebugLevel >= 10 {
+				logger.Fprintln(os.Stdout, constants.LOG_LEVEL_DEBUG, constants.MSG_RUNNING_RECIPE, recipe)
+			}
+			_, err := builder_utils.ExecRecipe(ctx, properties, recipe, false, verbose, verbose)
+			if err != nil {
+				return i18n.WrapError(err)
+			}
 		}
+		return nil
 	}
 
-	return nil
-
+	if len(s.ProjectInputs) == 0 || len(recipes) == 0 {
+		return run(buildProperties)
+	}
+	return builder_utils.RunWithTemporaryProjectName(ctx, buildProperties, s.ProjectInputs, run)
 }
 
 func findRecipes(buildProperties map[string]string, patternPrefix string, patternSuffix string) []string {
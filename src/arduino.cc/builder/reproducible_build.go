@@ -0,0 +1,271 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package builder
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+)
+
+const DEFAULT_PREFIX_MAP_FLAG = "-ffile-prefix-map"
+
+// Flags of compilers that embed source paths in what they compile, like
+// debug information and __FILE__
+var PREFIX_MAPPED_FLAGS = []string{
+	constants.BUILD_PROPERTIES_COMPILER_C_EXTRA_FLAGS,
+	constants.BUILD_PROPERTIES_COMPILER_CPP_EXTRA_FLAGS,
+	constants.BUILD_PROPERTIES_COMPILER_S_EXTRA_FLAGS,
+	constants.BUILD_PROPERTIES_COMPILER_C_ELF_EXTRA_FLAGS,
+}
+
+// In reproducible builds the time properties are the ones of
+// SOURCE_DATE_EPOCH (or of the epoch, when not set), and the folders of
+// the build, of the sketch, of the hardware, of the tools, of the
+// libraries and the working folder are replaced by fixed names in what's
+// compiled, with compiler.prefix_map.flag (-ffile-prefix-map by default).
+// The resulting flags are in build.prefix_map.flags
+type SetReproducibleBuildProperties struct{}
+
+func (s *SetReproducibleBuildProperties) Run(ctx *types.Context) error {
+	if !ctx.Reproducible {
+		return nil
+	}
+	buildProperties := ctx.BuildProperties
+
+	sourceDateEpoch := int64(0)
+	if value := os.Getenv(constants.ENV_SOURCE_DATE_EPOCH); value != "" {
+		var err error
+		sourceDateEpoch, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return i18n.ErrorfWithLogger(ctx.GetLogger(), constants.MSG_SOURCE_DATE_EPOCH_INVALID, value)
+		}
+	}
+	buildProperties[constants.BUILD_PROPERTIES_EXTRA_TIME_UTC] = strconv.FormatInt(sourceDateEpoch, 10)
+	buildProperties[constants.BUILD_PROPERTIES_EXTRA_TIME_LOCAL] = strconv.FormatInt(sourceDateEpoch, 10)
+	buildProperties[constants.BUILD_PROPERTIES_EXTRA_TIME_ZONE] = "0"
+	buildProperties[constants.BUILD_PROPERTIES_EXTRA_TIME_DST] = "0"
+
+	prefixMapFlag := buildProperties[constants.BUILD_PROPERTIES_COMPILER_PREFIX_MAP_FLAG]
+	if prefixMapFlag == constants.EMPTY_STRING {
+		prefixMapFlag = DEFAULT_PREFIX_MAP_FLAG
+	}
+	var flags []string
+	for _, prefixMap := range reproduciblePrefixMaps(ctx) {
+		flags = append(flags, "\""+prefixMapFlag+"="+prefixMap[0]+"="+prefixMap[1]+"\"")
+	}
+	buildProperties[constants.BUILD_PROPERTIES_BUILD_PREFIX_MAP_FLAGS] = strings.Join(flags, constants.SPACE)
+	for _, key := range PREFIX_MAPPED_FLAGS {
+		buildProperties[key] = strings.TrimSpace(buildProperties[key] + " {" + constants.BUILD_PROPERTIES_BUILD_PREFIX_MAP_FLAGS + "}")
+	}
+
+	return nil
+}
+
+// The folders to replace, each paired with its replacement. When
+// folders are nested, the compiler uses the last one that matches: they
+// are sorted so that the innermost comes last
+func reproduciblePrefixMaps(ctx *types.Context) [][2]string {
+	replacements := make(map[string]string)
+	add := func(folder string, replacement string) {
+		if folder == constants.EMPTY_STRING {
+			return
+		}
+		if absolute, err := filepath.Abs(folder); err == nil {
+			folder = absolute
+		}
+		if _, ok := replacements[folder]; !ok {
+			replacements[folder] = replacement
+		}
+	}
+
+	add(ctx.BuildPath, "/build")
+	add(ctx.BuildProperties[constants.BUILD_PROPERTIES_SOURCE_PATH], "/sketch")
+	for _, tool := range ctx.Tools {
+		add(tool.Folder, "/tools/"+tool.Name)
+	}
+	for _, folder := range ctx.HardwareFolders {
+		add(folder, "/hardware")
+	}
+	for _, folder := range ctx.ToolsFolders {
+		add(folder, "/tools")
+	}
+	for _, folder := range append(append([]string{}, ctx.BuiltInLibrariesFolders...), ctx.OtherLibrariesFolders...) {
+		add(folder, "/libraries")
+	}
+	// Where the compiler runs, unless everything is in it
+	if cwd, err := os.Getwd(); err == nil && filepath.Dir(cwd) != cwd {
+		add(cwd, ".")
+	}
+
+	var prefixMaps [][2]string
+	for folder, replacement := range replacements {
+		prefixMaps = append(prefixMaps, [2]string{folder, replacement})
+	}
+	sort.Slice(prefixMaps, func(i, j int) bool {
+		if len(prefixMaps[i][0]) != len(prefixMaps[j][0]) {
+			return len(prefixMaps[i][0]) < len(prefixMaps[j][0])
+		}
+		return prefixMaps[i][0] < prefixMaps[j][0]
+	})
+	return prefixMaps
+}
+
+// Builds the sketch twice, reproducibly, in the build path and in a
+// temporary folder, and fails if the artifacts of the two builds differ
+type VerifyReproducibleBuild struct {
+	// Set once the builds are done
+	Results []*BuildResult
+}
+
+func (s *VerifyReproducibleBuild) Run(ctx *types.Context) error {
+	logger := ctx.GetLogger()
+	ctx.Reproducible = true
+	if ctx.LoaderCache == nil {
+		ctx.LoaderCache = types.NewLoaderCache()
+	}
+	defer unlockBuildPath(ctx)
+
+	commands := []types.Command{
+		&GenerateBuildPathIfMissing{},
+		&EnsureBuildPathExists{},
+	}
+	if err := runCommands(ctx, commands, false); err != nil {
+		return i18n.WrapError(err)
+	}
+
+	// stdin can only be read once
+	if ctx.SketchLocation == SKETCH_ARCHIVE_STDIN {
+		mainFile, err := unpackSketchArchive(ctx, ctx.SketchLocation)
+		if err != nil {
+			return i18n.WrapError(err)
+		}
+		ctx.SketchLocation = mainFile
+	}
+
+	otherBuildPath, err := ioutil.TempDir("", "arduino-reproducible-")
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+	defer os.RemoveAll(otherBuildPath)
+
+	first := &Builder{}
+	if err := first.Run(ctx); err != nil {
+		return i18n.WrapError(err)
+	}
+
+	otherCtx := ctx.NewBuildContext()
+	otherCtx.BuildPath = otherBuildPath
+	otherCtx.CompilationDatabasePath = ""
+	otherCtx.OutputDir = ""
+	// The objects must be compiled again
+	otherCtx.ObjectCache = nil
+	logger.Println(constants.LOG_LEVEL_INFO, constants.MSG_REPRODUCIBLE_SECOND_BUILD, otherBuildPath)
+	second := &Builder{}
+	if err := second.Run(otherCtx); err != nil {
+		return i18n.WrapError(err)
+	}
+	s.Results = []*BuildResult{first.Result, second.Result}
+
+	different, total, err := compareArtifacts(logger, first.Result, second.Result)
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+	if different > 0 {
+		return i18n.ErrorfWithLogger(logger, constants.MSG_REPRODUCIBLE_BUILDS_DIFFER, strconv.Itoa(different), strconv.Itoa(total))
+	}
+	logger.Println(constants.LOG_LEVEL_INFO, constants.MSG_REPRODUCIBLE_BUILDS_IDENTICAL, strconv.Itoa(total))
+	return nil
+}
+
+// Returns how many of the artifacts of the two builds, matched by name,
+// differ, and how many there are
+func compareArtifacts(logger i18n.Logger, first *BuildResult, second *BuildResult) (int, int, error) {
+	artifacts := make(map[string][2]string)
+	for _, artifact := range first.Artifacts {
+		artifacts[filepath.Base(artifact)] = [2]string{artifact, ""}
+	}
+	for _, artifact := range second.Artifacts {
+		paths := artifacts[filepath.Base(artifact)]
+		paths[1] = artifact
+		artifacts[filepath.Base(artifact)] = paths
+	}
+	var names []string
+	for name := range artifacts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	different := 0
+	for _, name := range names {
+		paths := artifacts[name]
+		if paths[0] == "" || paths[1] == "" {
+			buildPath := first.BuildPath
+			if paths[0] == "" {
+				buildPath = second.BuildPath
+			}
+			logger.Println(constants.LOG_LEVEL_WARN, constants.MSG_REPRODUCIBLE_ARTIFACT_MISSING, name, buildPath)
+			different++
+			continue
+		}
+		firstBytes, err := ioutil.ReadFile(paths[0])
+		if err != nil {
+			return 0, 0, i18n.WrapError(err)
+		}
+		secondBytes, err := ioutil.ReadFile(paths[1])
+		if err != nil {
+			return 0, 0, i18n.WrapError(err)
+		}
+		if !bytes.Equal(firstBytes, secondBytes) {
+			logger.Println(constants.LOG_LEVEL_WARN, constants.MSG_REPRODUCIBLE_ARTIFACT_DIFFERS, name, first.BuildPath, second.BuildPath)
+			different++
+		}
+	}
+	return different, len(names), nil
+}
+
+func RunVerifyReproducibleBuild(ctx *types.Context) error {
+	command := VerifyReproducibleBuild{}
+	return command.Run(ctx)
+}
+
+func RunVerifyReproducibleBuildWithContext(cancelContext context.Context, ctx *types.Context) error {
+	ctx.SetCancelContext(cancelContext)
+	return RunVerifyReproducibleBuild(ctx)
+}
@@ -0,0 +1,664 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package builder
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+	"arduino.cc/builder/utils"
+)
+
+const SBOM_SPDX = "spdx"
+const SBOM_CYCLONEDX = "cyclonedx"
+
+const SBOM_SPDX_SUFFIX = ".spdx.json"
+const SBOM_CYCLONEDX_SUFFIX = ".cdx.json"
+
+// Writes a software bill of materials of the build, listing the sketch,
+// the core, the variant, the libraries and the tools it's made of, with
+// the hashes of the compiled sources and of the artifacts, in the build
+// path as {build.project_name}.spdx.json or .cdx.json
+type SBOMSaver struct {
+	SketchError bool
+}
+
+var sbomWriters = map[string]struct {
+	suffix   string
+	document func(bom *sbom) interface{}
+}{
+	SBOM_SPDX:      {SBOM_SPDX_SUFFIX, spdxDocument},
+	SBOM_CYCLONEDX: {SBOM_CYCLONEDX_SUFFIX, cycloneDXDocument},
+}
+
+func (s *SBOMSaver) Run(ctx *types.Context) error {
+	if ctx.SBOMFormat == constants.EMPTY_STRING || s.SketchError || ctx.DryRun {
+		return nil
+	}
+	writer, ok := sbomWriters[ctx.SBOMFormat]
+	if !ok {
+		return i18n.ErrorfWithLogger(ctx.GetLogger(), constants.MSG_SBOM_FORMAT_UNKNOWN, ctx.SBOMFormat, SBOM_SPDX, SBOM_CYCLONEDX)
+	}
+
+	bom, err := collectSBOM(ctx)
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+	bytes, err := json.MarshalIndent(writer.document(bom), "", "  ")
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+	return utils.WriteFileBytes(filepath.Join(ctx.BuildPath, bom.projectName+writer.suffix), bytes)
+}
+
+// What a bill of materials lists, whatever its format
+type sbom struct {
+	projectName string
+	fqbn        string
+	created     time.Time
+	sketch      *sbomComponent
+	// The core and the variant first, then the libraries and the tools
+	components []*sbomComponent
+	// Relative to the build path
+	artifacts []*sbomFile
+}
+
+type sbomComponent struct {
+	// Unique in the document
+	ref         string
+	kind        string
+	name        string
+	version     string
+	supplier    string
+	license     string
+	url         string
+	description string
+	// Where the files of the component are listed, laid out like the
+	// Arduino folders: {name}/ for the sketch, cores/{name}/,
+	// variants/{name}/ or libraries/{name}/
+	folder string
+	// Relative to the folder of the component
+	files []*sbomFile
+}
+
+type sbomFile struct {
+	name   string
+	sha1   string
+	sha256 string
+}
+
+const (
+	sbomSketch  = "Sketch"
+	sbomCore    = "Core"
+	sbomVariant = "Variant"
+	sbomLibrary = "Library"
+	sbomTool    = "Tool"
+)
+
+func collectSBOM(ctx *types.Context) (*sbom, error) {
+	buildProperties := ctx.BuildProperties
+	projectName := buildProperties[constants.BUILD_PROPERTIES_BUILD_PROJECT_NAME]
+	// The time the build is stamped with, so that reproducible builds
+	// get the same bill of materials
+	created, err := strconv.ParseInt(buildProperties[constants.BUILD_PROPERTIES_EXTRA_TIME_UTC], 10, 64)
+	if err != nil {
+		created = time.Now().Unix()
+	}
+	bom := &sbom{
+		projectName: projectName,
+		fqbn:        ctx.FQBN,
+		created:     time.Unix(created, 0).UTC(),
+	}
+	refs := make(map[string]bool)
+	newComponent := func(kind string, name string) *sbomComponent {
+		ref := kind + "-" + sbomRefInvalidChars.ReplaceAllString(name, "-")
+		for idx := 2; refs[ref]; idx++ {
+			ref = kind + "-" + sbomRefInvalidChars.ReplaceAllString(name, "-") + "-" + strconv.Itoa(idx)
+		}
+		refs[ref] = true
+		return &sbomComponent{ref: ref, kind: kind, name: name, folder: sbomFolders[kind] + name + "/"}
+	}
+
+	bom.sketch = newComponent(sbomSketch, strings.TrimSuffix(projectName, filepath.Ext(projectName)))
+	bom.sketch.description = "Arduino sketch"
+	if ctx.Sketch != nil {
+		sketchFolder := filepath.Dir(ctx.Sketch.MainFile.Name)
+		files := append([]types.SketchFile{ctx.Sketch.MainFile}, ctx.Sketch.OtherSketchFiles...)
+		for _, file := range append(files, ctx.Sketch.AdditionalFiles...) {
+			if err := bom.sketch.addFile(sketchFolder, file.Name); err != nil {
+				return nil, i18n.WrapError(err)
+			}
+		}
+	}
+
+	// Compiled sources belong to the innermost folder containing them
+	folders := make(map[string]*sbomComponent)
+	if corePath := buildProperties[constants.BUILD_PROPERTIES_BUILD_CORE_PATH]; corePath != constants.EMPTY_STRING {
+		core := newComponent(sbomCore, filepath.Base(corePath))
+		core.description = "Arduino core"
+		core.supplier, core.version = platformSupplierAndVersion(ctx, ctx.ActualPlatform)
+		bom.components = append(bom.components, core)
+		folders[corePath] = core
+	}
+	if variantPath := buildProperties[constants.BUILD_PROPERTIES_BUILD_VARIANT_PATH]; variantPath != constants.EMPTY_STRING {
+		variant := newComponent(sbomVariant, filepath.Base(variantPath))
+		variant.description = "Arduino variant"
+		variant.supplier, variant.version = platformSupplierAndVersion(ctx, ctx.TargetPlatform)
+		bom.components = append(bom.components, variant)
+		folders[variantPath] = variant
+	}
+	for _, library := range ctx.ImportedLibraries {
+		component := newComponent(sbomLibrary, library.Name)
+		component.description = "Arduino library"
+		component.version = library.Version
+		component.supplier = library.Author
+		if library.License != constants.LIB_LICENSE_UNSPECIFIED {
+			component.license = library.License
+		}
+		component.url = library.URL
+		bom.components = append(bom.components, component)
+		folders[library.Folder] = component
+	}
+
+	var folderNames []string
+	for folder := range folders {
+		folderNames = append(folderNames, folder)
+	}
+	sort.Slice(folderNames, func(i, j int) bool {
+		return len(folderNames[i]) > len(folderNames[j])
+	})
+	for _, command := range ctx.CompileCommands() {
+		for _, folder := range folderNames {
+			if isInFolder(command.File, folder) {
+				if err := folders[folder].addFile(folder, command.File); err != nil {
+					return nil, i18n.WrapError(err)
+				}
+				break
+			}
+		}
+	}
+
+	for _, tool := range usedTools(ctx) {
+		component := newComponent(sbomTool, tool.Name)
+		component.description = "Build tool"
+		component.version = tool.Version
+		bom.components = append(bom.components, component)
+	}
+
+	for _, file := range projectArtifacts(ctx) {
+		if strings.HasSuffix(file, SBOM_SPDX_SUFFIX) || strings.HasSuffix(file, SBOM_CYCLONEDX_SUFFIX) {
+			continue
+		}
+		artifact, err := hashSBOMFile(ctx.BuildPath, file)
+		if err != nil {
+			return nil, i18n.WrapError(err)
+		}
+		bom.artifacts = append(bom.artifacts, artifact)
+	}
+
+	for _, component := range append([]*sbomComponent{bom.sketch}, bom.components...) {
+		sort.Slice(component.files, func(i, j int) bool {
+			return component.files[i].name < component.files[j].name
+		})
+	}
+	return bom, nil
+}
+
+var sbomFolders = map[string]string{
+	sbomCore:    "cores/",
+	sbomVariant: "variants/",
+	sbomLibrary: "libraries/",
+	sbomTool:    "tools/",
+}
+
+var sbomRefInvalidChars = regexp.MustCompile("[^A-Za-z0-9.-]+")
+
+func (component *sbomComponent) addFile(folder string, file string) error {
+	for _, existing := range component.files {
+		if filepath.Join(folder, filepath.FromSlash(existing.name)) == file {
+			return nil
+		}
+	}
+	hashed, err := hashSBOMFile(folder, file)
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+	component.files = append(component.files, hashed)
+	return nil
+}
+
+func isInFolder(file string, folder string) bool {
+	relativePath, err := filepath.Rel(folder, file)
+	return err == nil && relativePath != ".." && !strings.HasPrefix(relativePath, ".."+string(filepath.Separator))
+}
+
+// Hash file, naming it by its path relative to folder
+func hashSBOMFile(folder string, file string) (*sbomFile, error) {
+	name, err := filepath.Rel(folder, file)
+	if err != nil {
+		return nil, i18n.WrapError(err)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, i18n.WrapError(err)
+	}
+	defer f.Close()
+
+	sha1Hash := sha1.New()
+	sha256Hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(sha1Hash, sha256Hash), f); err != nil {
+		return nil, i18n.WrapError(err)
+	}
+	return &sbomFile{
+		name:   filepath.ToSlash(name),
+		sha1:   hex.EncodeToString(sha1Hash.Sum(nil)),
+		sha256: hex.EncodeToString(sha256Hash.Sum(nil)),
+	}, nil
+}
+
+// The package providing platform, and the version of the platform
+func platformSupplierAndVersion(ctx *types.Context, platform *types.Platform) (string, string) {
+	if platform == nil {
+		return constants.EMPTY_STRING, constants.EMPTY_STRING
+	}
+	supplier := constants.EMPTY_STRING
+	if ctx.Hardware != nil {
+		for _, aPackage := range ctx.Hardware.Packages {
+			for _, aPlatform := range aPackage.Platforms {
+				if aPlatform == platform {
+					supplier = aPackage.PackageId
+				}
+			}
+		}
+	}
+	return supplier, platform.Properties[constants.PLATFORM_VERSION]
+}
+
+// The tools whose folder is in the compilation commands or in the other
+// recipes of the build, sorted by name and version
+func usedTools(ctx *types.Context) []*types.Tool {
+	var commands []string
+	for _, command := range ctx.CompileCommands() {
+		commands = append(commands, strings.Join(command.Arguments, constants.SPACE))
+	}
+	for key, value := range ctx.BuildProperties {
+		if strings.HasPrefix(key, "recipe.") {
+			commands = append(commands, ctx.BuildProperties.ExpandPropsInString(value))
+		}
+	}
+
+	var tools []*types.Tool
+	for _, tool := range ctx.Tools {
+		for _, command := range commands {
+			if strings.Contains(command, tool.Folder) {
+				tools = append(tools, tool)
+				break
+			}
+		}
+	}
+	sort.Slice(tools, func(i, j int) bool {
+		if tools[i].Name != tools[j].Name {
+			return tools[i].Name < tools[j].Name
+		}
+		return tools[i].Version < tools[j].Version
+	})
+	return tools
+}
+
+// The identity of the document: the hash of what it describes, so that
+// the same build gets the same identity
+func (bom *sbom) hash() []byte {
+	hash := sha256.New()
+	fmt.Fprintln(hash, bom.projectName, bom.fqbn, bom.created.Unix())
+	for _, component := range append([]*sbomComponent{bom.sketch}, bom.components...) {
+		fmt.Fprintln(hash, component.ref, component.version)
+		for _, file := range component.files {
+			fmt.Fprintln(hash, file.name, file.sha256)
+		}
+	}
+	for _, file := range bom.artifacts {
+		fmt.Fprintln(hash, file.name, file.sha256)
+	}
+	return hash.Sum(nil)
+}
+
+var spdxLicenseExpression = regexp.MustCompile(`^\(?[A-Za-z0-9.+-]+\)?( (AND|OR|WITH) \(?[A-Za-z0-9.+-]+\)?)*$`)
+
+// Whether license, as found in library.properties, is an SPDX license
+// identifier or expression
+func isSPDXLicense(license string) bool {
+	return spdxLicenseExpression.MatchString(license)
+}
+
+// SPDX 2.3 documents, see https://spdx.github.io/spdx-spec/v2.3/
+
+type spdxDoc struct {
+	SPDXVersion       string              `json:"spdxVersion"`
+	DataLicense       string              `json:"dataLicense"`
+	SPDXID            string              `json:"SPDXID"`
+	Name              string              `json:"name"`
+	DocumentNamespace string              `json:"documentNamespace"`
+	Comment           string              `json:"comment,omitempty"`
+	CreationInfo      spdxCreationInfo    `json:"creationInfo"`
+	Packages          []*spdxPackage      `json:"packages"`
+	Files             []*spdxFile         `json:"files"`
+	Relationships     []*spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID                  string                   `json:"SPDXID"`
+	Name                    string                   `json:"name"`
+	VersionInfo             string                   `json:"versionInfo,omitempty"`
+	Supplier                string                   `json:"supplier,omitempty"`
+	DownloadLocation        string                   `json:"downloadLocation"`
+	Homepage                string                   `json:"homepage,omitempty"`
+	FilesAnalyzed           bool                     `json:"filesAnalyzed"`
+	PackageVerificationCode *spdxVerificationCode    `json:"packageVerificationCode,omitempty"`
+	LicenseConcluded        string                   `json:"licenseConcluded"`
+	LicenseDeclared         string                   `json:"licenseDeclared"`
+	LicenseComments         string                   `json:"licenseComments,omitempty"`
+	CopyrightText           string                   `json:"copyrightText"`
+	Description             string                   `json:"description,omitempty"`
+	HasFiles                []string                 `json:"hasFiles,omitempty"`
+	PrimaryPackagePurpose   string                   `json:"primaryPackagePurpose,omitempty"`
+	Annotations             []map[string]interface{} `json:"annotations,omitempty"`
+}
+
+type spdxVerificationCode struct {
+	Value string `json:"packageVerificationCodeValue"`
+}
+
+type spdxFile struct {
+	SPDXID           string          `json:"SPDXID"`
+	FileName         string          `json:"fileName"`
+	Checksums        []*spdxChecksum `json:"checksums"`
+	LicenseConcluded string          `json:"licenseConcluded"`
+	CopyrightText    string          `json:"copyrightText"`
+}
+
+type spdxChecksum struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"checksumValue"`
+}
+
+type spdxRelationship struct {
+	Element        string `json:"spdxElementId"`
+	Type           string `json:"relationshipType"`
+	RelatedElement string `json:"relatedSpdxElement"`
+}
+
+const spdxNoAssertion = "NOASSERTION"
+
+func spdxDocument(bom *sbom) interface{} {
+	doc := &spdxDoc{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              bom.projectName,
+		DocumentNamespace: "https://arduino.cc/spdx/" + bom.projectName + "-" + hex.EncodeToString(bom.hash()),
+		Comment:           "FQBN: " + bom.fqbn,
+		CreationInfo: spdxCreationInfo{
+			Created:  bom.created.Format(time.RFC3339),
+			Creators: []string{"Tool: arduino-builder"},
+		},
+		Packages:      []*spdxPackage{},
+		Files:         []*spdxFile{},
+		Relationships: []*spdxRelationship{},
+	}
+	addRelationship := func(element string, relationshipType string, relatedElement string) {
+		doc.Relationships = append(doc.Relationships, &spdxRelationship{Element: element, Type: relationshipType, RelatedElement: relatedElement})
+	}
+	addFile := func(prefix string, file *sbomFile) string {
+		id := fmt.Sprintf("SPDXRef-File-%d", len(doc.Files)+1)
+		doc.Files = append(doc.Files, &spdxFile{
+			SPDXID:   id,
+			FileName: "./" + prefix + file.name,
+			Checksums: []*spdxChecksum{
+				{Algorithm: "SHA1", Value: file.sha1},
+				{Algorithm: "SHA256", Value: file.sha256},
+			},
+			LicenseConcluded: spdxNoAssertion,
+			CopyrightText:    spdxNoAssertion,
+		})
+		return id
+	}
+
+	sketchID := "SPDXRef-" + bom.sketch.ref
+	addRelationship(doc.SPDXID, "DESCRIBES", sketchID)
+	for _, component := range append([]*sbomComponent{bom.sketch}, bom.components...) {
+		id := "SPDXRef-" + component.ref
+		pkg := &spdxPackage{
+			SPDXID:           id,
+			Name:             component.name,
+			VersionInfo:      component.version,
+			DownloadLocation: spdxNoAssertion,
+			Homepage:         component.url,
+			LicenseConcluded: spdxNoAssertion,
+			LicenseDeclared:  spdxNoAssertion,
+			CopyrightText:    spdxNoAssertion,
+			Description:      component.description,
+		}
+		if component.supplier != constants.EMPTY_STRING {
+			pkg.Supplier = "Organization: " + component.supplier
+			if component.kind == sbomLibrary {
+				pkg.Supplier = "Person: " + component.supplier
+			}
+		}
+		if isSPDXLicense(component.license) {
+			pkg.LicenseDeclared = component.license
+		} else if component.license != constants.EMPTY_STRING {
+			pkg.LicenseComments = "Declared as " + component.license
+		}
+		if len(component.files) > 0 {
+			pkg.FilesAnalyzed = true
+			var sha1s []string
+			for _, file := range component.files {
+				pkg.HasFiles = append(pkg.HasFiles, addFile(component.folder, file))
+				sha1s = append(sha1s, file.sha1)
+			}
+			// See the package verification code in the specification
+			sort.Strings(sha1s)
+			verificationCode := sha1.Sum([]byte(strings.Join(sha1s, "")))
+			pkg.PackageVerificationCode = &spdxVerificationCode{Value: hex.EncodeToString(verificationCode[:])}
+		}
+		doc.Packages = append(doc.Packages, pkg)
+
+		switch component.kind {
+		case sbomSketch:
+			pkg.PrimaryPackagePurpose = "SOURCE"
+		case sbomTool:
+			pkg.PrimaryPackagePurpose = "APPLICATION"
+			addRelationship(id, "BUILD_TOOL_OF", sketchID)
+		default:
+			pkg.PrimaryPackagePurpose = "LIBRARY"
+			addRelationship(sketchID, "DEPENDS_ON", id)
+		}
+	}
+
+	for _, artifact := range bom.artifacts {
+		addRelationship(addFile("build/", artifact), "GENERATED_FROM", sketchID)
+	}
+	return doc
+}
+
+// CycloneDX 1.5 documents, see https://cyclonedx.org/docs/1.5/json/
+
+type cycloneDXDoc struct {
+	BOMFormat    string                 `json:"bomFormat"`
+	SpecVersion  string                 `json:"specVersion"`
+	SerialNumber string                 `json:"serialNumber"`
+	Version      int                    `json:"version"`
+	Metadata     cycloneDXMetadata      `json:"metadata"`
+	Components   []*cycloneDXComponent  `json:"components"`
+	Dependencies []*cycloneDXDependency `json:"dependencies"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp  string               `json:"timestamp"`
+	Tools      []map[string]string  `json:"tools"`
+	Component  *cycloneDXComponent  `json:"component"`
+	Properties []*cycloneDXProperty `json:"properties"`
+}
+
+type cycloneDXComponent struct {
+	Type               string                        `json:"type"`
+	BOMRef             string                        `json:"bom-ref,omitempty"`
+	Name               string                        `json:"name"`
+	Version            string                        `json:"version,omitempty"`
+	Description        string                        `json:"description,omitempty"`
+	Author             string                        `json:"author,omitempty"`
+	Supplier           *cycloneDXSupplier            `json:"supplier,omitempty"`
+	Hashes             []*cycloneDXHash              `json:"hashes,omitempty"`
+	Licenses           []map[string]interface{}      `json:"licenses,omitempty"`
+	ExternalReferences []*cycloneDXExternalReference `json:"externalReferences,omitempty"`
+	Components         []*cycloneDXComponent         `json:"components,omitempty"`
+}
+
+type cycloneDXSupplier struct {
+	Name string `json:"name"`
+}
+
+type cycloneDXHash struct {
+	Algorithm string `json:"alg"`
+	Content   string `json:"content"`
+}
+
+type cycloneDXExternalReference struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type cycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cycloneDXDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+var cycloneDXTypes = map[string]string{
+	sbomSketch:  "firmware",
+	sbomCore:    "framework",
+	sbomVariant: "framework",
+	sbomLibrary: "library",
+	sbomTool:    "application",
+}
+
+func cycloneDXDocument(bom *sbom) interface{} {
+	// A version 5 UUID, made of the hash of the bill of materials
+	uuid := bom.hash()[:16]
+	uuid[6] = (uuid[6] & 0x0f) | 0x50
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	uuidHex := hex.EncodeToString(uuid)
+
+	files := func(prefix string, files []*sbomFile) []*cycloneDXComponent {
+		var components []*cycloneDXComponent
+		for _, file := range files {
+			components = append(components, &cycloneDXComponent{
+				Type: "file",
+				Name: prefix + file.name,
+				Hashes: []*cycloneDXHash{
+					{Algorithm: "SHA-1", Content: file.sha1},
+					{Algorithm: "SHA-256", Content: file.sha256},
+				},
+			})
+		}
+		return components
+	}
+	component := func(component *sbomComponent) *cycloneDXComponent {
+		result := &cycloneDXComponent{
+			Type:        cycloneDXTypes[component.kind],
+			BOMRef:      component.ref,
+			Name:        component.name,
+			Version:     component.version,
+			Description: component.description,
+			Components:  files(component.folder, component.files),
+		}
+		if component.kind == sbomLibrary {
+			result.Author = component.supplier
+		} else if component.supplier != constants.EMPTY_STRING {
+			result.Supplier = &cycloneDXSupplier{Name: component.supplier}
+		}
+		if isSPDXLicense(component.license) {
+			result.Licenses = []map[string]interface{}{{"expression": component.license}}
+		} else if component.license != constants.EMPTY_STRING {
+			result.Licenses = []map[string]interface{}{{"license": map[string]string{"name": component.license}}}
+		}
+		if component.url != constants.EMPTY_STRING {
+			result.ExternalReferences = []*cycloneDXExternalReference{{Type: "website", URL: component.url}}
+		}
+		return result
+	}
+
+	sketch := component(bom.sketch)
+	sketch.Components = append(sketch.Components, files("build/", bom.artifacts)...)
+	doc := &cycloneDXDoc{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + uuidHex[0:8] + "-" + uuidHex[8:12] + "-" + uuidHex[12:16] + "-" + uuidHex[16:20] + "-" + uuidHex[20:32],
+		Version:      1,
+		Metadata: cycloneDXMetadata{
+			Timestamp:  bom.created.Format(time.RFC3339),
+			Tools:      []map[string]string{{"vendor": "Arduino", "name": "arduino-builder"}},
+			Component:  sketch,
+			Properties: []*cycloneDXProperty{{Name: "arduino:fqbn", Value: bom.fqbn}},
+		},
+		Components:   []*cycloneDXComponent{},
+		Dependencies: []*cycloneDXDependency{},
+	}
+	dependency := &cycloneDXDependency{Ref: bom.sketch.ref, DependsOn: []string{}}
+	for _, aComponent := range bom.components {
+		doc.Components = append(doc.Components, component(aComponent))
+		dependency.DependsOn = append(dependency.DependsOn, aComponent.ref)
+	}
+	doc.Dependencies = append(doc.Dependencies, dependency)
+	return doc
+}
@@ -0,0 +1,331 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package builder
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+	"arduino.cc/builder/utils"
+)
+
+// Sketch location meaning a tar archive read from the standard input
+const SKETCH_ARCHIVE_STDIN = "-"
+const SKETCH_ARCHIVE_STDIN_NAME = "<stdin>"
+
+// Limit to the size of the unpacked files of a sketch archive
+const SKETCH_ARCHIVE_MAX_SIZE = 64 * 1024 * 1024
+
+var SKETCH_ARCHIVE_EXTENSIONS = []string{".zip", ".tar", ".tar.gz", ".tgz"}
+
+type sketchArchiveFile struct {
+	name string
+	data []byte
+}
+
+func IsSketchArchive(sketchLocation string) bool {
+	if sketchLocation == SKETCH_ARCHIVE_STDIN {
+		return true
+	}
+	for _, extension := range SKETCH_ARCHIVE_EXTENSIONS {
+		if strings.HasSuffix(strings.ToLower(sketchLocation), extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unpack the sketch in the given archive in the build path and return
+// the path of its main file. The sketch is either at the root of the
+// archive or in its only folder, and its main file is the .ino file
+// named after that folder or, failing that, the only .ino file there.
+// Files already unpacked by a previous build are only written again if
+// they changed, so that they're not compiled again.
+// Compiler messages name the files as <archive>:<path in the archive>,
+// but the #line directives of the preprocessed sketch keep naming the
+// unpacked files: they end up in the debug information, where they must
+// be files that exist.
+func unpackSketchArchive(ctx *types.Context, archive string) (string, error) {
+	logger := ctx.GetLogger()
+	archiveName := archive
+	if archive == SKETCH_ARCHIVE_STDIN {
+		archiveName = SKETCH_ARCHIVE_STDIN_NAME
+	}
+
+	files, err := readSketchArchive(archive, archiveName, logger)
+	if err != nil {
+		return "", i18n.WrapError(err)
+	}
+
+	root := sketchArchiveRoot(files)
+	rootName := root
+	if rootName == "" && archive != SKETCH_ARCHIVE_STDIN {
+		rootName = strings.TrimSuffix(filepath.Base(archive), filepath.Ext(archive))
+		rootName = strings.TrimSuffix(rootName, ".tar")
+	}
+
+	mainFile, err := sketchArchiveMainFile(files, root, rootName, archiveName, logger)
+	if err != nil {
+		return "", i18n.WrapError(err)
+	}
+	sketchName := strings.TrimSuffix(path.Base(mainFile), path.Ext(mainFile))
+
+	archiveFolder := filepath.Join(ctx.BuildPath, constants.FOLDER_SKETCH_ARCHIVE)
+	sketchFolder := filepath.Join(archiveFolder, sketchName)
+	unpacked := make(map[string]bool)
+	for _, file := range files {
+		if !strings.HasPrefix(file.name, root) {
+			continue
+		}
+		target := filepath.Join(sketchFolder, filepath.FromSlash(strings.TrimPrefix(file.name, root)))
+		unpacked[target] = true
+		if existing, err := ioutil.ReadFile(target); err == nil && bytes.Equal(existing, file.data) {
+			continue
+		}
+		err := utils.EnsureFolderExists(filepath.Dir(target))
+		if err != nil {
+			return "", i18n.WrapError(err)
+		}
+		err = utils.WriteFileBytes(target, file.data)
+		if err != nil {
+			return "", i18n.WrapError(err)
+		}
+	}
+
+	// Remove what's left of other sketches and of removed files
+	others, err := ioutil.ReadDir(archiveFolder)
+	if err != nil {
+		return "", i18n.WrapError(err)
+	}
+	for _, other := range others {
+		if other.Name() != sketchName {
+			os.RemoveAll(filepath.Join(archiveFolder, other.Name()))
+		}
+	}
+	err = filepath.Walk(sketchFolder, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && !unpacked[path] {
+			return os.Remove(path)
+		}
+		return err
+	})
+	if err != nil {
+		return "", i18n.WrapError(err)
+	}
+
+	ctx.SketchArchive = archive
+	ctx.SketchArchiveFolder = sketchFolder
+	ctx.SketchArchivePrefix = archiveName + ":" + root
+
+	return filepath.Join(sketchFolder, filepath.FromSlash(strings.TrimPrefix(mainFile, root))), nil
+}
+
+// Return the regular files in the archive, with clean slash-separated
+// relative names
+func readSketchArchive(archive string, archiveName string, logger i18n.Logger) ([]sketchArchiveFile, error) {
+	if strings.HasSuffix(strings.ToLower(archive), ".zip") {
+		return readZipSketchArchive(archive, logger)
+	}
+
+	var reader io.Reader = os.Stdin
+	if archive != SKETCH_ARCHIVE_STDIN {
+		file, err := os.Open(archive)
+		if err != nil {
+			return nil, i18n.WrapError(err)
+		}
+		defer file.Close()
+		reader = file
+	}
+	return readTarSketchArchive(reader, archiveName, logger)
+}
+
+func readZipSketchArchive(archive string, logger i18n.Logger) ([]sketchArchiveFile, error) {
+	zipReader, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, i18n.WrapError(err)
+	}
+	defer zipReader.Close()
+
+	files := []sketchArchiveFile{}
+	size := int64(0)
+	for _, entry := range zipReader.File {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		name, err := sketchArchiveFileName(entry.Name, archive, logger)
+		if err != nil {
+			return nil, i18n.WrapError(err)
+		}
+		reader, err := entry.Open()
+		if err != nil {
+			return nil, i18n.WrapError(err)
+		}
+		data, err := readSketchArchiveFile(reader, &size, archive, name, logger)
+		reader.Close()
+		if err != nil {
+			return nil, i18n.WrapError(err)
+		}
+		files = append(files, sketchArchiveFile{name: name, data: data})
+	}
+
+	return files, nil
+}
+
+// Read a tar archive, gzipped or not
+func readTarSketchArchive(reader io.Reader, archiveName string, logger i18n.Logger) ([]sketchArchiveFile, error) {
+	buffered := bufio.NewReader(reader)
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, i18n.WrapError(err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	} else {
+		reader = buffered
+	}
+
+	files := []sketchArchiveFile{}
+	size := int64(0)
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, i18n.WrapError(err)
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+		name, err := sketchArchiveFileName(header.Name, archiveName, logger)
+		if err != nil {
+			return nil, i18n.WrapError(err)
+		}
+		data, err := readSketchArchiveFile(tarReader, &size, archiveName, name, logger)
+		if err != nil {
+			return nil, i18n.WrapError(err)
+		}
+		files = append(files, sketchArchiveFile{name: name, data: data})
+	}
+
+	return files, nil
+}
+
+// Read a file of the archive, adding its size to the total size and
+// failing when it's too large
+func readSketchArchiveFile(reader io.Reader, size *int64, archiveName string, name string, logger i18n.Logger) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(reader, SKETCH_ARCHIVE_MAX_SIZE-*size+1))
+	if err != nil {
+		return nil, i18n.WrapError(err)
+	}
+	*size += int64(len(data))
+	if *size > SKETCH_ARCHIVE_MAX_SIZE {
+		return nil, i18n.ErrorfWithLogger(logger, constants.MSG_SKETCH_ARCHIVE_TOO_LARGE, archiveName, name, strconv.Itoa(SKETCH_ARCHIVE_MAX_SIZE))
+	}
+	return data, nil
+}
+
+// Clean the name of a file in the archive, refusing the ones that would
+// be unpacked outside of the sketch folder
+func sketchArchiveFileName(name string, archiveName string, logger i18n.Logger) (string, error) {
+	cleanName := path.Clean(strings.Replace(name, "\\", "/", -1))
+	if path.IsAbs(cleanName) || cleanName == ".." || strings.HasPrefix(cleanName, "../") || strings.Contains(cleanName, ":") {
+		return "", i18n.ErrorfWithLogger(logger, constants.MSG_SKETCH_ARCHIVE_INVALID_PATH, archiveName, name)
+	}
+	return cleanName, nil
+}
+
+func isHiddenSketchArchiveFile(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the folder, ending with a slash, all of the files in the
+// archive are in, or an empty string if some are at the root
+func sketchArchiveRoot(files []sketchArchiveFile) string {
+	root := ""
+	for _, file := range files {
+		if isHiddenSketchArchiveFile(file.name) {
+			continue
+		}
+		slash := strings.Index(file.name, "/")
+		if slash < 0 || (root != "" && file.name[:slash+1] != root) {
+			return ""
+		}
+		root = file.name[:slash+1]
+	}
+	return root
+}
+
+func sketchArchiveMainFile(files []sketchArchiveFile, root string, rootName string, archiveName string, logger i18n.Logger) (string, error) {
+	candidates := []string{}
+	for _, file := range files {
+		name := strings.TrimPrefix(file.name, root)
+		if strings.Contains(name, "/") || isHiddenSketchArchiveFile(name) || !MAIN_FILE_VALID_EXTENSIONS[strings.ToLower(path.Ext(name))] {
+			continue
+		}
+		if strings.TrimSuffix(name, path.Ext(name)) == strings.TrimSuffix(rootName, "/") {
+			return file.name, nil
+		}
+		candidates = append(candidates, file.name)
+	}
+
+	folder := root
+	if folder == "" {
+		folder = "/"
+	}
+	if len(candidates) == 0 {
+		return "", i18n.ErrorfWithLogger(logger, constants.MSG_SKETCH_ARCHIVE_NO_MAIN_FILE, archiveName, folder)
+	}
+	if len(candidates) > 1 {
+		sort.Strings(candidates)
+		return "", i18n.ErrorfWithLogger(logger, constants.MSG_SKETCH_ARCHIVE_SEVERAL_MAIN_FILES, archiveName, folder, strings.Join(candidates, ", "))
+	}
+	return candidates[0], nil
+}
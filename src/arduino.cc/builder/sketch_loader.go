@@ -51,7 +51,15 @@ func (s *SketchLoader) Run(ctx *types.Context) error {
 
 	sketchLocation := ctx.SketchLocation
 
-	sketchLocation, err := filepath.Abs(sketchLocation)
+	var err error
+	if IsSketchArchive(sketchLocation) {
+		sketchLocation, err = unpackSketchArchive(ctx, sketchLocation)
+		if err != nil {
+			return i18n.WrapError(err)
+		}
+	}
+
+	sketchLocation, err = filepath.Abs(sketchLocation)
 	if err != nil {
 		return i18n.WrapError(err)
 	}
@@ -0,0 +1,104 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+)
+
+// Finds missing includes by scanning the source file, and the headers
+// it includes, for when the preprocessor can't be run (in dry runs). The
+// first #include that isn't found in the include folders, nor next to
+// the file including it, and that a library provides is reported.
+// Conditionals are not evaluated and includes no library provides (e.g.
+// from the toolchain) are skipped.
+type StaticIncludesFinder struct {
+	SourceFilePath string
+	Includes       []string
+}
+
+func (s *StaticIncludesFinder) Run(ctx *types.Context) error {
+	include, err := findMissingInclude(ctx, s.SourceFilePath, s.Includes, make(map[string]bool))
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+
+	ctx.IncludeJustFound = include
+
+	return nil
+}
+
+func findMissingInclude(ctx *types.Context, sourceFilePath string, includes []string, visited map[string]bool) (string, error) {
+	visited[sourceFilePath] = true
+
+	source, err := ioutil.ReadFile(sourceFilePath)
+	if err != nil {
+		return "", i18n.WrapError(err)
+	}
+
+	folders := append([]string{filepath.Dir(sourceFilePath)}, includes...)
+	for _, match := range INCLUDE_REGEXP.FindAllStringSubmatch(string(source), -1) {
+		include := strings.TrimSpace(match[1])
+		header := findInclude(include, folders)
+		if header == "" {
+			if len(ctx.HeaderToLibraries[include]) > 0 {
+				return include, nil
+			}
+			continue
+		}
+		if visited[header] {
+			continue
+		}
+		include, err = findMissingInclude(ctx, header, includes, visited)
+		if include != "" || err != nil {
+			return include, err
+		}
+	}
+
+	return "", nil
+}
+
+// Return the path of the given include in the first folder containing
+// it, or an empty string
+func findInclude(include string, folders []string) string {
+	for _, folder := range folders {
+		header := filepath.Join(folder, include)
+		if info, err := os.Stat(header); err == nil && !info.IsDir() {
+			return header
+		}
+	}
+	return ""
+}
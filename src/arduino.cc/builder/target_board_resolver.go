@@ -69,14 +69,17 @@ func (s *TargetBoardResolver) Run(ctx *types.Context) error {
 		return i18n.ErrorfWithLogger(logger, constants.MSG_BOARD_UNKNOWN, targetBoardName, targetPlatformName, targetPackageName)
 	}
 
-	ctx.TargetPackage = targetPackage
-	ctx.TargetPlatform = targetPlatform
-	ctx.TargetBoard = targetBoard
-
 	if len(fqbnParts) > 3 {
+		// The hardware may be shared with other builds, that must
+		// not see the options selected for this one
+		targetBoard = &types.Board{BoardId: targetBoard.BoardId, Properties: targetBoard.Properties.Clone()}
 		addAdditionalPropertiesToTargetBoard(targetBoard, fqbnParts[3])
 	}
 
+	ctx.TargetPackage = targetPackage
+	ctx.TargetPlatform = targetPlatform
+	ctx.TargetBoard = targetBoard
+
 	core := targetBoard.Properties[constants.BUILD_PROPERTIES_BUILD_CORE]
 	if core == constants.EMPTY_STRING {
 		core = DEFAULT_BUILD_CORE
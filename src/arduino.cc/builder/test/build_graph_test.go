@@ -0,0 +1,208 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"arduino.cc/builder"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+	"arduino.cc/properties"
+	"github.com/go-errors/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type stepsJournal struct {
+	sync.Mutex
+	entries []string
+}
+
+func (j *stepsJournal) add(entry string) {
+	j.Lock()
+	defer j.Unlock()
+	j.entries = append(j.entries, entry)
+}
+
+func (j *stepsJournal) indexOf(entry string) int {
+	for idx, e := range j.entries {
+		if e == entry {
+			return idx
+		}
+	}
+	return -1
+}
+
+type journalingCommand struct {
+	journal *stepsJournal
+	name    string
+	fail    bool
+	wait    chan struct{}
+	signal  chan struct{}
+}
+
+func (s *journalingCommand) Run(ctx *types.Context) error {
+	if s.signal != nil {
+		close(s.signal)
+	}
+	if s.wait != nil {
+		select {
+		case <-s.wait:
+		case <-time.After(5 * time.Second):
+			return errors.New("timeout waiting for " + s.name)
+		}
+	}
+	s.journal.add(s.name)
+	if s.fail {
+		return errors.New(s.name + " failed")
+	}
+	return nil
+}
+
+func TestBuildGraphRespectsDependencies(t *testing.T) {
+	journal := &stepsJournal{}
+	step := func(name string, inputs []string, outputs []string) *builder.BuildStep {
+		return &builder.BuildStep{Name: name, Commands: []types.Command{&journalingCommand{journal: journal, name: name}}, Inputs: inputs, Outputs: outputs}
+	}
+
+	graph := &builder.BuildGraph{Steps: []*builder.BuildStep{
+		step("link", []string{"a.objects", "b.objects"}, []string{"elf"}),
+		step("b", []string{"setup"}, []string{"b.objects"}),
+		step("a", []string{"setup"}, []string{"a.objects"}),
+		step("setup", nil, []string{"setup"}),
+		step("postbuild", []string{"elf"}, nil),
+	}}
+
+	ctx := &types.Context{}
+	ctx.SetLogger(i18n.NoopLogger{})
+	NoError(t, graph.Run(ctx, false))
+
+	require.Equal(t, 5, len(journal.entries))
+	require.Equal(t, "setup", journal.entries[0])
+	require.True(t, journal.indexOf("a") < journal.indexOf("link"))
+	require.True(t, journal.indexOf("b") < journal.indexOf("link"))
+	require.Equal(t, "postbuild", journal.entries[4])
+}
+
+func TestBuildGraphRunsIndependentStepsConcurrently(t *testing.T) {
+	journal := &stepsJournal{}
+	aStarted := make(chan struct{})
+	bStarted := make(chan struct{})
+
+	graph := &builder.BuildGraph{Steps: []*builder.BuildStep{
+		{Name: "a", Commands: []types.Command{&journalingCommand{journal: journal, name: "a", signal: aStarted, wait: bStarted}}},
+		{Name: "b", Commands: []types.Command{&journalingCommand{journal: journal, name: "b", signal: bStarted, wait: aStarted}}},
+	}}
+
+	ctx := &types.Context{}
+	ctx.SetLogger(i18n.NoopLogger{})
+	NoError(t, graph.Run(ctx, false))
+
+	require.Equal(t, 2, len(journal.entries))
+}
+
+func TestBuildGraphStopsAtFirstError(t *testing.T) {
+	journal := &stepsJournal{}
+
+	graph := &builder.BuildGraph{Steps: []*builder.BuildStep{
+		{Name: "a", Commands: []types.Command{&journalingCommand{journal: journal, name: "a", fail: true}}, Outputs: []string{"a"}},
+		{Name: "b", Commands: []types.Command{&journalingCommand{journal: journal, name: "b"}}, Inputs: []string{"a"}},
+	}}
+
+	ctx := &types.Context{}
+	ctx.SetLogger(i18n.NoopLogger{})
+	err := graph.Run(ctx, false)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "a failed")
+
+	require.Equal(t, []string{"a"}, journal.entries)
+}
+
+func TestBuildGraphMissingInput(t *testing.T) {
+	graph := &builder.BuildGraph{Steps: []*builder.BuildStep{
+		{Name: "a", Inputs: []string{"nothing"}},
+	}}
+
+	ctx := &types.Context{}
+	err := graph.Run(ctx, false)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Build step a needs nothing")
+}
+
+func TestBuildGraphCycle(t *testing.T) {
+	graph := &builder.BuildGraph{Steps: []*builder.BuildStep{
+		{Name: "a", Inputs: []string{"b"}, Outputs: []string{"a"}},
+		{Name: "b", Inputs: []string{"a"}, Outputs: []string{"b"}},
+	}}
+
+	ctx := &types.Context{}
+	err := graph.Run(ctx, false)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Build steps a, b depend on each other")
+}
+
+func TestBuildGraphRunsStepsWithHooksInOrder(t *testing.T) {
+	journal := &stepsJournal{}
+	aDone := make(chan struct{})
+	time.AfterFunc(100*time.Millisecond, func() { close(aDone) })
+
+	graph := &builder.BuildGraph{Steps: []*builder.BuildStep{
+		{Name: "setup", Commands: []types.Command{&journalingCommand{journal: journal, name: "setup"}}, Outputs: []string{"setup"}},
+		{Name: "a", Commands: []types.Command{&journalingCommand{journal: journal, name: "a", wait: aDone}}, Inputs: []string{"setup"}, Hooks: []string{"recipe.hooks.a.prebuild."}},
+		{Name: "b", Commands: []types.Command{&journalingCommand{journal: journal, name: "b"}}, Inputs: []string{"setup"}, Hooks: []string{"recipe.hooks.b.prebuild."}},
+	}}
+
+	ctx := &types.Context{}
+	ctx.SetLogger(i18n.NoopLogger{})
+	ctx.BuildProperties = properties.Map{"recipe.hooks.b.prebuild.1.pattern": "true"}
+	NoError(t, graph.Run(ctx, false))
+
+	require.Equal(t, []string{"setup", "a", "b"}, journal.entries)
+}
+
+func TestBuildGraphRunsStepsWithUndefinedHooksConcurrently(t *testing.T) {
+	journal := &stepsJournal{}
+	aStarted := make(chan struct{})
+	bStarted := make(chan struct{})
+
+	graph := &builder.BuildGraph{Steps: []*builder.BuildStep{
+		{Name: "a", Commands: []types.Command{&journalingCommand{journal: journal, name: "a", signal: aStarted, wait: bStarted}}, Hooks: []string{"recipe.hooks.a.prebuild."}},
+		{Name: "b", Commands: []types.Command{&journalingCommand{journal: journal, name: "b", signal: bStarted, wait: aStarted}}, Hooks: []string{"recipe.hooks.b.prebuild."}},
+	}}
+
+	ctx := &types.Context{}
+	ctx.SetLogger(i18n.NoopLogger{})
+	ctx.BuildProperties = properties.Map{"recipe.hooks.a.prebuild.1.pattern": ""}
+	NoError(t, graph.Run(ctx, false))
+
+	require.Equal(t, 2, len(journal.entries))
+}
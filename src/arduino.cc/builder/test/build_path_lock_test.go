@@ -0,0 +1,110 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"arduino.cc/builder"
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+	"arduino.cc/builder/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func newBuildPathLockContext(buildPath string, timeout time.Duration) *types.Context {
+	ctx := &types.Context{BuildPath: buildPath, BuildPathLockTimeout: timeout}
+	ctx.SetLogger(i18n.NoopLogger{})
+	return ctx
+}
+
+func TestBuildPathLock(t *testing.T) {
+	buildPath, err := ioutil.TempDir("", "build")
+	NoError(t, err)
+	defer os.RemoveAll(buildPath)
+
+	ctx := newBuildPathLockContext(buildPath, 0)
+	NoError(t, (&builder.EnsureBuildPathExists{}).Run(ctx))
+	require.NotNil(t, ctx.BuildPathLock)
+	_, err = os.Stat(filepath.Join(buildPath, constants.FILE_BUILD_PATH_LOCK))
+	NoError(t, err)
+
+	otherCtx := newBuildPathLockContext(buildPath, 0)
+	err = (&builder.EnsureBuildPathExists{}).Run(otherCtx)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "process "+strconv.Itoa(os.Getpid()))
+
+	NoError(t, ctx.BuildPathLock.Close())
+	NoError(t, (&builder.EnsureBuildPathExists{}).Run(otherCtx))
+	NoError(t, otherCtx.BuildPathLock.Close())
+}
+
+func TestBuildPathLockWaits(t *testing.T) {
+	buildPath, err := ioutil.TempDir("", "build")
+	NoError(t, err)
+	defer os.RemoveAll(buildPath)
+
+	ctx := newBuildPathLockContext(buildPath, 0)
+	NoError(t, (&builder.EnsureBuildPathExists{}).Run(ctx))
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		ctx.BuildPathLock.Close()
+	}()
+
+	otherCtx := newBuildPathLockContext(buildPath, -1)
+	NoError(t, (&builder.EnsureBuildPathExists{}).Run(otherCtx))
+	NoError(t, otherCtx.BuildPathLock.Close())
+}
+
+func TestBuildPathLockOfDeadProcess(t *testing.T) {
+	buildPath, err := ioutil.TempDir("", "build")
+	NoError(t, err)
+	defer os.RemoveAll(buildPath)
+
+	command := exec.Command(os.Args[0], "-test.run=^$")
+	NoError(t, command.Run())
+	hostname, err := os.Hostname()
+	NoError(t, err)
+	bytes, err := json.Marshal(map[string]interface{}{"pid": command.Process.Pid, "hostname": hostname, "command": "dead"})
+	NoError(t, err)
+	NoError(t, utils.WriteFileBytes(filepath.Join(buildPath, constants.FILE_BUILD_PATH_LOCK), bytes))
+
+	ctx := newBuildPathLockContext(buildPath, 0)
+	NoError(t, (&builder.EnsureBuildPathExists{}).Run(ctx))
+	NoError(t, ctx.BuildPathLock.Close())
+}
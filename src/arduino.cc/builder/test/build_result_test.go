@@ -0,0 +1,144 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"arduino.cc/builder"
+	"arduino.cc/builder/builder_utils"
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+	"arduino.cc/properties"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompileFilesCollectsWarnings(t *testing.T) {
+	sourcePath := prepareSourceFolder(t, "a.c", "b.c")
+	defer os.RemoveAll(sourcePath)
+	buildPath, err := ioutil.TempDir("", "build")
+	NoError(t, err)
+	defer os.RemoveAll(buildPath)
+
+	buildProperties := make(properties.Map)
+	buildProperties[constants.RECIPE_C_PATTERN] = "sh -c \"grep -q b.c {source_file} && echo careful >&2; cp {source_file} {object_file}\""
+
+	ctx := &types.Context{Jobs: 2}
+	ctx.SetLogger(i18n.NoopLogger{})
+
+	_, err = builder_utils.CompileFiles(ctx, []string{}, sourcePath, false, buildPath, buildProperties, []string{})
+	NoError(t, err)
+
+	warnings := ctx.CompilerWarnings()
+	require.Equal(t, 1, len(warnings))
+	require.Equal(t, filepath.Join(sourcePath, "b.c"), warnings[0].Source)
+	require.Equal(t, "careful\n", warnings[0].Output)
+}
+
+func TestNewBuildResult(t *testing.T) {
+	buildPath, err := ioutil.TempDir("", "build")
+	NoError(t, err)
+	defer os.RemoveAll(buildPath)
+	NoError(t, ioutil.WriteFile(filepath.Join(buildPath, "sketch.ino.hex"), []byte{}, os.FileMode(0644)))
+	NoError(t, ioutil.WriteFile(filepath.Join(buildPath, "sketch.ino.elf"), []byte{}, os.FileMode(0644)))
+	NoError(t, ioutil.WriteFile(filepath.Join(buildPath, "other.hex"), []byte{}, os.FileMode(0644)))
+
+	used := &types.Library{Name: "Servo", Version: "1.1.2", Folder: "/libraries/Servo"}
+	notUsed := &types.Library{Name: "Servo", Folder: "/builtin/Servo"}
+
+	ctx := &types.Context{
+		SketchLocation:    "/sketch/sketch.ino",
+		FQBN:              "arduino:avr:uno",
+		BuildPath:         buildPath,
+		BuildProperties:   properties.Map{constants.BUILD_PROPERTIES_BUILD_PROJECT_NAME: "sketch.ino"},
+		SketchObjectFiles: []string{filepath.Join(buildPath, "sketch", "sketch.ino.cpp.o")},
+		ImportedLibraries: []*types.Library{used},
+		LibrariesResolutionResults: map[string]types.LibraryResolutionResult{
+			"Servo.h": {Library: used, NotUsedLibraries: []*types.Library{notUsed}},
+		},
+		SketchSize: &types.SketchSize{Text: 1000, MaxText: 32256, Data: 9, MaxData: 2048},
+	}
+	ctx.AddPhaseTiming("core", 2*time.Second)
+
+	result := builder.NewBuildResult(ctx, nil, 3*time.Second)
+
+	require.True(t, result.Success)
+	require.Equal(t, []string{filepath.Join(buildPath, "sketch.ino.elf"), filepath.Join(buildPath, "sketch.ino.hex")}, result.Artifacts)
+	require.Equal(t, ctx.SketchObjectFiles, result.SketchObjectFiles)
+	require.Equal(t, []string{}, result.CoreObjectFiles)
+	require.Equal(t, 1, len(result.Libraries))
+	require.Equal(t, "Servo.h", result.Libraries[0].Include)
+	require.Equal(t, "1.1.2", result.Libraries[0].Version)
+	require.Equal(t, []string{"/builtin/Servo"}, result.Libraries[0].Alternatives)
+	require.Equal(t, 1000, result.Size.Text)
+	require.Equal(t, 1, len(result.Phases))
+	require.Equal(t, "core", result.Phases[0].Phase)
+	require.Equal(t, float64(2), result.Phases[0].Duration)
+	require.Equal(t, float64(3), result.Duration)
+
+	NoError(t, builder.WriteBuildResult(ctx, result))
+	bytes, err := ioutil.ReadFile(filepath.Join(buildPath, constants.FILE_BUILD_RESULT))
+	NoError(t, err)
+	var written map[string]interface{}
+	NoError(t, json.Unmarshal(bytes, &written))
+	require.Equal(t, true, written["success"])
+	require.Equal(t, "arduino:avr:uno", written["fqbn"])
+	require.Equal(t, float64(32256), written["size"].(map[string]interface{})["max_text"])
+}
+
+func TestCompileReturnsResultOnFailure(t *testing.T) {
+	buildPath, err := ioutil.TempDir("", "build")
+	NoError(t, err)
+	defer os.RemoveAll(buildPath)
+
+	result, err := builder.Compile(&builder.CompileOptions{
+		SketchLocation:  filepath.Join("sketch1", "sketch.ino"),
+		FQBN:            "my_avr_platform:avr:custom_yun",
+		HardwareFolders: []string{filepath.Join("downloaded_hardware", "missing")},
+		ToolsFolders:    []string{"downloaded_tools"},
+		BuildPath:       buildPath,
+	})
+	require.Error(t, err)
+	require.False(t, result.Success)
+	require.Equal(t, err.Error(), result.Error)
+
+	bytes, err := ioutil.ReadFile(filepath.Join(buildPath, constants.FILE_BUILD_RESULT))
+	NoError(t, err)
+	var written builder.BuildResult
+	NoError(t, json.Unmarshal(bytes, &written))
+	require.False(t, written.Success)
+	require.Equal(t, result.Error, written.Error)
+}
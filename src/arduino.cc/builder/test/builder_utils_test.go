@@ -31,10 +31,19 @@ package test
 
 import (
 	"arduino.cc/builder/builder_utils"
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
 	"arduino.cc/builder/utils"
+	"arduino.cc/properties"
+	"errors"
 	"github.com/stretchr/testify/require"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 )
@@ -170,3 +179,333 @@ func TestObjFileIsUpToDateDepIsWrong(t *testing.T) {
 	NoError(t, err)
 	require.False(t, upToDate)
 }
+
+func TestObjFileIsUpToDateMultiTargetRules(t *testing.T) {
+	sourceFile := tempFile(t, "source")
+	defer os.RemoveAll(sourceFile)
+	headerFile := tempFile(t, "header")
+	defer os.RemoveAll(headerFile)
+
+	sleep(t)
+
+	objFile := tempFile(t, "obj")
+	defer os.RemoveAll(objFile)
+	depFile := tempFile(t, "dep")
+	defer os.RemoveAll(depFile)
+
+	// As written by gcc -MMD -MP -MT obj -MT dep
+	utils.WriteFile(depFile, objFile+" "+depFile+": "+sourceFile+" \\\n "+headerFile+"\n\n"+headerFile+":\n")
+
+	upToDate, err := builder_utils.ObjFileIsUpToDate(sourceFile, objFile, depFile)
+	NoError(t, err)
+	require.True(t, upToDate)
+
+	sleep(t)
+	NoError(t, utils.WriteFile(headerFile, "changed"))
+
+	upToDate, err = builder_utils.ObjFileIsUpToDate(sourceFile, objFile, depFile)
+	NoError(t, err)
+	require.False(t, upToDate)
+}
+
+func TestCompileFilesWithHashCheck(t *testing.T) {
+	sourcePath := prepareSourceFolder(t, "a.c", "a.h")
+	defer os.RemoveAll(sourcePath)
+	buildPath, err := ioutil.TempDir("", "build")
+	NoError(t, err)
+	defer os.RemoveAll(buildPath)
+
+	source := filepath.Join(sourcePath, "a.c")
+	header := filepath.Join(sourcePath, "a.h")
+	object := filepath.Join(buildPath, "a.c.o")
+	hashFile := filepath.Join(buildPath, "a.c.hash")
+
+	buildProperties := make(properties.Map)
+	buildProperties[constants.RECIPE_C_PATTERN] = "sh -c \"cp {source_file} {object_file} && echo {object_file}: {source_file} " + header + " > " + filepath.Join(buildPath, "a.c.d") + "\""
+
+	ctx := &types.Context{HashCheck: true}
+	ctx.SetLogger(i18n.NoopLogger{})
+
+	_, err = builder_utils.CompileFiles(ctx, []string{}, sourcePath, false, buildPath, buildProperties, []string{})
+	NoError(t, err)
+
+	upToDate, err := builder_utils.ObjFileIsUpToDateByHash(source, object, hashFile)
+	NoError(t, err)
+	require.True(t, upToDate)
+
+	// Newer, but the same
+	future := time.Now().Add(time.Hour)
+	NoError(t, os.Chtimes(source, future, future))
+	NoError(t, os.Chtimes(header, future, future))
+	upToDate, err = builder_utils.ObjFileIsUpToDateByHash(source, object, hashFile)
+	NoError(t, err)
+	require.True(t, upToDate)
+
+	NoError(t, utils.WriteFile(header, "changed"))
+	upToDate, err = builder_utils.ObjFileIsUpToDateByHash(source, object, hashFile)
+	NoError(t, err)
+	require.False(t, upToDate)
+
+	NoError(t, os.Remove(hashFile))
+	upToDate, err = builder_utils.ObjFileIsUpToDateByHash(source, object, hashFile)
+	NoError(t, err)
+	require.False(t, upToDate)
+}
+
+func TestCompileFilesRecompilesWhenCommandChanges(t *testing.T) {
+	sourcePath := prepareSourceFolder(t, "a.c")
+	defer os.RemoveAll(sourcePath)
+	buildPath, err := ioutil.TempDir("", "build")
+	NoError(t, err)
+	defer os.RemoveAll(buildPath)
+
+	object := filepath.Join(buildPath, "a.c.o")
+	buildProperties := make(properties.Map)
+	buildProperties[constants.RECIPE_C_PATTERN] = "sh -c \"echo {compiler.c.extra_flags} > {object_file} && echo {object_file}: {source_file} > " + filepath.Join(buildPath, "a.c.d") + "\""
+	buildProperties["compiler.c.extra_flags"] = "-O1"
+
+	ctx := &types.Context{}
+	ctx.SetLogger(i18n.NoopLogger{})
+
+	compile := func() string {
+		_, err := builder_utils.CompileFiles(ctx, []string{}, sourcePath, false, buildPath, buildProperties, []string{})
+		NoError(t, err)
+		bytes, err := ioutil.ReadFile(object)
+		NoError(t, err)
+		return string(bytes)
+	}
+
+	require.Equal(t, "-O1\n", compile())
+	NoError(t, utils.WriteFile(object, "compiled"))
+	require.Equal(t, "compiled", compile())
+
+	buildProperties["compiler.c.extra_flags"] = "-O2"
+	require.Equal(t, "-O2\n", compile())
+}
+
+func prepareSourceFolder(t *testing.T, names ...string) string {
+	sourcePath, err := ioutil.TempDir("", "sources")
+	NoError(t, err)
+	for _, name := range names {
+		NoError(t, utils.WriteFile(filepath.Join(sourcePath, name), name))
+	}
+	return sourcePath
+}
+
+func TestCompileFilesInParallelKeepsOrder(t *testing.T) {
+	sourcePath := prepareSourceFolder(t, "e.cpp", "a.c", "d.c", "b.cpp", "c.S", "f.c", "g.cpp")
+	defer os.RemoveAll(sourcePath)
+	buildPath, err := ioutil.TempDir("", "build")
+	NoError(t, err)
+	defer os.RemoveAll(buildPath)
+
+	buildProperties := make(properties.Map)
+	buildProperties[constants.RECIPE_S_PATTERN] = "cp {source_file} {object_file}"
+	buildProperties[constants.RECIPE_C_PATTERN] = "cp {source_file} {object_file}"
+	buildProperties[constants.RECIPE_CPP_PATTERN] = "cp {source_file} {object_file}"
+
+	ctx := &types.Context{Jobs: 4}
+	ctx.SetLogger(i18n.NoopLogger{})
+
+	objectFiles, err := builder_utils.CompileFiles(ctx, []string{}, sourcePath, false, buildPath, buildProperties, []string{})
+	NoError(t, err)
+
+	expected := []string{"c.S.o", "a.c.o", "d.c.o", "f.c.o", "b.cpp.o", "e.cpp.o", "g.cpp.o"}
+	require.Equal(t, len(expected), len(objectFiles))
+	for i, name := range expected {
+		require.Equal(t, filepath.Join(buildPath, name), objectFiles[i])
+		_, err := os.Stat(objectFiles[i])
+		NoError(t, err)
+	}
+}
+
+func TestCompileFilesInParallelStopsAtFirstError(t *testing.T) {
+	sourcePath := prepareSourceFolder(t, "a.c", "b.c", "c.c", "d.c", "e.c", "f.c", "g.c", "h.c")
+	defer os.RemoveAll(sourcePath)
+	buildPath, err := ioutil.TempDir("", "build")
+	NoError(t, err)
+	defer os.RemoveAll(buildPath)
+
+	buildProperties := make(properties.Map)
+	buildProperties[constants.RECIPE_C_PATTERN] = "sh -c \"grep -qv b.c {source_file} && cp {source_file} {object_file}\""
+
+	ctx := &types.Context{Jobs: 1}
+	ctx.SetLogger(i18n.NoopLogger{})
+
+	objectFiles, err := builder_utils.CompileFiles(ctx, []string{}, sourcePath, false, buildPath, buildProperties, []string{})
+	require.Error(t, err)
+	require.Nil(t, objectFiles)
+
+	_, err = os.Stat(filepath.Join(buildPath, "a.c.o"))
+	NoError(t, err)
+	_, err = os.Stat(filepath.Join(buildPath, "b.c.o"))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(buildPath, "c.c.o"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestCompileFilesShareJobsBetweenPhases(t *testing.T) {
+	lockPath, err := ioutil.TempDir("", "lock")
+	NoError(t, err)
+	defer os.RemoveAll(lockPath)
+	buildPath, err := ioutil.TempDir("", "build")
+	NoError(t, err)
+	defer os.RemoveAll(buildPath)
+
+	// Fails when another compilation is running at the same time
+	lock := filepath.Join(lockPath, "running")
+	buildProperties := make(properties.Map)
+	buildProperties[constants.RECIPE_C_PATTERN] = "sh -c \"mkdir " + lock + " && sleep 0.05 && rmdir " + lock + " && cp {source_file} {object_file}\""
+
+	ctx := &types.Context{Jobs: 1}
+	ctx.SetLogger(i18n.NoopLogger{})
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		sourcePath := prepareSourceFolder(t, "a.c", "b.c", "c.c")
+		defer os.RemoveAll(sourcePath)
+		wg.Add(1)
+		go func(i int, sourcePath string) {
+			defer wg.Done()
+			_, errs[i] = builder_utils.CompileFiles(ctx, []string{}, sourcePath, false, filepath.Join(buildPath, strconv.Itoa(i)), buildProperties, []string{})
+		}(i, sourcePath)
+	}
+	wg.Wait()
+
+	NoError(t, errs[0])
+	NoError(t, errs[1])
+}
+
+func TestRecipeTimeout(t *testing.T) {
+	buildProperties := make(properties.Map)
+
+	timeout, err := builder_utils.RecipeTimeout(buildProperties, constants.RECIPE_C_COMBINE_PATTERN)
+	NoError(t, err)
+	require.Equal(t, time.Duration(0), timeout)
+
+	buildProperties[constants.RECIPE_TIMEOUT] = "60"
+	buildProperties["recipe.c.combine.timeout"] = "120"
+
+	timeout, err = builder_utils.RecipeTimeout(buildProperties, constants.RECIPE_C_COMBINE_PATTERN)
+	NoError(t, err)
+	require.Equal(t, 120*time.Second, timeout)
+
+	timeout, err = builder_utils.RecipeTimeout(buildProperties, constants.RECIPE_CPP_PATTERN)
+	NoError(t, err)
+	require.Equal(t, 60*time.Second, timeout)
+
+	buildProperties[constants.RECIPE_TIMEOUT] = "a minute"
+	_, err = builder_utils.RecipeTimeout(buildProperties, constants.RECIPE_CPP_PATTERN)
+	require.Error(t, err)
+}
+
+type eventRecorder struct {
+	i18n.NoopLogger
+	events []string
+	data   []map[string]interface{}
+}
+
+func (s *eventRecorder) Event(w io.Writer, event string, data map[string]interface{}) {
+	s.events = append(s.events, event)
+	s.data = append(s.data, data)
+}
+
+func TestExecRecipeOutputAsEvent(t *testing.T) {
+	buildProperties := make(properties.Map)
+	buildProperties["recipe.test.pattern"] = "echo compiled"
+
+	logger := &eventRecorder{}
+	ctx := &types.Context{}
+	ctx.SetLogger(logger)
+
+	_, err := builder_utils.ExecRecipe(ctx, buildProperties, "recipe.test.pattern", false, true, true)
+	NoError(t, err)
+	require.Equal(t, []string{constants.EVENT_COMMAND, constants.EVENT_OUTPUT}, logger.events)
+	require.Equal(t, "compiled\n", logger.data[1]["output"])
+}
+
+func TestCompileFilesRemovesPartialObjectOnTimeout(t *testing.T) {
+	sourcePath := prepareSourceFolder(t, "a.c")
+	defer os.RemoveAll(sourcePath)
+	buildPath, err := ioutil.TempDir("", "build")
+	NoError(t, err)
+	defer os.RemoveAll(buildPath)
+
+	buildProperties := make(properties.Map)
+	buildProperties[constants.RECIPE_C_PATTERN] = "sh -c \"cp {source_file} {object_file} && sleep 30\""
+	buildProperties[constants.RECIPE_TIMEOUT] = "1"
+
+	ctx := &types.Context{}
+	ctx.SetLogger(i18n.NoopLogger{})
+
+	_, err = builder_utils.CompileFiles(ctx, []string{}, sourcePath, false, buildPath, buildProperties, []string{})
+	require.Error(t, err)
+
+	_, err = os.Stat(filepath.Join(buildPath, "a.c.o"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestCompileFilesKeepsPreviousObjectOnFailure(t *testing.T) {
+	sourcePath := prepareSourceFolder(t, "a.c")
+	defer os.RemoveAll(sourcePath)
+	buildPath, err := ioutil.TempDir("", "build")
+	NoError(t, err)
+	defer os.RemoveAll(buildPath)
+	NoError(t, utils.WriteFile(filepath.Join(buildPath, "a.c.o"), "previous"))
+
+	buildProperties := make(properties.Map)
+	buildProperties[constants.RECIPE_C_PATTERN] = "sh -c \"echo partial > {object_file} && false\""
+
+	ctx := &types.Context{}
+	ctx.SetLogger(i18n.NoopLogger{})
+
+	_, err = builder_utils.CompileFiles(ctx, []string{}, sourcePath, false, buildPath, buildProperties, []string{})
+	require.Error(t, err)
+
+	bytes, err := ioutil.ReadFile(filepath.Join(buildPath, "a.c.o"))
+	NoError(t, err)
+	require.Equal(t, "previous", string(bytes))
+	files, err := ioutil.ReadDir(buildPath)
+	NoError(t, err)
+	require.Equal(t, 1, len(files))
+}
+
+func TestRunWithTemporaryProjectName(t *testing.T) {
+	buildPath, err := ioutil.TempDir("", "build")
+	NoError(t, err)
+	defer os.RemoveAll(buildPath)
+	NoError(t, utils.WriteFile(filepath.Join(buildPath, "sketch.ino.elf"), "elf"))
+
+	buildProperties := properties.Map{
+		constants.BUILD_PROPERTIES_BUILD_PATH:         buildPath,
+		constants.BUILD_PROPERTIES_BUILD_PROJECT_NAME: "sketch.ino",
+	}
+	objcopy := func(buildProperties properties.Map) error {
+		elf, err := ioutil.ReadFile(filepath.Join(buildPath, buildProperties[constants.BUILD_PROPERTIES_BUILD_PROJECT_NAME]+".elf"))
+		if err != nil {
+			return err
+		}
+		return utils.WriteFileBytes(filepath.Join(buildPath, buildProperties[constants.BUILD_PROPERTIES_BUILD_PROJECT_NAME]+".hex"), append(elf, " as hex"...))
+	}
+
+	ctx := &types.Context{}
+	NoError(t, builder_utils.RunWithTemporaryProjectName(ctx, buildProperties, []string{".elf"}, objcopy))
+	bytes, err := ioutil.ReadFile(filepath.Join(buildPath, "sketch.ino.hex"))
+	NoError(t, err)
+	require.Equal(t, "elf as hex", string(bytes))
+
+	failing := func(buildProperties properties.Map) error {
+		NoError(t, objcopy(buildProperties))
+		return errors.New("failed")
+	}
+	NoError(t, utils.WriteFile(filepath.Join(buildPath, "sketch.ino.elf"), "new elf"))
+	require.Error(t, builder_utils.RunWithTemporaryProjectName(ctx, buildProperties, []string{".elf"}, failing))
+	bytes, err = ioutil.ReadFile(filepath.Join(buildPath, "sketch.ino.hex"))
+	NoError(t, err)
+	require.Equal(t, "elf as hex", string(bytes))
+
+	files, err := ioutil.ReadDir(buildPath)
+	NoError(t, err)
+	require.Equal(t, 2, len(files))
+}
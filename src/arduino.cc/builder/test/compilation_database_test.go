@@ -0,0 +1,131 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"arduino.cc/builder"
+	"arduino.cc/builder/builder_utils"
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+	"arduino.cc/builder/utils"
+	"arduino.cc/properties"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompileFilesRecordsCompileCommands(t *testing.T) {
+	sourcePath := prepareSourceFolder(t, "a.c", "b.cpp")
+	defer os.RemoveAll(sourcePath)
+	buildPath, err := ioutil.TempDir("", "build")
+	NoError(t, err)
+	defer os.RemoveAll(buildPath)
+
+	// b.cpp is up to date, and its recipe would fail if run
+	NoError(t, utils.WriteFile(filepath.Join(buildPath, "b.cpp.o"), ""))
+	NoError(t, utils.WriteFile(filepath.Join(buildPath, "b.cpp.d"), filepath.Join(buildPath, "b.cpp.o")+": \\\n "+filepath.Join(sourcePath, "b.cpp")))
+	NoError(t, utils.WriteFile(filepath.Join(buildPath, "b.cpp.cmd"), utils.CommandLine([]string{"false", "-I/include dir", filepath.Join(sourcePath, "b.cpp"), "-o", filepath.Join(buildPath, "b.cpp.o")})+"\n"))
+
+	buildProperties := make(properties.Map)
+	buildProperties[constants.RECIPE_C_PATTERN] = "cp \"{source_file}\" \"{object_file}\""
+	buildProperties[constants.RECIPE_CPP_PATTERN] = "false {includes} \"{source_file}\" -o \"{object_file}\""
+
+	ctx := &types.Context{}
+	ctx.SetLogger(i18n.NoopLogger{})
+
+	_, err = builder_utils.CompileFiles(ctx, []string{}, sourcePath, false, buildPath, buildProperties, []string{"\"-I/include dir\""})
+	NoError(t, err)
+
+	commands := ctx.CompileCommands()
+	require.Equal(t, 2, len(commands))
+	require.Equal(t, filepath.Join(sourcePath, "a.c"), commands[0].File)
+	require.Equal(t, filepath.Join(sourcePath, "b.cpp"), commands[1].File)
+	require.Equal(t, filepath.Join(buildPath, "b.cpp.o"), commands[1].Output)
+	require.Equal(t, []string{"false", "-I/include dir", filepath.Join(sourcePath, "b.cpp"), "-o", filepath.Join(buildPath, "b.cpp.o")}, commands[1].Arguments)
+	cwd, err := os.Getwd()
+	NoError(t, err)
+	require.Equal(t, cwd, commands[1].Directory)
+}
+
+func TestCompilationDatabaseSaver(t *testing.T) {
+	buildPath, err := ioutil.TempDir("", "build")
+	NoError(t, err)
+	defer os.RemoveAll(buildPath)
+
+	sketchBuildPath := filepath.Join(buildPath, constants.FOLDER_SKETCH)
+	mergedFile := filepath.Join(sketchBuildPath, "sketch.ino.cpp")
+	copiedFile := filepath.Join(sketchBuildPath, "src", "helper.cpp")
+	ctx := &types.Context{
+		BuildPath:       buildPath,
+		SketchBuildPath: sketchBuildPath,
+		Sketch: &types.Sketch{
+			MainFile:         types.SketchFile{Name: "/sketch/sketch.ino"},
+			OtherSketchFiles: []types.SketchFile{{Name: "/sketch/other.ino"}},
+			AdditionalFiles:  []types.SketchFile{{Name: "/sketch/src/helper.cpp"}, {Name: "/sketch/src/helper.h"}},
+		},
+	}
+	ctx.AddCompileCommand(types.CompileCommand{Directory: "/", File: mergedFile, Arguments: []string{"g++", "-c", mergedFile, "-o", mergedFile + ".o"}, Output: mergedFile + ".o"})
+	ctx.AddCompileCommand(types.CompileCommand{Directory: "/", File: copiedFile, Arguments: []string{"g++", "-c", copiedFile, "-o", copiedFile + ".o"}, Output: copiedFile + ".o"})
+	ctx.AddCompileCommand(types.CompileCommand{Directory: "/", File: "/core/main.cpp", Arguments: []string{"g++", "-c", "/core/main.cpp"}})
+
+	NoError(t, (&builder.CompilationDatabaseSaver{}).Run(ctx))
+
+	bytes, err := ioutil.ReadFile(filepath.Join(buildPath, constants.FILE_COMPILE_COMMANDS))
+	NoError(t, err)
+	var commands []types.CompileCommand
+	NoError(t, json.Unmarshal(bytes, &commands))
+
+	files := []string{}
+	for _, command := range commands {
+		files = append(files, command.File)
+	}
+	expected := []string{copiedFile, mergedFile, "/core/main.cpp", "/sketch/other.ino", "/sketch/sketch.ino", "/sketch/src/helper.cpp"}
+	sort.Strings(expected)
+	require.Equal(t, expected, files)
+	for _, command := range commands {
+		switch command.File {
+		case "/sketch/sketch.ino":
+			require.Equal(t, []string{"g++", "-x", "c++", "-include", "Arduino.h", "-c", "/sketch/sketch.ino", "-o", mergedFile + ".o"}, command.Arguments)
+		case "/sketch/src/helper.cpp":
+			require.Equal(t, []string{"g++", "-c", "/sketch/src/helper.cpp", "-o", copiedFile + ".o"}, command.Arguments)
+		}
+	}
+
+	ctx.CompilationDatabasePath = filepath.Join(buildPath, "elsewhere.json")
+	NoError(t, (&builder.CompilationDatabaseSaver{}).Run(ctx))
+	_, err = os.Stat(ctx.CompilationDatabasePath)
+	NoError(t, err)
+}
@@ -0,0 +1,224 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"arduino.cc/builder"
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/types"
+	"arduino.cc/builder/utils"
+	"arduino.cc/properties"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoaderCache(t *testing.T) {
+	var nilCache *types.LoaderCache
+	nilCache.SetLibraries("folder", "stamp", []*types.Library{})
+	_, ok := nilCache.Libraries("folder", "stamp")
+	require.False(t, ok)
+	require.Nil(t, nilCache.Hardware("folders", "stamp"))
+
+	cache := types.NewLoaderCache()
+	libraries := []*types.Library{&types.Library{Name: "Lib"}}
+	cache.SetLibraries("folder", "stamp", libraries)
+	cached, ok := cache.Libraries("folder", "stamp")
+	require.True(t, ok)
+	require.Equal(t, libraries, cached)
+	_, ok = cache.Libraries("folder", "other stamp")
+	require.False(t, ok)
+	_, ok = cache.Libraries("other folder", "stamp")
+	require.False(t, ok)
+
+	hardware := &types.LoadedHardware{Hardware: &types.Packages{}}
+	cache.SetHardware("folders", "stamp", hardware)
+	require.Equal(t, hardware, cache.Hardware("folders", "stamp"))
+	require.Nil(t, cache.Hardware("folders", "other stamp"))
+}
+
+func TestLibrariesLoaderUsesLoaderCache(t *testing.T) {
+	librariesFolder, err := ioutil.TempDir("", "libraries")
+	NoError(t, err)
+	defer os.RemoveAll(librariesFolder)
+	libraryProperties := filepath.Join(librariesFolder, "MyLib", constants.LIBRARY_PROPERTIES)
+	NoError(t, os.MkdirAll(filepath.Join(librariesFolder, "MyLib", "src"), os.FileMode(0755)))
+	NoError(t, utils.WriteFile(libraryProperties, "name=MyLib\nversion=1.0.0\ncategory=Other\n"))
+	NoError(t, utils.WriteFile(filepath.Join(librariesFolder, "MyLib", "src", "MyLib.h"), ""))
+
+	cache := types.NewLoaderCache()
+	load := func() *types.Library {
+		platform := &types.Platform{Folder: filepath.Join(librariesFolder, "platform")}
+		ctx := &types.Context{
+			OtherLibrariesFolders: []string{librariesFolder},
+			TargetPlatform:        platform,
+			ActualPlatform:        platform,
+			LoaderCache:           cache,
+		}
+		NoError(t, (&builder.LibrariesLoader{}).Run(ctx))
+		require.Equal(t, 1, len(ctx.Libraries))
+		require.Equal(t, ctx.Libraries, ctx.HeaderToLibraries["MyLib.h"])
+		return ctx.Libraries[0]
+	}
+
+	library := load()
+	require.Equal(t, "1.0.0", library.Version)
+	require.True(t, library == load())
+
+	NoError(t, utils.WriteFile(libraryProperties, "name=MyLib\nversion=1.0.1\ncategory=Other\n"))
+	later := time.Now().Add(time.Minute)
+	NoError(t, os.Chtimes(libraryProperties, later, later))
+
+	reloaded := load()
+	require.False(t, library == reloaded)
+	require.Equal(t, "1.0.1", reloaded.Version)
+}
+
+func TestHardwareLoaderUsesLoaderCache(t *testing.T) {
+	packagesFolder, err := ioutil.TempDir("", "packages")
+	NoError(t, err)
+	defer os.RemoveAll(packagesFolder)
+	platformFolder := filepath.Join(packagesFolder, "vendor", "hardware", "arch", "1.0.0")
+	boardsTxt := filepath.Join(platformFolder, constants.FILE_BOARDS_TXT)
+	NoError(t, os.MkdirAll(platformFolder, os.FileMode(0755)))
+	NoError(t, utils.WriteFile(boardsTxt, "board.name=Board\n"))
+	NoError(t, utils.WriteFile(filepath.Join(platformFolder, constants.FILE_PLATFORM_TXT), "name=Platform\n"))
+
+	cache := types.NewLoaderCache()
+	load := func() *types.Board {
+		ctx := &types.Context{
+			HardwareFolders: []string{packagesFolder},
+			LoaderCache:     cache,
+		}
+		NoError(t, (&builder.ContainerLoadHardwareAndTools{}).Run(ctx))
+		return ctx.Hardware.Packages["vendor"].Platforms["arch"].Boards["board"]
+	}
+
+	board := load()
+	require.Equal(t, "Board", board.Properties["name"])
+	require.True(t, board == load())
+
+	// Edited in place, the folder doesn't change
+	NoError(t, ioutil.WriteFile(boardsTxt, []byte("board.name=Other\n"), os.FileMode(0644)))
+	later := time.Now().Add(time.Minute)
+	NoError(t, os.Chtimes(boardsTxt, later, later))
+
+	reloaded := load()
+	require.False(t, board == reloaded)
+	require.Equal(t, "Other", reloaded.Properties["name"])
+}
+
+func postToDaemon(t *testing.T, daemon *builder.Daemon, path string, options *builder.CompileOptions) *builder.DaemonResponse {
+	body, err := json.Marshal(options)
+	NoError(t, err)
+	recorder := httptest.NewRecorder()
+	daemon.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	response := &builder.DaemonResponse{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), response))
+	return response
+}
+
+func TestDaemonDumpPrefs(t *testing.T) {
+	buildPath, err := ioutil.TempDir("", "build")
+	NoError(t, err)
+	defer os.RemoveAll(buildPath)
+
+	daemon := builder.NewDaemon()
+	options := &builder.CompileOptions{
+		SketchLocation:  filepath.Join("sketch1", "sketch.ino"),
+		FQBN:            "my_avr_platform:avr:custom_yun",
+		HardwareFolders: []string{"hardware", "user_hardware"},
+		ToolsFolders:    []string{"tools_builtin"},
+		BuildPath:       buildPath,
+	}
+
+	for i := 0; i < 2; i++ {
+		response := postToDaemon(t, daemon, builder.DAEMON_PATH_DUMP_PREFS, options)
+		require.True(t, response.Success, response.Error)
+		require.Equal(t, "atmega32u4", response.BuildProperties[constants.BUILD_PROPERTIES_BUILD_MCU])
+		require.Equal(t, buildPath, response.BuildProperties[constants.BUILD_PROPERTIES_BUILD_PATH])
+	}
+
+	options.FQBN = "my_avr_platform:avr:missing"
+	response := postToDaemon(t, daemon, builder.DAEMON_PATH_DUMP_PREFS, options)
+	require.False(t, response.Success)
+	require.Contains(t, response.Error, "missing")
+}
+
+func TestDaemonPreprocessReleasesBuildPath(t *testing.T) {
+	root := PrepareTestPlatform(t, properties.Map{
+		"board.name":       "Board",
+		"board.build.core": "core",
+	}, nil, nil)
+	defer os.RemoveAll(root)
+	buildPath := filepath.Join(root, "build")
+	NoError(t, os.MkdirAll(buildPath, os.FileMode(0755)))
+
+	daemon := builder.NewDaemon()
+	options := &builder.CompileOptions{
+		SketchLocation:  filepath.Join(root, "sketch", "sketch.ino"),
+		FQBN:            "test:arch:board",
+		HardwareFolders: []string{filepath.Join(root, "hardware")},
+		BuildPath:       buildPath,
+	}
+
+	for i := 0; i < 2; i++ {
+		response := postToDaemon(t, daemon, builder.DAEMON_PATH_PREPROCESS, options)
+		require.True(t, response.Success, response.Error)
+	}
+	_, err := os.Stat(filepath.Join(buildPath, constants.FILE_BUILD_PATH_LOCK))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestDaemonRejectsBadRequests(t *testing.T) {
+	daemon := builder.NewDaemon()
+
+	recorder := httptest.NewRecorder()
+	daemon.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, builder.DAEMON_PATH_COMPILE, nil))
+	require.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	daemon.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/upload", nil))
+	require.Equal(t, http.StatusNotFound, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	daemon.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, builder.DAEMON_PATH_COMPILE, bytes.NewReader([]byte("{"))))
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+}
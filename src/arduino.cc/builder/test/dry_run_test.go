@@ -0,0 +1,107 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"arduino.cc/builder"
+	"arduino.cc/builder/builder_utils"
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+	"arduino.cc/builder/utils"
+	"arduino.cc/properties"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandLine(t *testing.T) {
+	require.Equal(t, "gcc -c 'my sketch.cpp' '' '-DNAME=\"x\"' '$HOME' 'it'\\''s'", utils.CommandLine([]string{"gcc", "-c", "my sketch.cpp", "", "-DNAME=\"x\"", "$HOME", "it's"}))
+}
+
+func TestStaticIncludesFinder(t *testing.T) {
+	sourcePath := prepareSourceFolder(t)
+	defer os.RemoveAll(sourcePath)
+	includePath := prepareSourceFolder(t)
+	defer os.RemoveAll(includePath)
+
+	NoError(t, utils.WriteFile(filepath.Join(sourcePath, "sketch.cpp"), "#include <stdio.h>\n#include \"own.h\"\n#include <Local.h>\n"))
+	NoError(t, utils.WriteFile(filepath.Join(sourcePath, "own.h"), "#include \"own.h\"\n"))
+	NoError(t, utils.WriteFile(filepath.Join(includePath, "Local.h"), "  #  include <Servo.h>\n"))
+
+	ctx := &types.Context{
+		HeaderToLibraries: map[string][]*types.Library{
+			"Servo.h": {&types.Library{Name: "Servo"}},
+			"own.h":   {&types.Library{Name: "Own"}},
+		},
+	}
+
+	finder := &builder.StaticIncludesFinder{SourceFilePath: filepath.Join(sourcePath, "sketch.cpp"), Includes: []string{includePath}}
+	NoError(t, finder.Run(ctx))
+	require.Equal(t, "Servo.h", ctx.IncludeJustFound)
+
+	NoError(t, utils.WriteFile(filepath.Join(includePath, "Servo.h"), ""))
+	NoError(t, finder.Run(ctx))
+	require.Equal(t, "", ctx.IncludeJustFound)
+}
+
+func TestCompileFilesDryRun(t *testing.T) {
+	sourcePath := prepareSourceFolder(t, "a.c", "b.c")
+	defer os.RemoveAll(sourcePath)
+	buildPath, err := ioutil.TempDir("", "build")
+	NoError(t, err)
+	defer os.RemoveAll(buildPath)
+
+	// Up to date objects are compiled anyway
+	NoError(t, utils.WriteFile(filepath.Join(buildPath, "a.c.o"), ""))
+	NoError(t, utils.WriteFile(filepath.Join(buildPath, "a.c.d"), filepath.Join(buildPath, "a.c.o")+": \\\n "+filepath.Join(sourcePath, "a.c")))
+
+	buildProperties := make(properties.Map)
+	buildProperties[constants.RECIPE_C_PATTERN] = "false {source_file}"
+
+	ctx := &types.Context{DryRun: true, DryRunPhase: "core", Jobs: 4}
+	ctx.SetLogger(i18n.NoopLogger{})
+
+	objectFiles, err := builder_utils.CompileFiles(ctx, []string{}, sourcePath, false, buildPath, buildProperties, []string{})
+	NoError(t, err)
+	require.Equal(t, 2, len(objectFiles))
+
+	_, err = os.Stat(filepath.Join(buildPath, "b.c.o"))
+	require.True(t, os.IsNotExist(err))
+
+	commands := ctx.DryRunCommands()
+	require.Equal(t, []types.DryRunCommand{
+		{Phase: "core", CommandLine: "false " + filepath.Join(sourcePath, "a.c")},
+		{Phase: "core", CommandLine: "false " + filepath.Join(sourcePath, "b.c")},
+	}, commands)
+}
@@ -0,0 +1,80 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package test
+
+import (
+	"testing"
+
+	"arduino.cc/builder"
+	"arduino.cc/builder/builder_utils"
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+	"arduino.cc/builder/utils"
+	"arduino.cc/properties"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordingExecutor(t *testing.T) {
+	recorder := &utils.RecordingExecutor{}
+	ctx := &types.Context{Executor: recorder}
+	ctx.SetLogger(i18n.NoopLogger{})
+
+	buildProperties := make(properties.Map)
+	buildProperties[constants.RECIPE_C_COMBINE_PATTERN] = "false {object_files} -o \"{build.path}/sketch.elf\""
+	buildProperties[constants.BUILD_PROPERTIES_OBJECT_FILES] = "a.o b.o"
+	buildProperties[constants.BUILD_PROPERTIES_BUILD_PATH] = "/tmp/my build"
+	buildProperties["tools.ctags.pattern"] = "ctags -u \"{source_file}\""
+
+	// Not run, so even false succeeds
+	output, err := builder_utils.ExecRecipe(ctx, buildProperties, constants.RECIPE_C_COMBINE_PATTERN, false, false, false)
+	NoError(t, err)
+	require.Equal(t, 0, len(output))
+
+	ctx.BuildProperties = buildProperties
+	ctx.CTagsTargetFile = "/tmp/ctags_target.cpp"
+	NoError(t, (&builder.CTagsRunner{}).Run(ctx))
+
+	require.Equal(t, []string{"false a.o b.o -o '/tmp/my build/sketch.elf'", "ctags -u /tmp/ctags_target.cpp"}, recorder.Commands())
+}
+
+func TestWrapperExecutor(t *testing.T) {
+	recorder := &utils.RecordingExecutor{Executor: utils.LocalExecutor{}}
+	ctx := &types.Context{Executor: &utils.WrapperExecutor{Wrapper: []string{"echo", "wrapped"}, Executor: recorder}}
+	ctx.SetLogger(i18n.NoopLogger{})
+
+	buildProperties := make(properties.Map)
+	buildProperties[constants.RECIPE_AR_PATTERN] = "ar rcs \"core a.a\" main.o"
+
+	output, err := builder_utils.ExecRecipe(ctx, buildProperties, constants.RECIPE_AR_PATTERN, false, false, false)
+	NoError(t, err)
+	require.Equal(t, "wrapped ar rcs core a.a main.o\n", string(output))
+	require.Equal(t, []string{"echo wrapped ar rcs 'core a.a' main.o"}, recorder.Commands())
+}
@@ -0,0 +1,134 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"arduino.cc/builder"
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+	"arduino.cc/properties"
+	"github.com/stretchr/testify/require"
+)
+
+// Create a platform compiling with the cc of the host, and a sketch for
+// it, in a temporary folder
+func prepareExportPlatform(t *testing.T) string {
+	return PrepareTestPlatform(t, properties.Map{
+		"board.name":        "Board",
+		"board.build.core":  "core",
+		"board.build.board": "BOARD",
+	}, properties.Map{
+		"recipe.cpp.o.pattern":                 "cc -c -MMD {includes} \"{source_file}\" -o \"{object_file}\"",
+		"recipe.ar.pattern":                    "ar rcs \"{archive_file_path}\" \"{object_file}\"",
+		"recipe.c.combine.pattern":             "cc -o \"{build.path}/{build.project_name}.elf\" {object_files} \"{build.path}/{archive_file}\"",
+		"recipe.objcopy.hex.pattern":           "objcopy \"{build.path}/{build.project_name}.elf\" \"{build.path}/{build.project_name}.hex\"",
+		"recipe.hooks.core.prebuild.1.pattern": "echo '$CORE'",
+	}, nil)
+}
+
+func TestExportBuildNinja(t *testing.T) {
+	root := prepareExportPlatform(t)
+	defer os.RemoveAll(root)
+	buildPath := filepath.Join(root, "build")
+	NoError(t, os.Mkdir(buildPath, os.FileMode(0755)))
+
+	ctx := &types.Context{
+		HardwareFolders:   []string{filepath.Join(root, "hardware")},
+		SketchLocation:    filepath.Join(root, "sketch", "sketch.ino"),
+		FQBN:              "test:arch:board",
+		ArduinoAPIVersion: "10600",
+		BuildPath:         buildPath,
+	}
+	ctx.SetLogger(i18n.NoopLogger{})
+
+	NoError(t, builder.RunExportBuild(ctx, builder.EXPORT_BUILD_NINJA))
+	require.False(t, ctx.DryRun)
+
+	bytes, err := ioutil.ReadFile(filepath.Join(buildPath, constants.FILE_BUILD_NINJA))
+	NoError(t, err)
+	ninja := string(bytes)
+
+	core := filepath.Join(root, "hardware", "test", "arch", "cores", "core")
+	object := filepath.Join(buildPath, "core", "main.cpp.o")
+	prebuildStamp := filepath.Join(buildPath, "core.prebuild.stamp")
+	require.Contains(t, ninja, "build "+object+": run "+filepath.Join(core, "main.cpp")+" || "+prebuildStamp+"\n")
+	require.Contains(t, ninja, "  cmd = cc -c -MMD -I"+core+" "+filepath.Join(core, "main.cpp")+" -o "+object+"\n")
+	require.Contains(t, ninja, "  depfile = "+filepath.Join(buildPath, "core", "main.cpp.d")+"\n")
+	require.Contains(t, ninja, "build "+prebuildStamp+": run\n  cmd = echo '$$CORE' && touch "+prebuildStamp+"\n")
+	require.Contains(t, ninja, "build "+filepath.Join(buildPath, "core.stamp")+": run "+object+" "+prebuildStamp+"\n  cmd = ar rcs ")
+	require.Contains(t, ninja, "build "+filepath.Join(buildPath, "link.stamp")+": run "+filepath.Join(buildPath, "sketch.stamp")+" "+filepath.Join(buildPath, "libraries.stamp")+" "+filepath.Join(buildPath, "core.stamp")+"\n")
+	require.Contains(t, ninja, "\ndefault ")
+
+	// Nothing was compiled
+	_, err = os.Stat(object)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestExportBuildMake(t *testing.T) {
+	root := prepareExportPlatform(t)
+	defer os.RemoveAll(root)
+	buildPath := filepath.Join(root, "build")
+	NoError(t, os.Mkdir(buildPath, os.FileMode(0755)))
+
+	ctx := &types.Context{
+		HardwareFolders:   []string{filepath.Join(root, "hardware")},
+		SketchLocation:    filepath.Join(root, "sketch", "sketch.ino"),
+		FQBN:              "test:arch:board",
+		ArduinoAPIVersion: "10600",
+		BuildPath:         buildPath,
+	}
+	ctx.SetLogger(i18n.NoopLogger{})
+
+	NoError(t, builder.RunExportBuild(ctx, builder.EXPORT_BUILD_MAKE))
+
+	bytes, err := ioutil.ReadFile(filepath.Join(buildPath, constants.FILE_MAKEFILE))
+	NoError(t, err)
+	makefile := string(bytes)
+
+	sketchObject := filepath.Join(buildPath, "sketch", "sketch.ino.cpp.o")
+	require.Contains(t, makefile, "\n"+sketchObject+": "+filepath.Join(buildPath, "sketch", "sketch.ino.cpp")+"\n\tcc -c -MMD ")
+	require.Contains(t, makefile, "-include "+filepath.Join(buildPath, "sketch", "sketch.ino.cpp.d")+"\n")
+	require.Contains(t, makefile, "\techo '$$CORE'\n")
+	require.Contains(t, makefile, "\n"+filepath.Join(buildPath, "objcopy.stamp")+": "+filepath.Join(buildPath, "link.stamp")+"\n\tobjcopy ")
+}
+
+func TestExportBuildUnknownFormat(t *testing.T) {
+	ctx := &types.Context{}
+	ctx.SetLogger(i18n.NoopLogger{})
+
+	err := builder.RunExportBuild(ctx, "bazel")
+	require.Error(t, err)
+}
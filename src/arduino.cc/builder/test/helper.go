@@ -34,12 +34,16 @@ import (
 	"arduino.cc/builder/constants"
 	"arduino.cc/builder/types"
 	"arduino.cc/builder/utils"
+	"arduino.cc/properties"
 	"bytes"
 	"fmt"
 	"github.com/go-errors/errors"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 	"text/template"
 )
@@ -84,6 +88,53 @@ func SetupBuildPath(t *testing.T, ctx *types.Context) string {
 	return buildPath
 }
 
+// Creates, in a temporary folder that is returned, a test:arch platform
+// in hardware/ with the given boards.txt and platform.txt properties, a
+// core named core, a sketch/sketch.ino and the other given files, with
+// slash separated paths relative to the folder. Unless replaced, the
+// recipes copy files instead of compiling them and ctags isn't run, so
+// no toolchain is needed
+func PrepareTestPlatform(t *testing.T, boardsTxt properties.Map, platformTxt properties.Map, files map[string]string) string {
+	root, err := ioutil.TempDir("", "platform")
+	NoError(t, err)
+
+	platform := properties.Map{
+		"name":                  "Test",
+		"version":               "1.0.0",
+		"recipe.cpp.o.pattern":  "cp \"{source_file}\" \"{object_file}\"",
+		"recipe.preproc.macros": "cp \"{source_file}\" \"{preprocessed_file_path}\"",
+		"recipe.ar.pattern":     "cp \"{object_file}\" \"{archive_file_path}\"",
+		"tools.ctags.pattern":   "true",
+	}
+	platform.Merge(platformTxt)
+
+	allFiles := map[string]string{
+		"hardware/test/arch/boards.txt":          propertiesFile(boardsTxt),
+		"hardware/test/arch/platform.txt":        propertiesFile(platform),
+		"hardware/test/arch/cores/core/main.cpp": "int main() {}\n",
+		"sketch/sketch.ino":                      "void setup() {}\nvoid loop() {}\n",
+	}
+	for name, content := range files {
+		allFiles[name] = content
+	}
+	for name, content := range allFiles {
+		file := filepath.Join(root, filepath.FromSlash(name))
+		NoError(t, os.MkdirAll(filepath.Dir(file), os.FileMode(0755)))
+		NoError(t, utils.WriteFile(file, content))
+	}
+
+	return root
+}
+
+func propertiesFile(properties properties.Map) string {
+	var lines []string
+	for key, value := range properties {
+		lines = append(lines, key+"="+value)
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n") + "\n"
+}
+
 type ByLibraryName []*types.Library
 
 func (s ByLibraryName) Len() int {
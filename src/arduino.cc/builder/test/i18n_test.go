@@ -32,8 +32,11 @@ package test
 import (
 	"arduino.cc/builder/constants"
 	"arduino.cc/builder/i18n"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/stretchr/testify/require"
+	"strings"
 	"testing"
 )
 
@@ -69,4 +72,56 @@ func TestI18NInheritance(t *testing.T) {
 
 	logger = i18n.MachineLogger{}
 	logger.Println(constants.LOG_LEVEL_INFO, "good {0} {1}", "morning", "vietnam!")
+
+	logger = i18n.JSONLogger{}
+	logger.Println(constants.LOG_LEVEL_INFO, "good {0} {1}", "morning", "vietnam!")
+}
+
+func TestJSONLogger(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := i18n.JSONLogger{}
+
+	logger.Fprintln(&buffer, constants.LOG_LEVEL_WARN, constants.MSG_USING_LIBRARY, "SPI", "/lib/SPI folder", 3)
+	i18n.LogEvent(logger, &buffer, constants.EVENT_FILE_COMPILED, map[string]interface{}{"source": "a.cpp", "cached": true})
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	require.Equal(t, 2, len(lines))
+
+	var message map[string]interface{}
+	NoError(t, json.Unmarshal([]byte(lines[0]), &message))
+	require.Equal(t, "message", message["type"])
+	require.Equal(t, i18n.MessageId(constants.MSG_USING_LIBRARY), message["id"])
+	require.Equal(t, "warn", message["level"])
+	require.Equal(t, "Using library SPI in folder: /lib/SPI folder 3", message["message"])
+	require.Equal(t, constants.MSG_USING_LIBRARY, message["format"])
+	require.Equal(t, []interface{}{"SPI", "/lib/SPI folder", float64(3)}, message["args"])
+
+	var event map[string]interface{}
+	NoError(t, json.Unmarshal([]byte(lines[1]), &event))
+	require.Equal(t, "event", event["type"])
+	require.Equal(t, constants.EVENT_FILE_COMPILED, event["event"])
+	require.Equal(t, map[string]interface{}{"source": "a.cpp", "cached": true}, event["data"])
+}
+
+func TestJSONLoggerDoesntChangeData(t *testing.T) {
+	var buffer bytes.Buffer
+	data := map[string]interface{}{"value": complex(1, 2)}
+	i18n.LogEvent(i18n.JSONLogger{}, &buffer, constants.EVENT_PROGRESS, data)
+
+	var event map[string]interface{}
+	NoError(t, json.Unmarshal(buffer.Bytes(), &event))
+	require.Equal(t, map[string]interface{}{"value": "(1+2i)"}, event["data"])
+	require.Equal(t, complex(1, 2), data["value"])
+}
+
+func TestEventsAreIgnoredByOtherLoggers(t *testing.T) {
+	var buffer bytes.Buffer
+	i18n.LogEvent(i18n.HumanLogger{}, &buffer, constants.EVENT_PROGRESS, map[string]interface{}{"progress": 10})
+	i18n.LogEvent(i18n.MachineLogger{}, &buffer, constants.EVENT_PROGRESS, map[string]interface{}{"progress": 10})
+	require.Equal(t, 0, buffer.Len())
+}
+
+func TestMessageIdIsStable(t *testing.T) {
+	require.Equal(t, i18n.MessageId(constants.MSG_USING_LIBRARY), i18n.MessageId(constants.MSG_USING_LIBRARY))
+	require.NotEqual(t, i18n.MessageId(constants.MSG_USING_LIBRARY), i18n.MessageId(constants.MSG_USING_BOARD))
 }
@@ -0,0 +1,166 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"arduino.cc/builder"
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+	"arduino.cc/builder/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func writeLicensePolicy(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "license_policy")
+	NoError(t, err)
+	NoError(t, file.Close())
+	NoError(t, utils.WriteFile(file.Name(), content))
+	return file.Name()
+}
+
+func TestLicensePolicyAllows(t *testing.T) {
+	file := writeLicensePolicy(t, "allow=MIT, BSD-*, lgpl-*\ndeny=GPL-*\n")
+	defer os.Remove(file)
+
+	policy, err := builder.LoadLicensePolicy(file, i18n.HumanLogger{})
+	NoError(t, err)
+	require.Equal(t, []string{"mit", "bsd-*", "lgpl-*"}, policy.Allow)
+	require.Equal(t, []string{"gpl-*"}, policy.Deny)
+	require.Equal(t, builder.LICENSE_POLICY_ACTION_FAIL, policy.Disallowed)
+	require.Equal(t, builder.LICENSE_POLICY_ACTION_WARN, policy.Unspecified)
+
+	require.True(t, policy.Allows("MIT"))
+	require.True(t, policy.Allows("BSD-3-Clause"))
+	require.True(t, policy.Allows("LGPL-2.1 WITH LLVM-exception"))
+	require.True(t, policy.Allows("MIT OR GPL-3.0"))
+	require.True(t, policy.Allows("(MIT OR GPL-2.0) AND BSD-2-Clause"))
+	require.False(t, policy.Allows("GPL-3.0"))
+	require.False(t, policy.Allows("MIT AND GPL-3.0"))
+	require.False(t, policy.Allows("(GPL-2.0 OR GPL-3.0) AND MIT"))
+	require.False(t, policy.Allows("GNU General Public License"))
+	require.False(t, policy.Allows("Proprietary"))
+}
+
+func TestLicensePolicyOnlyDeny(t *testing.T) {
+	file := writeLicensePolicy(t, "deny=*GPL*, GNU *\n")
+	defer os.Remove(file)
+
+	policy, err := builder.LoadLicensePolicy(file, i18n.HumanLogger{})
+	NoError(t, err)
+	require.True(t, policy.Allows("Apache-2.0"))
+	require.True(t, policy.Allows("Proprietary"))
+	require.False(t, policy.Allows("AGPL-3.0"))
+	require.False(t, policy.Allows("GNU General Public License"))
+}
+
+func TestLicensePolicyMalformedExpressions(t *testing.T) {
+	file := writeLicensePolicy(t, "deny=GPL-*\n")
+	defer os.Remove(file)
+
+	policy, err := builder.LoadLicensePolicy(file, i18n.HumanLogger{})
+	NoError(t, err)
+
+	expressions := map[string]bool{
+		"MIT":                  true,
+		"(MIT OR Apache-2.0)":  true,
+		"MIT ) GPL-3.0-only":   false,
+		"MIT GPL-3.0":          false,
+		"(MIT OR Apache-2.0":   false,
+		"MIT OR":               false,
+		"AND MIT":              false,
+		"MIT AND () OR MIT":    false,
+		"MIT OR Apache-2.0 )":  false,
+		"(MIT) (Apache-2.0)":   false,
+		"MIT OR OR Apache-2.0": false,
+	}
+	for expression, allowed := range expressions {
+		require.Equal(t, allowed, policy.Allows(expression), expression)
+	}
+}
+
+func TestLoadLicensePolicyInvalid(t *testing.T) {
+	file := writeLicensePolicy(t, "allow=MIT\ndisallowed=maybe\n")
+	defer os.Remove(file)
+	_, err := builder.LoadLicensePolicy(file, i18n.HumanLogger{})
+	require.Error(t, err)
+	require.Equal(t, file+": invalid value maybe for disallowed, use allow, warn or fail", err.Error())
+
+	NoError(t, utils.WriteFile(file, "allowed=MIT\n"))
+	_, err = builder.LoadLicensePolicy(file, i18n.HumanLogger{})
+	require.Error(t, err)
+	require.Equal(t, file+": unknown key allowed, use allow, deny, disallowed or unspecified", err.Error())
+}
+
+func TestCheckLicensePolicy(t *testing.T) {
+	root, err := ioutil.TempDir("", "license_policy")
+	NoError(t, err)
+	defer os.RemoveAll(root)
+
+	mit := &types.Library{Name: "Mit", Folder: filepath.Join(root, "Mit"), License: "MIT"}
+	gpl := &types.Library{Name: "Gpl", Folder: filepath.Join(root, "Gpl"), License: "GPL-3.0"}
+	unspecified := &types.Library{Name: "Unspecified", Folder: filepath.Join(root, "Unspecified"), License: constants.LIB_LICENSE_UNSPECIFIED}
+	sketch := filepath.Join(root, "sketch", "sketch.ino.cpp")
+	newContext := func(policy string) *types.Context {
+		file := filepath.Join(root, "policy.txt")
+		NoError(t, utils.WriteFile(file, policy))
+		ctx := &types.Context{
+			LicensePolicyFile: file,
+			ImportedLibraries: []*types.Library{mit, gpl, unspecified},
+			ImportedLibrariesIncludes: map[*types.Library]types.LibraryInclude{
+				mit:         {Include: "Mit.h", SourceFile: sketch},
+				gpl:         {Include: "Gpl.h", SourceFile: sketch},
+				unspecified: {Include: "Unspecified.h", SourceFile: filepath.Join(root, "Gpl", "Gpl.cpp")},
+			},
+		}
+		ctx.SetLogger(i18n.NoopLogger{})
+		return ctx
+	}
+
+	err = (&builder.CheckLicensePolicy{}).Run(newContext("deny=GPL-*\nunspecified=fail\n"))
+	require.Error(t, err)
+	require.Equal(t, "2 of the libraries used don't comply with the license policy "+filepath.Join(root, "policy.txt"), err.Error())
+
+	err = (&builder.CheckLicensePolicy{}).Run(newContext("deny=GPL-*\ndisallowed=warn\n"))
+	NoError(t, err)
+
+	err = (&builder.CheckLicensePolicy{}).Run(newContext("allow=MIT, GPL-*\nunspecified=allow\n"))
+	NoError(t, err)
+
+	// No policy, no check
+	ctx := newContext("")
+	ctx.LicensePolicyFile = ""
+	NoError(t, (&builder.CheckLicensePolicy{}).Run(ctx))
+}
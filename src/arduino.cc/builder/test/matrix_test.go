@@ -0,0 +1,117 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"arduino.cc/builder"
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+	"arduino.cc/builder/utils"
+	"arduino.cc/properties"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadMatrixFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "matrix")
+	NoError(t, err)
+	defer os.RemoveAll(file.Name())
+	file.Close()
+
+	NoError(t, utils.WriteFile(file.Name(), "# boards\narduino:avr:uno\n\n  arduino:avr:mega cpu=atmega1280\narduino:avr:nano:cpu=atmega328old speed=fast\n"))
+	fqbns, err := builder.ReadMatrixFile(file.Name(), i18n.HumanLogger{})
+	NoError(t, err)
+	require.Equal(t, []string{"arduino:avr:uno", "arduino:avr:mega:cpu=atmega1280", "arduino:avr:nano:cpu=atmega328old,speed=fast"}, fqbns)
+
+	NoError(t, utils.WriteFile(file.Name(), "arduino:avr:uno\narduino:avr:mega atmega1280\n"))
+	_, err = builder.ReadMatrixFile(file.Name(), i18n.HumanLogger{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), file.Name()+":2: ")
+}
+
+func TestMatrix(t *testing.T) {
+	root := PrepareTestPlatform(t, properties.Map{
+		"good.name":                "Good",
+		"good.build.core":          "core",
+		"good.build.board":         "GOOD",
+		"good.build.link":          "touch",
+		"good.upload.maximum_size": "2000",
+		"bad.name":                 "Bad",
+		"bad.build.core":           "core",
+		"bad.build.board":          "BAD",
+		"bad.build.link":           "false",
+		"bad.upload.maximum_size":  "2000",
+	}, properties.Map{
+		"recipe.c.combine.pattern": "{build.link} \"{build.path}/{build.project_name}.elf\"",
+		"recipe.size.pattern":      "echo size 1234",
+		"recipe.size.regex":        "^size ([0-9]+)",
+	}, nil)
+	defer os.RemoveAll(root)
+	buildPath := filepath.Join(root, "build")
+
+	ctx := &types.Context{
+		HardwareFolders:   []string{filepath.Join(root, "hardware")},
+		SketchLocation:    filepath.Join(root, "sketch", "sketch.ino"),
+		ArduinoAPIVersion: "10600",
+		BuildPath:         buildPath,
+	}
+	ctx.SetLogger(i18n.NoopLogger{})
+
+	matrix := &builder.Matrix{FQBNs: []string{"test:arch:bad", "test:arch:good", "test:arch:good"}}
+	err := matrix.Run(ctx)
+	require.Error(t, err)
+	require.Equal(t, "1 of 3 builds failed", err.Error())
+
+	require.Equal(t, 3, len(matrix.Results))
+	require.False(t, matrix.Results[0].Success)
+	require.Equal(t, filepath.Join(buildPath, "test_arch_bad"), matrix.Results[0].BuildPath)
+	require.True(t, matrix.Results[1].Success)
+	require.Equal(t, filepath.Join(buildPath, "test_arch_good"), matrix.Results[1].BuildPath)
+	require.Equal(t, 1234, matrix.Results[1].Size.Text)
+	require.Equal(t, 2000, matrix.Results[1].Size.MaxText)
+	require.True(t, matrix.Results[2].Success)
+	require.Equal(t, filepath.Join(buildPath, "test_arch_good_2"), matrix.Results[2].BuildPath)
+
+	_, err = os.Stat(filepath.Join(buildPath, "test_arch_good", "sketch.ino.elf"))
+	NoError(t, err)
+
+	bytes, err := ioutil.ReadFile(filepath.Join(buildPath, constants.FILE_MATRIX_RESULT))
+	NoError(t, err)
+	var results []*builder.BuildResult
+	NoError(t, json.Unmarshal(bytes, &results))
+	require.Equal(t, 3, len(results))
+	require.Equal(t, "test:arch:bad", results[0].FQBN)
+}
@@ -0,0 +1,161 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"arduino.cc/builder/builder_utils"
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/objectcache"
+	"arduino.cc/builder/types"
+	"arduino.cc/builder/utils"
+	"arduino.cc/properties"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirObjectCache(t *testing.T) {
+	folder, err := ioutil.TempDir("", "objectcache")
+	NoError(t, err)
+	defer os.RemoveAll(folder)
+
+	cache := objectcache.Open(folder)
+	data, err := cache.Get("abcdef")
+	NoError(t, err)
+	require.Nil(t, data)
+
+	NoError(t, cache.Put("abcdef", []byte("object")))
+	data, err = cache.Get("abcdef")
+	NoError(t, err)
+	require.Equal(t, "object", string(data))
+
+	_, err = os.Stat(filepath.Join(folder, "ab", "abcdef"))
+	NoError(t, err)
+}
+
+func TestHTTPObjectCache(t *testing.T) {
+	var lock sync.Mutex
+	stored := make(map[string][]byte)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		switch r.Method {
+		case http.MethodGet:
+			data, ok := stored[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(data)
+		case http.MethodPut:
+			data, _ := ioutil.ReadAll(r.Body)
+			stored[r.URL.Path] = data
+		}
+	}))
+	defer server.Close()
+
+	cache := objectcache.Open(server.URL + "/cache/")
+	data, err := cache.Get("abcdef")
+	NoError(t, err)
+	require.Nil(t, data)
+
+	NoError(t, cache.Put("abcdef", []byte("object")))
+	require.Equal(t, "object", string(stored["/cache/abcdef"]))
+	data, err = cache.Get("abcdef")
+	NoError(t, err)
+	require.Equal(t, "object", string(data))
+}
+
+func TestCompileFilesUsesObjectCache(t *testing.T) {
+	sourcePath := prepareSourceFolder(t, "a.c", "a.h")
+	defer os.RemoveAll(sourcePath)
+	cacheFolder, err := ioutil.TempDir("", "objectcache")
+	NoError(t, err)
+	defer os.RemoveAll(cacheFolder)
+	compilations := filepath.Join(cacheFolder, "compilations")
+
+	ctx := &types.Context{ObjectCache: &objectcache.Dir{Folder: filepath.Join(cacheFolder, "objects")}}
+	ctx.SetLogger(i18n.NoopLogger{})
+
+	source := filepath.Join(sourcePath, "a.c")
+	header := filepath.Join(sourcePath, "a.h")
+	compile := func() string {
+		buildPath, err := ioutil.TempDir("", "build")
+		NoError(t, err)
+		object := filepath.Join(buildPath, "a.c.o")
+		dependencies := filepath.Join(buildPath, "a.c.d")
+
+		// Writes the object and its dependencies, like gcc -MMD
+		buildProperties := make(properties.Map)
+		buildProperties[constants.RECIPE_C_PATTERN] = "sh -c \"echo >> " + compilations + " && cat {source_file} " + header + " > {object_file} && echo {object_file}: {source_file} " + header + " > " + dependencies + "\""
+
+		objectFiles, err := builder_utils.CompileFiles(ctx, []string{}, sourcePath, false, buildPath, buildProperties, []string{})
+		NoError(t, err)
+		require.Equal(t, []string{object}, objectFiles)
+
+		bytes, err := ioutil.ReadFile(object)
+		NoError(t, err)
+		require.True(t, strings.HasPrefix(string(bytes), "a.c"))
+		_, err = os.Stat(dependencies)
+		NoError(t, err)
+		return buildPath
+	}
+	compilationsCount := func() int {
+		bytes, err := ioutil.ReadFile(compilations)
+		NoError(t, err)
+		return len(bytes)
+	}
+
+	buildPath := compile()
+	defer os.RemoveAll(buildPath)
+	require.Equal(t, 1, compilationsCount())
+
+	// Same source and header in another build path
+	buildPath = compile()
+	defer os.RemoveAll(buildPath)
+	require.Equal(t, 1, compilationsCount())
+	upToDate, err := builder_utils.ObjFileIsUpToDate(source, filepath.Join(buildPath, "a.c.o"), filepath.Join(buildPath, "a.c.d"))
+	NoError(t, err)
+	require.True(t, upToDate)
+
+	// The header changed
+	NoError(t, utils.WriteFile(header, "changed"))
+	buildPath = compile()
+	defer os.RemoveAll(buildPath)
+	require.Equal(t, 2, compilationsCount())
+}
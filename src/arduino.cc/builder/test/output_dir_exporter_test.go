@@ -0,0 +1,145 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"arduino.cc/builder"
+	"arduino.cc/builder/builder_utils"
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+	"arduino.cc/builder/utils"
+	"arduino.cc/properties"
+	"github.com/stretchr/testify/require"
+)
+
+func readOutputDirManifest(t *testing.T, outputDir string) *builder.OutputDirManifest {
+	bytes, err := ioutil.ReadFile(filepath.Join(outputDir, constants.FILE_OUTPUT_DIR_MANIFEST))
+	NoError(t, err)
+	manifest := &builder.OutputDirManifest{}
+	NoError(t, json.Unmarshal(bytes, manifest))
+	return manifest
+}
+
+func TestOutputDirExporter(t *testing.T) {
+	root, err := ioutil.TempDir("", "output_dir")
+	NoError(t, err)
+	defer os.RemoveAll(root)
+
+	buildPath := filepath.Join(root, "build")
+	outputDir := filepath.Join(root, "output")
+	NoError(t, os.MkdirAll(filepath.Join(buildPath, "sketch"), os.FileMode(0755)))
+	NoError(t, utils.WriteFile(filepath.Join(buildPath, "sketch", "sketch.ino.cpp"), "not an artifact"))
+	// Left by previous builds, or describing the build
+	NoError(t, utils.WriteFile(filepath.Join(buildPath, "sketch.ino.map"), "old map"))
+	NoError(t, utils.WriteFile(filepath.Join(buildPath, "sketch.ino.tmp.bin"), "interrupted"))
+	NoError(t, utils.WriteFile(filepath.Join(buildPath, "sketch.ino.spdx.json"), "{}"))
+
+	ctx := &types.Context{
+		SketchLocation: filepath.Join(root, "sketch", "sketch.ino"),
+		FQBN:           "arduino:avr:uno",
+		BuildPath:      buildPath,
+		BuildProperties: properties.Map{
+			constants.BUILD_PROPERTIES_BUILD_PATH:         buildPath,
+			constants.BUILD_PROPERTIES_BUILD_PROJECT_NAME: "sketch.ino",
+			"version": "1.2.3",
+		},
+		TargetBoard: &types.Board{BoardId: "uno"},
+		OutputDir:   outputDir,
+		OutputName:  "{project}-{board}-{version}",
+	}
+	ctx.SetLogger(i18n.NoopLogger{})
+
+	NoError(t, builder_utils.RunWithTemporaryProjectName(ctx, ctx.BuildProperties, nil, func(buildProperties properties.Map) error {
+		return utils.WriteFile(filepath.Join(buildPath, buildProperties[constants.BUILD_PROPERTIES_BUILD_PROJECT_NAME]+".hex"), "hex")
+	}))
+	NoError(t, utils.WriteFile(filepath.Join(buildPath, "sketch.ino.with_bootloader.hex"), "bootloader"))
+	ctx.AddProjectOutput(filepath.Join(buildPath, "sketch.ino.with_bootloader.hex"))
+
+	NoError(t, (&builder.OutputDirExporter{}).Run(ctx))
+
+	bytes, err := ioutil.ReadFile(filepath.Join(outputDir, "sketch-uno-1.2.3.with_bootloader.hex"))
+	NoError(t, err)
+	require.Equal(t, "bootloader", string(bytes))
+
+	manifest := readOutputDirManifest(t, outputDir)
+	require.Equal(t, 2, len(manifest.Files))
+	require.Equal(t, "sketch-uno-1.2.3.hex", manifest.Files[0].Name)
+	require.Equal(t, int64(3), manifest.Files[0].Size)
+	// sha256 of "hex"
+	require.Equal(t, "128df13c1e54ffaaafcc9d07ec7427d61f764214e6ae0321de23c94d261d0860", manifest.Files[0].SHA256)
+	require.Equal(t, "arduino:avr:uno", manifest.Files[0].FQBN)
+	require.Equal(t, "sketch-uno-1.2.3.with_bootloader.hex", manifest.Files[1].Name)
+
+	// Exports with other names are added to the manifest, the ones of
+	// deleted files are dropped
+	NoError(t, os.Remove(filepath.Join(outputDir, "sketch-uno-1.2.3.with_bootloader.hex")))
+	ctx.BuildProperties["version"] = "1.2.4"
+	NoError(t, (&builder.OutputDirExporter{}).Run(ctx))
+
+	var names []string
+	for _, file := range readOutputDirManifest(t, outputDir).Files {
+		names = append(names, file.Name)
+	}
+	require.Equal(t, []string{"sketch-uno-1.2.3.hex", "sketch-uno-1.2.4.hex", "sketch-uno-1.2.4.with_bootloader.hex"}, names)
+}
+
+func TestOutputName(t *testing.T) {
+	ctx := &types.Context{
+		BuildProperties: properties.Map{
+			constants.BUILD_PROPERTIES_BUILD_PROJECT_NAME: "sketch.ino",
+			"build.board": "AVR/UNO",
+		},
+		TargetBoard:    &types.Board{BoardId: "uno"},
+		TargetPackage:  &types.Package{PackageId: "arduino"},
+		TargetPlatform: &types.Platform{PlatformId: "avr"},
+	}
+	ctx.SetLogger(i18n.NoopLogger{})
+
+	name, err := builder.OutputName(ctx)
+	NoError(t, err)
+	require.Equal(t, "sketch", name)
+
+	ctx.OutputName = "{vendor}-{arch}-{board}-{build.board}"
+	name, err = builder.OutputName(ctx)
+	NoError(t, err)
+	require.Equal(t, "arduino-avr-uno-AVR_UNO", name)
+
+	ctx.OutputName = "{project}-{release}"
+	_, err = builder.OutputName(ctx)
+	require.Error(t, err)
+	require.Equal(t, "Output name {project}-{release}: {release} is not set", err.Error())
+}
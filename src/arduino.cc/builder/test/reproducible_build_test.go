@@ -0,0 +1,181 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package test
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"arduino.cc/builder"
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+	"arduino.cc/properties"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetReproducibleBuildProperties(t *testing.T) {
+	defer os.Unsetenv(constants.ENV_SOURCE_DATE_EPOCH)
+	NoError(t, os.Setenv(constants.ENV_SOURCE_DATE_EPOCH, "1500000000"))
+
+	sketchFolder := filepath.Join(os.TempDir(), "sketchbook", "sketch")
+	ctx := &types.Context{
+		Reproducible:          true,
+		BuildPath:             filepath.Join(sketchFolder, "build"),
+		OtherLibrariesFolders: []string{filepath.Join(os.TempDir(), "sketchbook", "libraries")},
+		BuildProperties: properties.Map{
+			constants.BUILD_PROPERTIES_SOURCE_PATH:            sketchFolder,
+			constants.BUILD_PROPERTIES_COMPILER_C_EXTRA_FLAGS: "-DEXTRA",
+			constants.BUILD_PROPERTIES_EXTRA_TIME_UTC:         "1",
+		},
+	}
+	ctx.SetLogger(i18n.NoopLogger{})
+
+	NoError(t, (&builder.SetReproducibleBuildProperties{}).Run(ctx))
+
+	buildProperties := ctx.BuildProperties
+	require.Equal(t, "1500000000", buildProperties[constants.BUILD_PROPERTIES_EXTRA_TIME_UTC])
+	require.Equal(t, "1500000000", buildProperties[constants.BUILD_PROPERTIES_EXTRA_TIME_LOCAL])
+	require.Equal(t, "0", buildProperties[constants.BUILD_PROPERTIES_EXTRA_TIME_ZONE])
+	require.Equal(t, "-DEXTRA {build.prefix_map.flags}", buildProperties[constants.BUILD_PROPERTIES_COMPILER_C_EXTRA_FLAGS])
+	require.Equal(t, "{build.prefix_map.flags}", buildProperties[constants.BUILD_PROPERTIES_COMPILER_CPP_EXTRA_FLAGS])
+
+	// The build path is in the sketch folder: it must come after it
+	flags := buildProperties[constants.BUILD_PROPERTIES_BUILD_PREFIX_MAP_FLAGS]
+	sketchFlag := "\"-ffile-prefix-map=" + sketchFolder + "=/sketch\""
+	buildPathFlag := "\"-ffile-prefix-map=" + ctx.BuildPath + "=/build\""
+	librariesFlag := "\"-ffile-prefix-map=" + ctx.OtherLibrariesFolders[0] + "=/libraries\""
+	require.Contains(t, flags, librariesFlag)
+	require.Contains(t, flags, sketchFlag)
+	require.Contains(t, flags, buildPathFlag)
+	require.True(t, strings.Index(flags, sketchFlag) < strings.Index(flags, buildPathFlag))
+}
+
+func TestSetReproducibleBuildPropertiesInvalidSourceDateEpoch(t *testing.T) {
+	defer os.Unsetenv(constants.ENV_SOURCE_DATE_EPOCH)
+	NoError(t, os.Setenv(constants.ENV_SOURCE_DATE_EPOCH, "yesterday"))
+
+	ctx := &types.Context{Reproducible: true, BuildProperties: properties.Map{}}
+	ctx.SetLogger(i18n.NoopLogger{})
+
+	require.Error(t, (&builder.SetReproducibleBuildProperties{}).Run(ctx))
+}
+
+func TestVerifyReproducibleBuild(t *testing.T) {
+	root := PrepareTestPlatform(t, properties.Map{
+		"same.name":            "Same",
+		"same.build.core":      "core",
+		"same.build.link":      "sh -c \"echo {extra.time.utc} > {build.path}/{build.project_name}.elf\"",
+		"different.name":       "Different",
+		"different.build.core": "core",
+		"different.build.link": "sh -c \"echo {build.path} > {build.path}/{build.project_name}.elf\"",
+	}, properties.Map{
+		"recipe.c.combine.pattern": "{build.link}",
+	}, nil)
+	defer os.RemoveAll(root)
+
+	verify := func(fqbn string) error {
+		buildPath := filepath.Join(root, "build", fqbn[strings.LastIndex(fqbn, ":")+1:])
+		NoError(t, os.MkdirAll(buildPath, os.FileMode(0755)))
+		ctx := &types.Context{
+			HardwareFolders:   []string{filepath.Join(root, "hardware")},
+			SketchLocation:    filepath.Join(root, "sketch", "sketch.ino"),
+			FQBN:              fqbn,
+			ArduinoAPIVersion: "10600",
+			BuildPath:         buildPath,
+		}
+		ctx.SetLogger(i18n.NoopLogger{})
+		command := &builder.VerifyReproducibleBuild{}
+		err := command.Run(ctx)
+		require.Equal(t, 2, len(command.Results))
+		require.NotEqual(t, command.Results[0].BuildPath, command.Results[1].BuildPath)
+		return err
+	}
+
+	NoError(t, verify("test:arch:same"))
+	err := verify("test:arch:different")
+	require.Error(t, err)
+	require.Equal(t, "1 of the 1 artifacts differ between the two builds", err.Error())
+}
+
+func TestVerifyReproducibleBuildFromStdin(t *testing.T) {
+	root := PrepareTestPlatform(t, properties.Map{
+		"same.name":       "Same",
+		"same.build.core": "core",
+	}, properties.Map{
+		"recipe.c.combine.pattern": "touch \"{build.path}/{build.project_name}.elf\"",
+	}, nil)
+	defer os.RemoveAll(root)
+
+	archive := filepath.Join(root, "Blink.tar")
+	file, err := os.Create(archive)
+	NoError(t, err)
+	writer := tar.NewWriter(file)
+	content := "void setup() {}\nvoid loop() {}\n"
+	NoError(t, writer.WriteHeader(&tar.Header{Name: "Blink/Blink.ino", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+	_, err = writer.Write([]byte(content))
+	NoError(t, err)
+	NoError(t, writer.Close())
+	NoError(t, file.Close())
+
+	stdin, err := os.Open(archive)
+	NoError(t, err)
+	defer stdin.Close()
+	oldStdin := os.Stdin
+	os.Stdin = stdin
+	defer func() { os.Stdin = oldStdin }()
+
+	workingDir := filepath.Join(root, "cwd")
+	NoError(t, os.MkdirAll(workingDir, os.FileMode(0755)))
+	oldWorkingDir, err := os.Getwd()
+	NoError(t, err)
+	NoError(t, os.Chdir(workingDir))
+	defer os.Chdir(oldWorkingDir)
+
+	ctx := &types.Context{
+		HardwareFolders:   []string{filepath.Join(root, "hardware")},
+		SketchLocation:    builder.SKETCH_ARCHIVE_STDIN,
+		FQBN:              "test:arch:same",
+		ArduinoAPIVersion: "10600",
+	}
+	ctx.SetLogger(i18n.NoopLogger{})
+	err = (&builder.VerifyReproducibleBuild{}).Run(ctx)
+	defer os.RemoveAll(ctx.BuildPath)
+	NoError(t, err)
+
+	// The archive is unpacked in the generated build path
+	require.NotEqual(t, "", ctx.BuildPath)
+	require.Equal(t, filepath.Join(ctx.BuildPath, constants.FOLDER_SKETCH_ARCHIVE, "Blink", "Blink.ino"), ctx.SketchLocation)
+	_, err = os.Stat(filepath.Join(workingDir, constants.FOLDER_SKETCH_ARCHIVE))
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,170 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"arduino.cc/builder"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+	"arduino.cc/properties"
+	"github.com/stretchr/testify/require"
+)
+
+// Builds a sketch using a library with a platform copying files instead
+// of compiling them, and only preprocessing them with g++, writing the
+// bill of materials in the given format. Returns the temporary folder
+// of the build and the bill of materials
+func buildWithSBOM(t *testing.T, format string) (string, map[string]interface{}) {
+	root := PrepareTestPlatform(t, properties.Map{
+		"board.name":          "Board",
+		"board.build.core":    "core",
+		"board.build.variant": "standard",
+	}, properties.Map{
+		"version":            "1.2.3",
+		"recipe.c.o.pattern": "cp \"{source_file}\" \"{object_file}\"",
+		// Libraries are found by the errors of the preprocessor
+		"recipe.preproc.macros":    "g++ -w -x c++ -E {includes} \"{source_file}\" -o \"{preprocessed_file_path}\"",
+		"recipe.c.combine.pattern": "cp \"{archive_file_path}\" \"{build.path}/{build.project_name}.elf\"",
+	}, map[string]string{
+		"hardware/test/arch/cores/core/Arduino.h":        "",
+		"hardware/test/arch/variants/standard/variant.c": "int variant;\n",
+		"libraries/Lib/library.properties": strings.Join([]string{
+			"name=Lib",
+			"version=2.0.0",
+			"author=Someone",
+			"maintainer=Someone",
+			"license=MIT",
+			"url=https://example.com/lib",
+		}, "\n"),
+		"libraries/Lib/src/Lib.h":   "void lib();\n",
+		"libraries/Lib/src/Lib.cpp": "void lib() {}\n",
+		"sketch/sketch.ino":         "#include <Lib.h>\nvoid setup() {}\nvoid loop() {}\n",
+	})
+
+	buildPath := filepath.Join(root, "build")
+	NoError(t, os.MkdirAll(buildPath, os.FileMode(0755)))
+	ctx := &types.Context{
+		HardwareFolders:       []string{filepath.Join(root, "hardware")},
+		OtherLibrariesFolders: []string{filepath.Join(root, "libraries")},
+		SketchLocation:        filepath.Join(root, "sketch", "sketch.ino"),
+		FQBN:                  "test:arch:board",
+		ArduinoAPIVersion:     "10600",
+		BuildPath:             buildPath,
+		SBOMFormat:            format,
+	}
+	ctx.SetLogger(i18n.NoopLogger{})
+	NoError(t, builder.RunBuilder(ctx))
+
+	suffix := builder.SBOM_SPDX_SUFFIX
+	if format == builder.SBOM_CYCLONEDX {
+		suffix = builder.SBOM_CYCLONEDX_SUFFIX
+	}
+	bytes, err := ioutil.ReadFile(filepath.Join(buildPath, "sketch.ino"+suffix))
+	NoError(t, err)
+	var document map[string]interface{}
+	NoError(t, json.Unmarshal(bytes, &document))
+	return root, document
+}
+
+func TestSBOMSPDX(t *testing.T) {
+	root, document := buildWithSBOM(t, builder.SBOM_SPDX)
+	defer os.RemoveAll(root)
+
+	require.Equal(t, "SPDX-2.3", document["spdxVersion"])
+	require.Equal(t, "FQBN: test:arch:board", document["comment"])
+
+	packages := make(map[string]map[string]interface{})
+	for _, aPackage := range document["packages"].([]interface{}) {
+		aPackage := aPackage.(map[string]interface{})
+		packages[aPackage["name"].(string)] = aPackage
+	}
+	require.Equal(t, 4, len(packages))
+	require.Equal(t, "Arduino sketch", packages["sketch"]["description"])
+	require.Equal(t, "Arduino core", packages["core"]["description"])
+	require.Equal(t, "1.2.3", packages["core"]["versionInfo"])
+	require.Equal(t, "Organization: test", packages["core"]["supplier"])
+	require.Equal(t, "Arduino variant", packages["standard"]["description"])
+	require.Equal(t, "2.0.0", packages["Lib"]["versionInfo"])
+	require.Equal(t, "Person: Someone", packages["Lib"]["supplier"])
+	require.Equal(t, "MIT", packages["Lib"]["licenseDeclared"])
+	require.Equal(t, "https://example.com/lib", packages["Lib"]["homepage"])
+
+	files := make(map[string]string)
+	for _, file := range document["files"].([]interface{}) {
+		file := file.(map[string]interface{})
+		checksums := file["checksums"].([]interface{})
+		files[file["fileName"].(string)] = checksums[1].(map[string]interface{})["checksumValue"].(string)
+	}
+	// sha256 of "void lib() {}\n"
+	require.Equal(t, "c2e796bc157dda70484a5169fac492befd4eb6f82cc7895503a21eb914e97b63", files["./libraries/Lib/src/Lib.cpp"])
+	require.Contains(t, files, "./sketch/sketch.ino")
+	require.Contains(t, files, "./cores/core/main.cpp")
+	require.Contains(t, files, "./variants/standard/variant.c")
+	require.Contains(t, files, "./build/sketch.ino.elf")
+	require.NotContains(t, files, "./libraries/Lib/src/Lib.h")
+}
+
+func TestSBOMCycloneDX(t *testing.T) {
+	root, document := buildWithSBOM(t, builder.SBOM_CYCLONEDX)
+	defer os.RemoveAll(root)
+
+	require.Equal(t, "CycloneDX", document["bomFormat"])
+	metadata := document["metadata"].(map[string]interface{})
+	require.Equal(t, []interface{}{map[string]interface{}{"name": "arduino:fqbn", "value": "test:arch:board"}}, metadata["properties"])
+	sketch := metadata["component"].(map[string]interface{})
+	require.Equal(t, "firmware", sketch["type"])
+	var sketchFiles []string
+	for _, file := range sketch["components"].([]interface{}) {
+		sketchFiles = append(sketchFiles, file.(map[string]interface{})["name"].(string))
+	}
+	require.Equal(t, []string{"sketch/sketch.ino", "build/sketch.ino.elf"}, sketchFiles)
+
+	var refs []string
+	for _, component := range document["components"].([]interface{}) {
+		component := component.(map[string]interface{})
+		refs = append(refs, component["bom-ref"].(string))
+		if component["name"] == "Lib" {
+			require.Equal(t, "library", component["type"])
+			require.Equal(t, "2.0.0", component["version"])
+			require.Equal(t, []interface{}{map[string]interface{}{"expression": "MIT"}}, component["licenses"])
+		}
+	}
+	require.Equal(t, []string{"Core-core", "Variant-standard", "Library-Lib"}, refs)
+	dependencies := document["dependencies"].([]interface{})
+	require.Equal(t, 1, len(dependencies))
+	require.Equal(t, "Sketch-sketch", dependencies[0].(map[string]interface{})["ref"])
+}
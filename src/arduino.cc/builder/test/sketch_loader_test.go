@@ -30,11 +30,21 @@
 package test
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"testing"
 
 	"arduino.cc/builder"
+	"arduino.cc/builder/builder_utils"
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
 	"arduino.cc/builder/types"
+	"arduino.cc/builder/utils"
+	"arduino.cc/properties"
 	"github.com/stretchr/testify/require"
 )
 
@@ -175,3 +185,169 @@ func TestLoadSketchWithMacOSXGarbage(t *testing.T) {
 	require.Equal(t, 0, len(sketch.AdditionalFiles))
 	require.Equal(t, 0, len(sketch.OtherSketchFiles))
 }
+
+func writeZipSketchArchive(t *testing.T, path string, files map[string]string) {
+	file, err := os.Create(path)
+	NoError(t, err)
+	defer file.Close()
+	writer := zip.NewWriter(file)
+	for name, content := range files {
+		entry, err := writer.Create(name)
+		NoError(t, err)
+		_, err = entry.Write([]byte(content))
+		NoError(t, err)
+	}
+	NoError(t, writer.Close())
+}
+
+func TestLoadSketchFromZip(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "archive")
+	NoError(t, err)
+	defer os.RemoveAll(tempPath)
+
+	archive := filepath.Join(tempPath, "upload.zip")
+	writeZipSketchArchive(t, archive, map[string]string{
+		"Blink/Blink.ino":         "void setup() {}",
+		"Blink/other.ino":         "void loop() {}",
+		"Blink/src/helper.cpp":    "",
+		"__MACOSX/Blink/._helper": "",
+	})
+
+	ctx := &types.Context{SketchLocation: archive, BuildPath: tempPath}
+	NoError(t, (&builder.SketchLoader{}).Run(ctx))
+
+	sketchFolder := filepath.Join(tempPath, constants.FOLDER_SKETCH_ARCHIVE, "Blink")
+	require.Equal(t, filepath.Join(sketchFolder, "Blink.ino"), ctx.SketchLocation)
+	require.Equal(t, "void setup() {}", ctx.Sketch.MainFile.Source)
+	require.Equal(t, 1, len(ctx.Sketch.OtherSketchFiles))
+	require.Equal(t, 1, len(ctx.Sketch.AdditionalFiles))
+	require.Equal(t, filepath.Join(sketchFolder, "src", "helper.cpp"), ctx.Sketch.AdditionalFiles[0].Name)
+
+	// Unpacking again only removes what's not in the archive anymore
+	writeZipSketchArchive(t, archive, map[string]string{
+		"Blink/Blink.ino": "void setup() {}",
+	})
+	ctx = &types.Context{SketchLocation: archive, BuildPath: tempPath}
+	NoError(t, (&builder.SketchLoader{}).Run(ctx))
+	require.Equal(t, 0, len(ctx.Sketch.OtherSketchFiles))
+	require.Equal(t, 0, len(ctx.Sketch.AdditionalFiles))
+}
+
+func TestSketchFromZipInCompilerMessages(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "archive")
+	NoError(t, err)
+	defer os.RemoveAll(tempPath)
+
+	archive := filepath.Join(tempPath, "upload.zip")
+	writeZipSketchArchive(t, archive, map[string]string{
+		"Blink/Blink.ino": "void setup() {}",
+	})
+
+	ctx := &types.Context{SketchLocation: archive, BuildPath: tempPath}
+	ctx.SetLogger(i18n.NoopLogger{})
+	NoError(t, (&builder.SketchLoader{}).Run(ctx))
+	NoError(t, (&builder.SketchSourceMerger{}).Run(ctx))
+
+	// The #line directives name the unpacked files, that debuggers can
+	// find
+	require.Contains(t, ctx.Source, "#line 1 "+utils.QuoteCppString(ctx.SketchLocation))
+
+	buildProperties := properties.Map{
+		"recipe.test.pattern": "sh -c \"echo {source_file}:1:14: error >&2\"",
+		"source_file":         ctx.SketchLocation,
+	}
+	output, err := builder_utils.ExecRecipeCollectStdErr(ctx, buildProperties, "recipe.test.pattern", false, false, false)
+	NoError(t, err)
+	require.Equal(t, archive+":Blink/Blink.ino:1:14: error\n", output)
+}
+
+func TestBuildSketchFromZipForAnotherBoard(t *testing.T) {
+	root := PrepareTestPlatform(t, properties.Map{
+		"one.name":       "One",
+		"one.build.core": "core",
+		"two.name":       "Two",
+		"two.build.core": "core",
+	}, properties.Map{
+		"recipe.c.combine.pattern": "touch \"{build.path}/{build.project_name}.elf\"",
+	}, nil)
+	defer os.RemoveAll(root)
+
+	archive := filepath.Join(root, "Blink.zip")
+	writeZipSketchArchive(t, archive, map[string]string{
+		"Blink/Blink.ino": "#include \"conf.h\"\nvoid setup() {}\nvoid loop() {}\n",
+		"Blink/conf.h":    "",
+	})
+	buildPath := filepath.Join(root, "build")
+	NoError(t, os.MkdirAll(buildPath, os.FileMode(0755)))
+
+	// Changing the board wipes the build path, but not the sketch
+	// just unpacked in it
+	for _, fqbn := range []string{"test:arch:one", "test:arch:two"} {
+		ctx := &types.Context{
+			HardwareFolders:   []string{filepath.Join(root, "hardware")},
+			SketchLocation:    archive,
+			FQBN:              fqbn,
+			ArduinoAPIVersion: "10600",
+			BuildPath:         buildPath,
+		}
+		ctx.SetLogger(i18n.NoopLogger{})
+		NoError(t, builder.RunBuilder(ctx))
+	}
+	_, err := os.Stat(filepath.Join(buildPath, constants.FOLDER_SKETCH, "conf.h"))
+	NoError(t, err)
+}
+
+func TestLoadSketchFromTarGz(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "archive")
+	NoError(t, err)
+	defer os.RemoveAll(tempPath)
+
+	archive := filepath.Join(tempPath, "upload.tgz")
+	file, err := os.Create(archive)
+	NoError(t, err)
+	gzipWriter := gzip.NewWriter(file)
+	writer := tar.NewWriter(gzipWriter)
+	for name, content := range map[string]string{"sketch.ino": "void setup() {}", "config.h": "#define X"} {
+		NoError(t, writer.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err = writer.Write([]byte(content))
+		NoError(t, err)
+	}
+	NoError(t, writer.Close())
+	NoError(t, gzipWriter.Close())
+	NoError(t, file.Close())
+
+	ctx := &types.Context{SketchLocation: archive, BuildPath: tempPath}
+	NoError(t, (&builder.SketchLoader{}).Run(ctx))
+
+	sketchFolder := filepath.Join(tempPath, constants.FOLDER_SKETCH_ARCHIVE, "sketch")
+	require.Equal(t, filepath.Join(sketchFolder, "sketch.ino"), ctx.SketchLocation)
+	require.Equal(t, 1, len(ctx.Sketch.AdditionalFiles))
+	require.Equal(t, filepath.Join(sketchFolder, "config.h"), ctx.Sketch.AdditionalFiles[0].Name)
+}
+
+func TestLoadSketchFromInvalidArchive(t *testing.T) {
+	tempPath, err := ioutil.TempDir("", "archive")
+	NoError(t, err)
+	defer os.RemoveAll(tempPath)
+
+	archives := map[string]map[string]string{
+		"outside.zip": {"../sketch/sketch.ino": ""},
+		"empty.zip":   {"sketch/readme.txt": ""},
+		"several.zip": {"a.ino": "", "b.ino": ""},
+	}
+	messages := map[string]string{
+		"outside.zip": "invalid path ../sketch/sketch.ino",
+		"empty.zip":   "no sketch found in sketch/",
+		"several.zip": "several sketches found in / (a.ino, b.ino)",
+	}
+	for name, files := range archives {
+		archive := filepath.Join(tempPath, name)
+		writeZipSketchArchive(t, archive, files)
+
+		ctx := &types.Context{SketchLocation: archive, BuildPath: tempPath}
+		ctx.SetLogger(i18n.NoopLogger{})
+		err := (&builder.SketchLoader{}).Run(ctx)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), archive+": "+messages[name])
+	}
+}
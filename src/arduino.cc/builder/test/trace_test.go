@@ -0,0 +1,156 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"arduino.cc/builder/builder_utils"
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/trace"
+	"arduino.cc/builder/types"
+	"arduino.cc/properties"
+	"github.com/stretchr/testify/require"
+)
+
+type chromeTraceEvent struct {
+	Name string
+	Cat  string
+	Ph   string
+	Ts   int64
+	Dur  int64
+	Tid  int
+	Args map[string]interface{}
+}
+
+func readChromeTrace(t *testing.T, tr *trace.Trace) map[string]chromeTraceEvent {
+	buffer := &bytes.Buffer{}
+	NoError(t, tr.WriteChromeTrace(buffer))
+
+	var data struct {
+		TraceEvents []chromeTraceEvent
+	}
+	NoError(t, json.Unmarshal(buffer.Bytes(), &data))
+
+	events := make(map[string]chromeTraceEvent)
+	for _, event := range data.TraceEvents {
+		require.Equal(t, "X", event.Ph)
+		events[event.Name] = event
+	}
+	return events
+}
+
+func TestTraceNilIsNoop(t *testing.T) {
+	var tr *trace.Trace
+	span := tr.Begin(trace.CATEGORY_COMMAND, "nothing", nil)
+	span.End()
+	tr.Count(trace.COUNTER_INCLUDE_CACHE_HIT)
+	require.Equal(t, 0, tr.Counter(trace.COUNTER_INCLUDE_CACHE_HIT))
+	require.Nil(t, tr.Slowest(trace.CATEGORY_COMMAND, 10))
+}
+
+func TestTraceChromeFormat(t *testing.T) {
+	tr := trace.New()
+
+	outer := tr.Begin(trace.CATEGORY_PHASE, "outer", nil)
+	inner := tr.Begin(trace.CATEGORY_COMMAND, "inner", map[string]interface{}{"key": "value"})
+	time.Sleep(5 * time.Millisecond)
+	parallel := tr.Begin(trace.CATEGORY_COMPILE, "parallel", nil)
+	inner.End()
+	time.Sleep(5 * time.Millisecond)
+	parallel.End()
+	outer.End()
+	tr.Count(trace.COUNTER_INCLUDE_CACHE_MISS)
+	tr.Count(trace.COUNTER_INCLUDE_CACHE_MISS)
+
+	events := readChromeTrace(t, tr)
+	require.Equal(t, 3, len(events))
+	require.Equal(t, trace.CATEGORY_PHASE, events["outer"].Cat)
+	require.Equal(t, "value", events["inner"].Args["key"])
+	require.True(t, events["outer"].Dur >= 10000)
+
+	// inner is nested in outer, parallel overlaps inner without being
+	// nested in it, so it needs its own thread
+	require.Equal(t, events["outer"].Tid, events["inner"].Tid)
+	require.NotEqual(t, events["inner"].Tid, events["parallel"].Tid)
+
+	require.Equal(t, 2, tr.Counter(trace.COUNTER_INCLUDE_CACHE_MISS))
+}
+
+func TestTraceSlowest(t *testing.T) {
+	tr := trace.New()
+	for _, name := range []string{"fast", "slow", "medium"} {
+		span := tr.Begin(trace.CATEGORY_COMPILE, name, nil)
+		switch name {
+		case "slow":
+			time.Sleep(20 * time.Millisecond)
+		case "medium":
+			time.Sleep(10 * time.Millisecond)
+		}
+		span.End()
+	}
+	tr.Begin(trace.CATEGORY_PHASE, "other", nil).End()
+
+	spans := tr.Slowest(trace.CATEGORY_COMPILE, 2)
+	require.Equal(t, 2, len(spans))
+	require.Equal(t, "slow", spans[0].Name)
+	require.Equal(t, "medium", spans[1].Name)
+}
+
+func TestTraceCompileFiles(t *testing.T) {
+	sourcePath := prepareSourceFolder(t, "a.c", "b.cpp")
+	defer os.RemoveAll(sourcePath)
+	buildPath, err := ioutil.TempDir("", "build")
+	NoError(t, err)
+	defer os.RemoveAll(buildPath)
+
+	buildProperties := make(properties.Map)
+	buildProperties[constants.RECIPE_C_PATTERN] = "cp {source_file} {object_file}"
+	buildProperties[constants.RECIPE_CPP_PATTERN] = "cp {source_file} {object_file}"
+
+	ctx := &types.Context{Jobs: 2, Trace: trace.New()}
+	ctx.SetLogger(i18n.NoopLogger{})
+
+	_, err = builder_utils.CompileFiles(ctx, []string{}, sourcePath, false, buildPath, buildProperties, []string{})
+	NoError(t, err)
+
+	events := readChromeTrace(t, ctx.Trace)
+	require.Equal(t, trace.CATEGORY_COMPILE, events[filepath.Join(sourcePath, "a.c")].Cat)
+	require.Equal(t, trace.CATEGORY_COMPILE, events[filepath.Join(sourcePath, "b.cpp")].Cat)
+	require.Equal(t, trace.CATEGORY_RECIPE, events[constants.RECIPE_C_PATTERN].Cat)
+	require.Equal(t, trace.CATEGORY_RECIPE, events[constants.RECIPE_CPP_PATTERN].Cat)
+}
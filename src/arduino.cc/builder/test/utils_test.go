@@ -31,10 +31,18 @@ package test
 
 import (
 	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
 	"arduino.cc/builder/utils"
+	"context"
 	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestCommandLineParser(t *testing.T) {
@@ -138,3 +146,110 @@ func TestParseCppString(t *testing.T) {
 	require.Equal(t, `/home/ççç/ /$sdsdd\`, str)
 	require.Equal(t, ``, rest)
 }
+
+func TestRunCommandKillsChildrenWhenCanceled(t *testing.T) {
+	cancelContext, cancel := context.WithCancel(context.Background())
+	ctx := &types.Context{}
+	ctx.SetCancelContext(cancelContext)
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		cancel()
+	}()
+
+	// The grandchild keeps the stdout pipe open: if it weren't killed
+	// too, RunCommand would wait for it
+	command := exec.Command("sh", "-c", "sleep 30 & sleep 30")
+	command.Stdout = &strings.Builder{}
+	start := time.Now()
+	err := utils.RunCommand(ctx, command, 0)
+	require.True(t, utils.IsCommandKilled(err))
+	require.Equal(t, "Build canceled", err.Error())
+	require.True(t, time.Since(start) < 10*time.Second)
+}
+
+func TestRunCommandTimeout(t *testing.T) {
+	ctx := &types.Context{}
+
+	command := exec.Command("sleep", "30")
+	start := time.Now()
+	err := utils.RunCommand(ctx, command, 200*time.Millisecond)
+	require.True(t, utils.IsCommandKilled(err))
+	require.Contains(t, err.Error(), "sleep 30")
+	require.True(t, time.Since(start) < 10*time.Second)
+}
+
+func TestRunCommandAlreadyCanceled(t *testing.T) {
+	cancelContext, cancel := context.WithCancel(context.Background())
+	cancel()
+	ctx := &types.Context{}
+	ctx.SetCancelContext(cancelContext)
+
+	err := utils.RunCommand(ctx, exec.Command("true"), 0)
+	require.True(t, utils.IsCommandKilled(err))
+}
+
+func TestRunCommand(t *testing.T) {
+	ctx := &types.Context{}
+
+	NoError(t, utils.RunCommand(ctx, exec.Command("true"), time.Minute))
+	require.Error(t, utils.RunCommand(ctx, exec.Command("false"), 0))
+}
+
+func TestFoldersStamp(t *testing.T) {
+	folder, err := ioutil.TempDir("", "stamp")
+	NoError(t, err)
+	defer os.RemoveAll(folder)
+	NoError(t, os.MkdirAll(filepath.Join(folder, "a", "b"), os.FileMode(0755)))
+	NoError(t, utils.WriteFile(filepath.Join(folder, "a", "b", "file.txt"), "content"))
+
+	stamp := utils.FoldersStamp([]string{folder}, 1)
+	require.Equal(t, stamp, utils.FoldersStamp([]string{folder}, 1))
+
+	// Too deep to be seen, when changed in place
+	NoError(t, ioutil.WriteFile(filepath.Join(folder, "a", "b", "file.txt"), []byte("other content"), os.FileMode(0644)))
+	require.Equal(t, stamp, utils.FoldersStamp([]string{folder}, 1))
+
+	NoError(t, utils.WriteFile(filepath.Join(folder, "a", "file.txt"), "content"))
+	changed := utils.FoldersStamp([]string{folder}, 1)
+	require.NotEqual(t, stamp, changed)
+
+	NoError(t, utils.WriteFile(filepath.Join(folder, "a", "file.txt"), "other content"))
+	require.NotEqual(t, changed, utils.FoldersStamp([]string{folder}, 1))
+}
+
+func TestWriteFileKeepsModeAndSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no file modes nor symlinks on windows")
+	}
+	folder, err := ioutil.TempDir("", "write")
+	NoError(t, err)
+	defer os.RemoveAll(folder)
+
+	script := filepath.Join(folder, "script.sh")
+	NoError(t, ioutil.WriteFile(script, []byte("true"), os.FileMode(0755)))
+	NoError(t, utils.WriteFile(script, "false"))
+	info, err := os.Stat(script)
+	NoError(t, err)
+	require.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+	// The file linked to is written, the symlink stays
+	link := filepath.Join(folder, "link.sh")
+	NoError(t, os.Symlink(script, link))
+	NoError(t, utils.WriteFile(link, "exit 1"))
+	info, err = os.Lstat(link)
+	NoError(t, err)
+	require.Equal(t, os.ModeSymlink, info.Mode()&os.ModeSymlink)
+	bytes, err := ioutil.ReadFile(script)
+	NoError(t, err)
+	require.Equal(t, "exit 1", string(bytes))
+
+	// New files get the mode the umask leaves, like with ioutil.WriteFile
+	NoError(t, ioutil.WriteFile(filepath.Join(folder, "expected.txt"), []byte{}, os.FileMode(0666)))
+	expected, err := os.Stat(filepath.Join(folder, "expected.txt"))
+	NoError(t, err)
+	NoError(t, utils.WriteFile(filepath.Join(folder, "new.txt"), ""))
+	info, err = os.Stat(filepath.Join(folder, "new.txt"))
+	NoError(t, err)
+	require.Equal(t, expected.Mode().Perm(), info.Mode().Perm())
+}
@@ -0,0 +1,172 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"arduino.cc/builder"
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+	"arduino.cc/builder/utils"
+	"arduino.cc/properties"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBuildContext(t *testing.T) {
+	ctx := &types.Context{
+		HardwareFolders:   []string{"hardware"},
+		SketchLocation:    "sketch.ino",
+		FQBN:              "arduino:avr:uno",
+		Jobs:              3,
+		Verbose:           true,
+		SketchObjectFiles: []string{"sketch.ino.cpp.o"},
+	}
+	ctx.SetLogger(i18n.NoopLogger{})
+	ctx.AddPhaseTiming("core", time.Second)
+
+	newCtx := ctx.NewBuildContext()
+	require.Equal(t, ctx.HardwareFolders, newCtx.HardwareFolders)
+	require.Equal(t, ctx.SketchLocation, newCtx.SketchLocation)
+	require.Equal(t, ctx.FQBN, newCtx.FQBN)
+	require.Equal(t, 3, newCtx.Jobs)
+	require.True(t, newCtx.Verbose)
+	require.Equal(t, ctx.GetLogger(), newCtx.GetLogger())
+	require.Nil(t, newCtx.SketchObjectFiles)
+	require.Equal(t, 0, len(newCtx.PhaseTimings()))
+}
+
+func TestWatchedFolders(t *testing.T) {
+	ctx := &types.Context{
+		Sketch:            &types.Sketch{MainFile: types.SketchFile{Name: filepath.Join("/sketches", "Blink", "Blink.ino")}},
+		ImportedLibraries: []*types.Library{&types.Library{Folder: "/libraries/Servo"}, &types.Library{Folder: "/libraries/Wire"}},
+		BuildProperties: properties.Map{
+			constants.BUILD_PROPERTIES_BUILD_CORE_PATH:    "/hardware/arduino/avr/cores/arduino",
+			constants.BUILD_PROPERTIES_BUILD_VARIANT_PATH: "/hardware/arduino/avr/variants/standard",
+		},
+	}
+
+	folders := builder.WatchedFolders(ctx)
+	require.Equal(t, []string{
+		filepath.Join("/sketches", "Blink"),
+		"/libraries/Servo",
+		"/libraries/Wire",
+		"/hardware/arduino/avr/cores/arduino",
+		"/hardware/arduino/avr/variants/standard",
+	}, folders)
+}
+
+func TestWatchSketchArchive(t *testing.T) {
+	root := PrepareTestPlatform(t, properties.Map{
+		"board.name":       "Board",
+		"board.build.core": "core",
+	}, properties.Map{
+		"recipe.c.combine.pattern": "touch \"{build.path}/{build.project_name}.elf\"",
+	}, nil)
+	defer os.RemoveAll(root)
+
+	archive := filepath.Join(root, "Blink.zip")
+	writeZipSketchArchive(t, archive, map[string]string{
+		"Blink/Blink.ino": "void setup() {}\nvoid loop() {}\n",
+	})
+	buildPath := filepath.Join(root, "build")
+	NoError(t, os.MkdirAll(buildPath, os.FileMode(0755)))
+
+	ctx := &types.Context{
+		HardwareFolders:   []string{filepath.Join(root, "hardware")},
+		SketchLocation:    archive,
+		FQBN:              "test:arch:board",
+		ArduinoAPIVersion: "10600",
+		BuildPath:         buildPath,
+	}
+	ctx.SetLogger(i18n.NoopLogger{})
+	buildCtx := ctx.NewBuildContext()
+	NoError(t, builder.RunBuilder(buildCtx))
+
+	// The unpacked sketch is in the build path, that isn't watched
+	folders := builder.WatchedFolders(buildCtx)
+	require.Equal(t, archive, folders[0])
+	stamp := builder.WatchStamp(folders, buildPath)
+
+	writeZipSketchArchive(t, archive, map[string]string{
+		"Blink/Blink.ino": "void setup() {}\nvoid loop() { setup(); }\n",
+	})
+	require.NotEqual(t, stamp, builder.WatchStamp(folders, buildPath))
+
+	// The next build unpacks it again
+	buildCtx = ctx.NewBuildContext()
+	NoError(t, builder.RunBuilder(buildCtx))
+	require.Contains(t, buildCtx.Sketch.MainFile.Source, "setup();")
+}
+
+func TestWatchStamp(t *testing.T) {
+	sketchFolder, err := ioutil.TempDir("", "sketch")
+	NoError(t, err)
+	defer os.RemoveAll(sketchFolder)
+	buildPath := filepath.Join(sketchFolder, "build")
+	NoError(t, os.MkdirAll(filepath.Join(sketchFolder, "src"), os.FileMode(0755)))
+	NoError(t, os.MkdirAll(buildPath, os.FileMode(0755)))
+	NoError(t, utils.WriteFile(filepath.Join(sketchFolder, "src", "lib.cpp"), ""))
+	NoError(t, utils.WriteFile(filepath.Join(buildPath, "sketch.ino.hex"), ""))
+
+	folders := []string{sketchFolder}
+	stamp := builder.WatchStamp(folders, buildPath)
+
+	NoError(t, utils.WriteFile(filepath.Join(buildPath, "sketch.ino.elf"), ""))
+	NoError(t, utils.WriteFile(filepath.Join(sketchFolder, ".sketch.ino.swp"), ""))
+	require.Equal(t, stamp, builder.WatchStamp(folders, buildPath))
+
+	NoError(t, utils.WriteFile(filepath.Join(sketchFolder, "src", "lib.cpp"), "void lib() {}"))
+	require.NotEqual(t, stamp, builder.WatchStamp(folders, buildPath))
+}
+
+func TestWaitForChanges(t *testing.T) {
+	sketchFolder, err := ioutil.TempDir("", "sketch")
+	NoError(t, err)
+	defer os.RemoveAll(sketchFolder)
+	folders := []string{sketchFolder}
+
+	stamp := builder.WatchStamp(folders, "")
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		utils.WriteFile(filepath.Join(sketchFolder, "sketch.ino"), "void setup() {}")
+	}()
+	require.True(t, builder.WaitForChanges(context.Background(), folders, "", stamp))
+
+	cancelContext, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.False(t, builder.WaitForChanges(cancelContext, folders, "", builder.WatchStamp(folders, "")))
+}
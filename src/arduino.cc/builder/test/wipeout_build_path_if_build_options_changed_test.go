@@ -31,9 +31,11 @@ package test
 
 import (
 	"arduino.cc/builder"
+	"arduino.cc/builder/constants"
 	"arduino.cc/builder/gohasissues"
 	"arduino.cc/builder/types"
 	"arduino.cc/builder/utils"
+	"arduino.cc/properties"
 	"github.com/stretchr/testify/require"
 	"os"
 	"path/filepath"
@@ -131,3 +133,102 @@ func TestWipeoutBuildPathIfBuildOptionsChangedBuildOptionsMatch(t *testing.T) {
 	_, err = os.Stat(filepath.Join(buildPath, "should_not_be_deleted.txt"))
 	NoError(t, err)
 }
+
+func TestWipeoutBuildPathIfBuildOptionsChangedOnlyCustomBuildProperties(t *testing.T) {
+	ctx := &types.Context{}
+
+	buildPath := SetupBuildPath(t, ctx)
+	defer os.RemoveAll(buildPath)
+
+	ctx.BuildOptionsJsonPrevious = "{ \"fqbn\":\"arduino:avr:uno\", \"customBuildProperties\":\"\" }"
+	ctx.BuildOptionsJson = "{ \"fqbn\":\"arduino:avr:uno\", \"customBuildProperties\":\"compiler.cpp.extra_flags=-DDEBUG\" }"
+
+	utils.TouchFile(filepath.Join(buildPath, "should_not_be_deleted.txt"))
+	utils.TouchFile(filepath.Join(buildPath, constants.FILE_INCLUDES_CACHE))
+
+	command := &builder.WipeoutBuildPathIfBuildOptionsChanged{}
+	NoError(t, command.Run(ctx))
+
+	_, err := os.Stat(filepath.Join(buildPath, "should_not_be_deleted.txt"))
+	NoError(t, err)
+	_, err = os.Stat(filepath.Join(buildPath, constants.FILE_INCLUDES_CACHE))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestWipeoutBuildPathIfBuildOptionsChangedOnlyLibrariesFolders(t *testing.T) {
+	ctx := &types.Context{}
+
+	buildPath := SetupBuildPath(t, ctx)
+	defer os.RemoveAll(buildPath)
+
+	ctx.BuildOptionsJsonPrevious = "{ \"fqbn\":\"arduino:avr:uno\", \"otherLibrariesFolders\":\"libraries\" }"
+	ctx.BuildOptionsJson = "{ \"fqbn\":\"arduino:avr:uno\", \"otherLibrariesFolders\":\"libraries,other_libraries\" }"
+
+	for _, folder := range []string{constants.FOLDER_CORE, constants.FOLDER_LIBRARIES, constants.FOLDER_SKETCH} {
+		NoError(t, os.MkdirAll(filepath.Join(buildPath, folder), os.FileMode(0755)))
+		utils.TouchFile(filepath.Join(buildPath, folder, "file.o"))
+	}
+	utils.TouchFile(filepath.Join(buildPath, constants.FILE_INCLUDES_CACHE))
+
+	command := &builder.WipeoutBuildPathIfBuildOptionsChanged{}
+	NoError(t, command.Run(ctx))
+
+	files, err := gohasissues.ReadDir(buildPath)
+	NoError(t, err)
+	require.Equal(t, 2, len(files))
+	require.Equal(t, constants.FOLDER_CORE, files[0].Name())
+	require.Equal(t, constants.FOLDER_SKETCH, files[1].Name())
+}
+
+func TestWipeoutBuildPathIfBuildOptionsChangedOnlyBoardOptions(t *testing.T) {
+	board := &types.Board{BoardId: "board", Properties: properties.Map{
+		"build.f_cpu":                  "16",
+		"menu.speed.fast.build.f_cpu":  "16",
+		"menu.speed.slow.build.f_cpu":  "8",
+		"menu.upload.a.upload.speed":   "1",
+		"menu.upload.b.upload.speed":   "2",
+		"menu.upload.b.build.ldscript": "b.ld",
+		"name":                         "Board",
+		"upload.speed":                 "1",
+	}}
+	platform := &types.Platform{PlatformId: "arch", Boards: map[string]*types.Board{"board": board}, Properties: properties.Map{
+		constants.RECIPE_C_PATTERN:        "gcc -DF_CPU={build.f_cpu} {source_file} -o {object_file}",
+		constants.RECIPE_CPP_PATTERN:      "g++ -DF_CPU={build.f_cpu} {source_file} -o {object_file}",
+		constants.RECIPE_AR_PATTERN:       "ar rcs {archive_file_path} {object_file}",
+		constants.RECIPE_PREPROC_INCLUDES: "g++ -M {source_file}",
+		"build.ldscript":                  "a.ld",
+	}}
+
+	build := func(fqbn string, prevFQBN string, targetBoardProperties properties.Map) []string {
+		ctx := &types.Context{}
+		buildPath := SetupBuildPath(t, ctx)
+		defer os.RemoveAll(buildPath)
+
+		for _, folder := range []string{constants.FOLDER_CORE, constants.FOLDER_LIBRARIES, constants.FOLDER_SKETCH} {
+			NoError(t, os.MkdirAll(filepath.Join(buildPath, folder), os.FileMode(0755)))
+		}
+		utils.TouchFile(filepath.Join(buildPath, constants.FILE_INCLUDES_CACHE))
+
+		ctx.BuildOptionsJsonPrevious = "{ \"fqbn\":\"" + prevFQBN + "\" }"
+		ctx.BuildOptionsJson = "{ \"fqbn\":\"" + fqbn + "\" }"
+		ctx.TargetPlatform = platform
+		ctx.ActualPlatform = platform
+		ctx.TargetBoard = &types.Board{BoardId: "board", Properties: board.Properties.Clone().Merge(targetBoardProperties)}
+		ctx.BuildProperties = platform.Properties.Clone().Merge(ctx.TargetBoard.Properties)
+
+		command := &builder.WipeoutBuildPathIfBuildOptionsChanged{}
+		NoError(t, command.Run(ctx))
+
+		files, err := gohasissues.ReadDir(buildPath)
+		NoError(t, err)
+		var names []string
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+		return names
+	}
+
+	require.Equal(t, []string{constants.FILE_INCLUDES_CACHE}, build("pkg:arch:board:speed=slow,upload=a", "pkg:arch:board:speed=fast,upload=a", properties.Map{"build.f_cpu": "8"}))
+	require.Equal(t, []string{constants.FOLDER_CORE, constants.FILE_INCLUDES_CACHE, constants.FOLDER_LIBRARIES, constants.FOLDER_SKETCH}, build("pkg:arch:board:speed=fast,upload=b", "pkg:arch:board:speed=fast,upload=a", properties.Map{"upload.speed": "2", "build.ldscript": "b.ld"}))
+	require.Equal(t, 0, len(build("pkg:arch:other:speed=fast", "pkg:arch:board:speed=fast", properties.Map{})))
+}
@@ -0,0 +1,238 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+/*
+
+Build tracing
+
+A Trace records how long the steps of a build took: every command,
+recipe and compiler invocation is a Span, with a category (see the
+CATEGORY_* constants), a name and when it started and ended. Spans can
+overlap, since files are compiled in parallel and independent phases
+run at the same time.
+
+A Trace can be written in the Chrome trace-event format (load it in
+chrome://tracing or https://ui.perfetto.dev) and summarized, listing the
+slowest spans of a category.
+
+All the methods can be called on a nil *Trace, and do nothing: this way
+tracing code doesn't need to check if tracing is enabled.
+
+*/
+
+package trace
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const CATEGORY_COMMAND = "command"
+const CATEGORY_COMPILE = "compile"
+const CATEGORY_HOOK = "hook"
+const CATEGORY_PHASE = "phase"
+const CATEGORY_RECIPE = "recipe"
+
+const COUNTER_INCLUDE_CACHE_HIT = "include cache hits"
+const COUNTER_INCLUDE_CACHE_MISS = "include cache misses"
+const COUNTER_OBJECT_CACHE_HIT = "object cache hits"
+const COUNTER_OBJECT_CACHE_MISS = "object cache misses"
+
+type Trace struct {
+	lock     sync.Mutex
+	start    time.Time
+	spans    []*Span
+	counters map[string]int
+}
+
+type Span struct {
+	trace     *Trace
+	Category  string
+	Name      string
+	Args      map[string]interface{}
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+func New() *Trace {
+	return &Trace{start: time.Now(), counters: make(map[string]int)}
+}
+
+// Start a span, that lasts until End is called on it
+func (t *Trace) Begin(category string, name string, args map[string]interface{}) *Span {
+	if t == nil {
+		return nil
+	}
+	return &Span{trace: t, Category: category, Name: name, Args: args, StartTime: time.Now()}
+}
+
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.EndTime = time.Now()
+	s.trace.lock.Lock()
+	defer s.trace.lock.Unlock()
+	s.trace.spans = append(s.trace.spans, s)
+}
+
+func (s *Span) Duration() time.Duration {
+	return s.EndTime.Sub(s.StartTime)
+}
+
+func (t *Trace) Count(counter string) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.counters[counter]++
+}
+
+func (t *Trace) Counter(counter string) int {
+	if t == nil {
+		return 0
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.counters[counter]
+}
+
+// Return the completed spans of the given category, slowest first
+func (t *Trace) Slowest(category string, count int) []*Span {
+	if t == nil {
+		return nil
+	}
+	t.lock.Lock()
+	var spans []*Span
+	for _, span := range t.spans {
+		if span.Category == category {
+			spans = append(spans, span)
+		}
+	}
+	t.lock.Unlock()
+
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].Duration() > spans[j].Duration() })
+	if len(spans) > count {
+		spans = spans[:count]
+	}
+	return spans
+}
+
+type chromeTraceEvent struct {
+	Name      string                 `json:"name"`
+	Category  string                 `json:"cat"`
+	Phase     string                 `json:"ph"`
+	Timestamp int64                  `json:"ts"`
+	Duration  int64                  `json:"dur"`
+	Pid       int                    `json:"pid"`
+	Tid       int                    `json:"tid"`
+	Args      map[string]interface{} `json:"args,omitempty"`
+}
+
+type chromeTrace struct {
+	TraceEvents     []chromeTraceEvent `json:"traceEvents"`
+	DisplayTimeUnit string             `json:"displayTimeUnit"`
+	OtherData       map[string]int     `json:"otherData,omitempty"`
+}
+
+// Write the trace in the Chrome trace-event format. Spans are spread
+// over as many threads as needed for each thread to only contain spans
+// that are either disjoint or nested into each other.
+func (t *Trace) WriteChromeTrace(w io.Writer) error {
+	t.lock.Lock()
+	spans := append([]*Span(nil), t.spans...)
+	counters := make(map[string]int)
+	for counter, value := range t.counters {
+		counters[counter] = value
+	}
+	t.lock.Unlock()
+
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i].StartTime.Equal(spans[j].StartTime) {
+			return spans[i].EndTime.After(spans[j].EndTime)
+		}
+		return spans[i].StartTime.Before(spans[j].StartTime)
+	})
+
+	// Spans still open on each thread, innermost last
+	var threads [][]*Span
+	events := []chromeTraceEvent{}
+	for _, span := range spans {
+		tid := -1
+		for idx, open := range threads {
+			for len(open) > 0 && !open[len(open)-1].EndTime.After(span.StartTime) {
+				open = open[:len(open)-1]
+			}
+			threads[idx] = open
+			if tid == -1 && (len(open) == 0 || !open[len(open)-1].EndTime.Before(span.EndTime)) {
+				tid = idx
+			}
+		}
+		if tid == -1 {
+			threads = append(threads, nil)
+			tid = len(threads) - 1
+		}
+		threads[tid] = append(threads[tid], span)
+
+		events = append(events, chromeTraceEvent{
+			Name:      span.Name,
+			Category:  span.Category,
+			Phase:     "X",
+			Timestamp: span.StartTime.Sub(t.start).Nanoseconds() / 1000,
+			Duration:  span.Duration().Nanoseconds() / 1000,
+			Pid:       1,
+			Tid:       tid + 1,
+			Args:      span.Args,
+		})
+	}
+
+	bytes, err := json.MarshalIndent(chromeTrace{TraceEvents: events, DisplayTimeUnit: "ms", OtherData: counters}, "", " ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(bytes)
+	return err
+}
+
+func (t *Trace) WriteChromeTraceFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = t.WriteChromeTrace(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
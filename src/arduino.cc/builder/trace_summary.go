@@ -0,0 +1,76 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package builder
+
+import (
+	"os"
+	"strconv"
+
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/trace"
+	"arduino.cc/builder/types"
+)
+
+const TRACE_SUMMARY_SIZE = 10
+
+// Prints the slowest phases, files and hooks recorded in ctx.Trace and
+// how well the include and object caches worked
+func PrintTraceSummary(ctx *types.Context) {
+	if ctx.Trace == nil {
+		return
+	}
+	logger := ctx.GetLogger()
+
+	printSlowestSpans(logger, ctx.Trace, trace.CATEGORY_PHASE, constants.MSG_TRACE_SLOWEST_PHASES)
+	printSlowestSpans(logger, ctx.Trace, trace.CATEGORY_COMPILE, constants.MSG_TRACE_SLOWEST_FILES)
+	printSlowestSpans(logger, ctx.Trace, trace.CATEGORY_HOOK, constants.MSG_TRACE_SLOWEST_HOOKS)
+
+	hits := ctx.Trace.Counter(trace.COUNTER_INCLUDE_CACHE_HIT)
+	misses := ctx.Trace.Counter(trace.COUNTER_INCLUDE_CACHE_MISS)
+	logger.Fprintln(os.Stdout, constants.LOG_LEVEL_INFO, constants.MSG_TRACE_INCLUDE_CACHE, strconv.Itoa(hits), strconv.Itoa(misses))
+
+	if ctx.ObjectCache != nil {
+		hits := ctx.Trace.Counter(trace.COUNTER_OBJECT_CACHE_HIT)
+		misses := ctx.Trace.Counter(trace.COUNTER_OBJECT_CACHE_MISS)
+		logger.Fprintln(os.Stdout, constants.LOG_LEVEL_INFO, constants.MSG_TRACE_OBJECT_CACHE, strconv.Itoa(hits), strconv.Itoa(misses))
+	}
+}
+
+func printSlowestSpans(logger i18n.Logger, t *trace.Trace, category string, title string) {
+	spans := t.Slowest(category, TRACE_SUMMARY_SIZE)
+	if len(spans) == 0 {
+		return
+	}
+	logger.Fprintln(os.Stdout, constants.LOG_LEVEL_INFO, title)
+	for _, span := range spans {
+		logger.Fprintln(os.Stdout, constants.LOG_LEVEL_INFO, constants.MSG_TRACE_SPAN, span.Name, span.Duration().String())
+	}
+}
@@ -1,9 +1,16 @@
 package types
 
 import (
+	"context"
+	"io"
+	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/objectcache"
+	"arduino.cc/builder/trace"
 	"arduino.cc/properties"
 )
 
@@ -52,12 +59,22 @@ type Context struct {
 	Source          string
 	SourceGccMinusE string
 
+	// When the sketch comes from an archive, the archive, the folder it's
+	// unpacked to and what's shown instead of it in compiler messages,
+	// the name of the archive and of the sketch folder in it, like
+	// upload.zip:Blink/
+	SketchArchive       string
+	SketchArchiveFolder string
+	SketchArchivePrefix string
+
 	WarningsLevel string
 
 	// Libraries handling
-	Libraries                  []*Library
-	HeaderToLibraries          map[string][]*Library
-	ImportedLibraries          []*Library
+	Libraries         []*Library
+	HeaderToLibraries map[string][]*Library
+	ImportedLibraries []*Library
+	// The include that made each imported library be used
+	ImportedLibrariesIncludes  map[*Library]LibraryInclude
 	LibrariesResolutionResults map[string]LibraryResolutionResult
 	IncludeJustFound           string
 	IncludeFolders             []string
@@ -77,6 +94,9 @@ type Context struct {
 	Verbose           bool
 	DebugPreprocessor bool
 
+	// Max number of files compiled at the same time, 0 means one per CPU
+	Jobs int
+
 	// Contents of a custom build properties file (line by line)
 	CustomBuildProperties []string
 
@@ -84,6 +104,75 @@ type Context struct {
 	logger     i18n.Logger
 	DebugLevel int
 
+	// Canceling this context stops the build and the commands it started
+	cancelContext context.Context
+
+	// Records how long commands, recipes and compilations take, when not nil
+	Trace *trace.Trace
+
+	// Hardware, tools and libraries already loaded by other builds
+	LoaderCache *LoaderCache
+
+	// Runs the commands of the build, as local processes if nil
+	Executor Executor
+
+	// Where compiled objects and archives are looked for before
+	// compiling, and stored after, when not nil
+	ObjectCache objectcache.Cache
+
+	// Whether object files are up to date is decided by the content of
+	// their sources and dependencies, instead of by modification times
+	HashCheck bool
+
+	// Time properties and the paths embedded in what's compiled don't
+	// change from one build to another
+	Reproducible bool
+
+	// How long to wait for other builds using the build path to end:
+	// 0 fails at once, a negative value waits forever
+	BuildPathLockTimeout time.Duration
+	// Held while the build path is used, nil when not locked
+	BuildPathLock io.Closer
+
+	// Commands are recorded, instead of being run, when DryRun is set.
+	// Build steps then run one at a time, and DryRunPhase is the name of
+	// the running one
+	DryRun      bool
+	DryRunPhase string
+
+	// Where to write compile_commands.json, in the build path if empty
+	CompilationDatabasePath string
+
+	// Format of the software bill of materials written in the build
+	// path, none if empty
+	SBOMFormat string
+
+	// File with the licenses the imported libraries may have, see
+	// builder.LicensePolicy, none if empty
+	LicensePolicyFile string
+
+	// Where the artifacts of the build are copied, named after the
+	// OutputName template, none if empty
+	OutputDir  string
+	OutputName string
+
+	// Build results not stored elsewhere. Phases run concurrently, so
+	// the ones below are only accessed through methods
+	SketchSize       *SketchSize
+	resultsLock      sync.Mutex
+	compilerWarnings []CompilerWarning
+	phaseTimings     []PhaseTiming
+	dryRunCommands   []DryRunCommand
+	compileCommands  []CompileCommand
+	// Files named after the project written by the link and objcopy
+	// recipes, and by the builder from them, during this build
+	projectOutputs []string
+
+	// Shared by the compilations of all the phases, so that no more than
+	// ParallelJobs are run at a time
+	compileSlots     chan struct{}
+	compileSlotsOnce sync.Once
+
 	// ReadFileAndStoreInContext command
 	FileToRead string
 }
@@ -112,6 +201,70 @@ func (ctx *Context) InjectBuildOptions(opts properties.Map) {
 	ctx.CustomBuildProperties = strings.Split(opts["customBuildProperties"], ",")
 }
 
+// Returns a new context with the same options as ctx, to build the
+// same sketch again. Folders are copied, as builds change them in place
+func (ctx *Context) NewBuildContext() *Context {
+	newCtx := &Context{
+		HardwareFolders:         append([]string(nil), ctx.HardwareFolders...),
+		ToolsFolders:            append([]string(nil), ctx.ToolsFolders...),
+		BuiltInLibrariesFolders: append([]string(nil), ctx.BuiltInLibrariesFolders...),
+		OtherLibrariesFolders:   append([]string(nil), ctx.OtherLibrariesFolders...),
+		SketchLocation:          ctx.SketchLocation,
+		SketchArchive:           ctx.SketchArchive,
+		SketchArchiveFolder:     ctx.SketchArchiveFolder,
+		SketchArchivePrefix:     ctx.SketchArchivePrefix,
+		ArduinoAPIVersion:       ctx.ArduinoAPIVersion,
+		FQBN:                    ctx.FQBN,
+		BuildPath:               ctx.BuildPath,
+		USBVidPid:               ctx.USBVidPid,
+		WarningsLevel:           ctx.WarningsLevel,
+		Verbose:                 ctx.Verbose,
+		DebugPreprocessor:       ctx.DebugPreprocessor,
+		Jobs:                    ctx.Jobs,
+		DryRun:                  ctx.DryRun,
+		CompilationDatabasePath: ctx.CompilationDatabasePath,
+		CustomBuildProperties:   append([]string(nil), ctx.CustomBuildProperties...),
+		DebugLevel:              ctx.DebugLevel,
+		Trace:                   ctx.Trace,
+		LoaderCache:             ctx.LoaderCache,
+		Executor:                ctx.Executor,
+		ObjectCache:             ctx.ObjectCache,
+		HashCheck:               ctx.HashCheck,
+		BuildPathLockTimeout:    ctx.BuildPathLockTimeout,
+		Reproducible:            ctx.Reproducible,
+		SBOMFormat:              ctx.SBOMFormat,
+		LicensePolicyFile:       ctx.LicensePolicyFile,
+		OutputDir:               ctx.OutputDir,
+		OutputName:              ctx.OutputName,
+	}
+	newCtx.logger = ctx.logger
+	newCtx.cancelContext = ctx.cancelContext
+	return newCtx
+}
+
+func (ctx *Context) ParallelJobs() int {
+	if ctx.DryRun {
+		return 1
+	}
+	if ctx.Jobs <= 0 {
+		return runtime.NumCPU()
+	}
+	return ctx.Jobs
+}
+
+// Wait until one of the ParallelJobs compiler invocations allowed at a
+// time can be started. Release it with ReleaseCompileSlot
+func (ctx *Context) AcquireCompileSlot() {
+	ctx.compileSlotsOnce.Do(func() {
+		ctx.compileSlots = make(chan struct{}, ctx.ParallelJobs())
+	})
+	ctx.compileSlots <- struct{}{}
+}
+
+func (ctx *Context) ReleaseCompileSlot() {
+	<-ctx.compileSlots
+}
+
 func (ctx *Context) GetLogger() i18n.Logger {
 	if ctx.logger == nil {
 		return &i18n.HumanLogger{}
@@ -122,3 +275,79 @@ func (ctx *Context) GetLogger() i18n.Logger {
 func (ctx *Context) SetLogger(l i18n.Logger) {
 	ctx.logger = l
 }
+
+func (ctx *Context) CancelContext() context.Context {
+	if ctx.cancelContext == nil {
+		return context.Background()
+	}
+	return ctx.cancelContext
+}
+
+func (ctx *Context) SetCancelContext(c context.Context) {
+	ctx.cancelContext = c
+}
+
+func (ctx *Context) AddCompilerWarning(warning CompilerWarning) {
+	ctx.resultsLock.Lock()
+	defer ctx.resultsLock.Unlock()
+	ctx.compilerWarnings = append(ctx.compilerWarnings, warning)
+}
+
+func (ctx *Context) CompilerWarnings() []CompilerWarning {
+	ctx.resultsLock.Lock()
+	defer ctx.resultsLock.Unlock()
+	return append([]CompilerWarning(nil), ctx.compilerWarnings...)
+}
+
+func (ctx *Context) AddPhaseTiming(phase string, duration time.Duration) {
+	ctx.resultsLock.Lock()
+	defer ctx.resultsLock.Unlock()
+	ctx.phaseTimings = append(ctx.phaseTimings, PhaseTiming{Phase: phase, Duration: duration})
+}
+
+func (ctx *Context) PhaseTimings() []PhaseTiming {
+	ctx.resultsLock.Lock()
+	defer ctx.resultsLock.Unlock()
+	return append([]PhaseTiming(nil), ctx.phaseTimings...)
+}
+
+func (ctx *Context) AddDryRunCommand(commandLine string) {
+	ctx.resultsLock.Lock()
+	defer ctx.resultsLock.Unlock()
+	ctx.dryRunCommands = append(ctx.dryRunCommands, DryRunCommand{Phase: ctx.DryRunPhase, CommandLine: commandLine})
+}
+
+func (ctx *Context) DryRunCommands() []DryRunCommand {
+	ctx.resultsLock.Lock()
+	defer ctx.resultsLock.Unlock()
+	return append([]DryRunCommand(nil), ctx.dryRunCommands...)
+}
+
+func (ctx *Context) AddCompileCommand(command CompileCommand) {
+	ctx.resultsLock.Lock()
+	defer ctx.resultsLock.Unlock()
+	ctx.compileCommands = append(ctx.compileCommands, command)
+}
+
+func (ctx *Context) CompileCommands() []CompileCommand {
+	ctx.resultsLock.Lock()
+	defer ctx.resultsLock.Unlock()
+	return append([]CompileCommand(nil), ctx.compileCommands...)
+}
+
+func (ctx *Context) AddProjectOutput(file string) {
+	ctx.resultsLock.Lock()
+	defer ctx.resultsLock.Unlock()
+	for _, output := range ctx.projectOutputs {
+		if output == file {
+			return
+		}
+	}
+	ctx.projectOutputs = append(ctx.projectOutputs, file)
+}
+
+func (ctx *Context) ProjectOutputs() []string {
+	ctx.resultsLock.Lock()
+	defer ctx.resultsLock.Unlock()
+	return append([]string(nil), ctx.projectOutputs...)
+}
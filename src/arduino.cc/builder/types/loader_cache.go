@@ -0,0 +1,122 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package types
+
+import "sync"
+
+// Hardware, tools and libraries loaded by previous builds, to be reused
+// by the next ones when the folders they were loaded from haven't
+// changed. Builds must not modify what they get from here, as other
+// builds may be using it at the same time.
+// All the methods can be called on a nil *LoaderCache: nothing is ever
+// found in it.
+type LoaderCache struct {
+	lock      sync.Mutex
+	hardware  map[string]*loaderCacheEntry
+	libraries map[string]*loaderCacheEntry
+}
+
+type loaderCacheEntry struct {
+	stamp string
+	value interface{}
+}
+
+// Hardware and tools, with the platform keys rewrites applied to them
+type LoadedHardware struct {
+	Hardware               *Packages
+	Tools                  []*Tool
+	PlatformKeyRewrites    PlatforKeysRewrite
+	HardwareRewriteResults map[*Platform][]PlatforKeyRewrite
+	// The boards.txt, platform.txt and the like of the platforms, which
+	// can be deeper than the folders stamp sees, with their own stamp
+	PlatformFiles      []string
+	PlatformFilesStamp string
+}
+
+func NewLoaderCache() *LoaderCache {
+	return &LoaderCache{
+		hardware:  make(map[string]*loaderCacheEntry),
+		libraries: make(map[string]*loaderCacheEntry),
+	}
+}
+
+// Returns the hardware loaded from the folders identified by key, nil
+// if it was never loaded or if stamp says the folders changed since
+func (c *LoaderCache) Hardware(key string, stamp string) *LoadedHardware {
+	if c == nil {
+		return nil
+	}
+	if value := c.get(c.hardware, key, stamp); value != nil {
+		return value.(*LoadedHardware)
+	}
+	return nil
+}
+
+func (c *LoaderCache) SetHardware(key string, stamp string, hardware *LoadedHardware) {
+	if c == nil {
+		return
+	}
+	c.set(c.hardware, key, stamp, hardware)
+}
+
+// Returns the libraries found in folder, if they were loaded when the
+// folder had the given stamp
+func (c *LoaderCache) Libraries(folder string, stamp string) ([]*Library, bool) {
+	if c == nil {
+		return nil, false
+	}
+	if value := c.get(c.libraries, folder, stamp); value != nil {
+		return value.([]*Library), true
+	}
+	return nil, false
+}
+
+func (c *LoaderCache) SetLibraries(folder string, stamp string, libraries []*Library) {
+	if c == nil {
+		return
+	}
+	c.set(c.libraries, folder, stamp, libraries)
+}
+
+func (c *LoaderCache) get(entries map[string]*loaderCacheEntry, key string, stamp string) interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry := entries[key]
+	if entry == nil || entry.stamp != stamp {
+		return nil
+	}
+	return entry.value
+}
+
+func (c *LoaderCache) set(entries map[string]*loaderCacheEntry, key string, stamp string, value interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entries[key] = &loaderCacheEntry{stamp: stamp, value: value}
+}
@@ -31,8 +31,10 @@ package types
 
 import (
 	"fmt"
+	"os/exec"
 	"path/filepath"
 	"strconv"
+	"time"
 
 	"arduino.cc/builder/constants"
 	"arduino.cc/properties"
@@ -236,6 +238,57 @@ type SourceFolder struct {
 	Recurse bool
 }
 
+type SketchSize struct {
+	Text    int `json:"text"`
+	MaxText int `json:"max_text"`
+	Data    int `json:"data"`
+	MaxData int `json:"max_data"`
+	Eeprom  int `json:"eeprom"`
+	// Only set when the size is read from the .elf file by the builder
+	Sections []SectionSize `json:"sections,omitempty"`
+}
+
+// A section of the .elf file, and the sizes it counts in
+type SectionSize struct {
+	Name    string `json:"name"`
+	Address uint64 `json:"address"`
+	Size    int    `json:"size"`
+	Text    bool   `json:"text"`
+	Data    bool   `json:"data"`
+	Eeprom  bool   `json:"eeprom"`
+}
+
+// Output printed by the compiler for a file that was compiled
+// successfully
+type CompilerWarning struct {
+	Source string `json:"source"`
+	Output string `json:"output"`
+}
+
+type PhaseTiming struct {
+	Phase    string
+	Duration time.Duration
+}
+
+// An entry of the compilation database (compile_commands.json)
+type CompileCommand struct {
+	Directory string   `json:"directory"`
+	File      string   `json:"file"`
+	Arguments []string `json:"arguments"`
+	Output    string   `json:"output,omitempty"`
+}
+
+type DryRunCommand struct {
+	Phase       string `json:"phase"`
+	CommandLine string `json:"command_line"`
+}
+
+// An #include line, and the file it was found in
+type LibraryInclude struct {
+	Include    string
+	SourceFile string
+}
+
 type LibraryResolutionResult struct {
 	Library          *Library
 	NotUsedLibraries []*Library
@@ -271,3 +324,11 @@ func LibraryToSourceFolder(library *Library) []SourceFolder {
 type Command interface {
 	Run(ctx *Context) error
 }
+
+// Runs the external commands of a build: compilers, archivers, linkers,
+// hooks, ctags. command is ready to be started, with its output already
+// redirected. It must be stopped when the build is canceled or, if
+// timeout isn't zero, when it takes longer than that
+type Executor interface {
+	Execute(ctx *Context, command *exec.Cmd, timeout time.Duration) error
+}
@@ -0,0 +1,96 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package utils
+
+import (
+	"os/exec"
+	"sync"
+	"time"
+
+	"arduino.cc/builder/types"
+)
+
+// Runs commands prefixed with Wrapper, like ccache or a sandbox, with
+// Executor (as local processes if nil)
+type WrapperExecutor struct {
+	Wrapper  []string
+	Executor types.Executor
+}
+
+func (e *WrapperExecutor) Execute(ctx *types.Context, command *exec.Cmd, timeout time.Duration) error {
+	if len(e.Wrapper) == 0 {
+		return execute(ctx, e.Executor, command, timeout)
+	}
+
+	args := append(append([]string(nil), e.Wrapper[1:]...), command.Args...)
+	wrapped := exec.Command(e.Wrapper[0], args...)
+	wrapped.Dir = command.Dir
+	wrapped.Env = command.Env
+	wrapped.Stdin = command.Stdin
+	wrapped.Stdout = command.Stdout
+	wrapped.Stderr = command.Stderr
+	err := execute(ctx, e.Executor, wrapped, timeout)
+	command.ProcessState = wrapped.ProcessState
+	return err
+}
+
+// Records the command lines of the commands, then runs them with
+// Executor. If Executor is nil, commands aren't run, as if they
+// succeeded without printing anything. Commands compiling files run
+// concurrently, so they may be recorded in any order
+type RecordingExecutor struct {
+	Executor types.Executor
+	lock     sync.Mutex
+	commands []string
+}
+
+func (e *RecordingExecutor) Execute(ctx *types.Context, command *exec.Cmd, timeout time.Duration) error {
+	e.lock.Lock()
+	e.commands = append(e.commands, CommandLine(command.Args))
+	e.lock.Unlock()
+
+	if e.Executor == nil {
+		return nil
+	}
+	return e.Executor.Execute(ctx, command, timeout)
+}
+
+func (e *RecordingExecutor) Commands() []string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]string(nil), e.commands...)
+}
+
+func execute(ctx *types.Context, executor types.Executor, command *exec.Cmd, timeout time.Duration) error {
+	if executor == nil {
+		executor = LocalExecutor{}
+	}
+	return executor.Execute(ctx, command, timeout)
+}
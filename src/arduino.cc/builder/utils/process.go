@@ -0,0 +1,127 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package utils
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"time"
+
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/i18n"
+	"arduino.cc/builder/types"
+)
+
+// Returned by RunCommand when the command was killed before it could
+// complete, either because the build was canceled or because it was
+// taking too long
+type CommandKilledError struct {
+	Message string
+}
+
+func (e *CommandKilledError) Error() string {
+	return e.Message
+}
+
+func IsCommandKilled(err error) bool {
+	_, ok := err.(*CommandKilledError)
+	return ok
+}
+
+// Run the given command and wait for it to complete, with the executor
+// of the build. If the build is canceled, or the command doesn't
+// complete within timeout (when not zero), the command is killed. In
+// dry runs the command is only recorded, as if it produced no output
+func RunCommand(ctx *types.Context, command *exec.Cmd, timeout time.Duration) error {
+	if ctx.DryRun {
+		ctx.AddDryRunCommand(CommandLine(command.Args))
+		return nil
+	}
+
+	return execute(ctx, ctx.Executor, command, timeout)
+}
+
+// Runs commands as local processes. Killing one also kills all of the
+// processes it started
+type LocalExecutor struct{}
+
+func (e LocalExecutor) Execute(ctx *types.Context, command *exec.Cmd, timeout time.Duration) error {
+	cancelContext := ctx.CancelContext()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		cancelContext, cancel = context.WithTimeout(cancelContext, timeout)
+		defer cancel()
+	}
+
+	if cancelContext.Err() != nil {
+		return commandKilledError(ctx, command, timeout)
+	}
+
+	prepareProcessGroup(command)
+	err := command.Start()
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+
+	waitResult := make(chan error, 1)
+	go func() {
+		waitResult <- command.Wait()
+	}()
+
+	select {
+	case err := <-waitResult:
+		return err
+	case <-cancelContext.Done():
+		killProcessGroup(command)
+		<-waitResult
+		return commandKilledError(ctx, command, timeout)
+	}
+}
+
+// Joins args in a command line that can be pasted in a shell, quoting
+// the ones containing spaces or characters the shell would interpret
+func CommandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$`;&|<>()*?[]{}~#!") {
+			arg = "'" + strings.Replace(arg, "'", "'\\''", -1) + "'"
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, constants.SPACE)
+}
+
+func commandKilledError(ctx *types.Context, command *exec.Cmd, timeout time.Duration) error {
+	if ctx.CancelContext().Err() != nil {
+		return &CommandKilledError{Message: i18n.Format(constants.MSG_BUILD_CANCELED)}
+	}
+	return &CommandKilledError{Message: i18n.Format(constants.MSG_COMMAND_TIMEOUT, timeout.String(), strings.Join(command.Args, constants.SPACE))}
+}
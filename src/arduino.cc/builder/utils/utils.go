@@ -32,6 +32,8 @@ package utils
 import (
 	"crypto/md5"
 	"encoding/hex"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -366,14 +368,52 @@ func EnsureFolderExists(folder string) error {
 	return os.MkdirAll(folder, os.FileMode(0755))
 }
 
+// The file is written under a temporary name and renamed once complete,
+// so that an interrupted build doesn't leave it partially written
+// Write the file atomically, through a temporary file renamed into
+// place. A symlink is followed, and the file keeps its mode if it already
+// exists
 func WriteFileBytes(targetFilePath string, data []byte) error {
-	return ioutil.WriteFile(targetFilePath, data, os.FileMode(0644))
+	if realPath, err := filepath.EvalSymlinks(targetFilePath); err == nil {
+		targetFilePath = realPath
+	}
+	mode := os.FileMode(0666) &^ umask
+	if info, err := os.Stat(targetFilePath); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(targetFilePath), "."+filepath.Base(targetFilePath)+".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), targetFilePath)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
 }
 
 func WriteFile(targetFilePath string, data string) error {
 	return WriteFileBytes(targetFilePath, []byte(data))
 }
 
+// Where to write path before renaming it into place. The extension is
+// kept, as tools choose what to write by it (e.g. gcc names dependency
+// files after the object file, with .d instead of .o)
+func TemporaryPath(path string) string {
+	extension := filepath.Ext(path)
+	return strings.TrimSuffix(path, extension) + ".tmp" + extension
+}
+
 func TouchFile(targetFilePath string) error {
 	return WriteFileBytes(targetFilePath, []byte{})
 }
@@ -390,6 +430,47 @@ func MD5Sum(data []byte) string {
 	return hex.EncodeToString(md5sumBytes[:])
 }
 
+// Returns a value that changes whenever a file is added to, removed
+// from or modified in the given folders or in their subfolders, up to
+// depth levels down
+func FoldersStamp(folders []string, depth int) string {
+	hash := md5.New()
+	for _, folder := range folders {
+		stampFolder(hash, folder, depth)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Returns a value that changes whenever one of the given files is
+// created, removed or modified
+func FilesStamp(files []string) string {
+	hash := md5.New()
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			fmt.Fprintln(hash, file, os.IsNotExist(err))
+			continue
+		}
+		fmt.Fprintln(hash, file, info.Size(), info.ModTime().UnixNano())
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func stampFolder(w io.Writer, folder string, depth int) {
+	files, err := ioutil.ReadDir(folder)
+	if err != nil {
+		fmt.Fprintln(w, folder, err)
+		return
+	}
+	for _, file := range files {
+		path := filepath.Join(folder, file.Name())
+		fmt.Fprintln(w, path, file.Size(), file.ModTime().UnixNano())
+		if file.IsDir() && depth > 0 {
+			stampFolder(w, path, depth-1)
+		}
+	}
+}
+
 type loggerAction struct {
 	onlyIfVerbose bool
 	level         string
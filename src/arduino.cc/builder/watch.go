@@ -0,0 +1,197 @@
+/*
+ * This file is part of Arduino Builder.
+ *
+ * Arduino Builder is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
+ *
+ * As a special exception, you may use this file as part of a free software
+ * library without restriction.  Specifically, if other files instantiate
+ * templates or use macros or inline functions from this file, or you compile
+ * this file and link it with other files to produce an executable, this
+ * file does not by itself cause the resulting executable to be covered by
+ * the GNU General Public License.  This exception does not however
+ * invalidate any other reasons why the executable file might be covered by
+ * the GNU General Public License.
+ *
+ * Copyright 2015 Arduino LLC (http://www.arduino.cc/)
+ */
+
+package builder
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"arduino.cc/builder/constants"
+	"arduino.cc/builder/types"
+	"arduino.cc/builder/utils"
+)
+
+// How often watched folders are checked for changes. A build starts
+// once they haven't changed for this long, so that saving many files at
+// once doesn't start many builds
+const WATCH_INTERVAL = 500 * time.Millisecond
+
+// Builds the sketch, then builds it again every time the sketch, the
+// libraries it uses or the core change, until cancelContext is
+// canceled. Every build gets a new context with the options of ctx.
+// Previous builds make the next ones incremental.
+func RunWatch(cancelContext context.Context, ctx *types.Context) error {
+	ctx.SetCancelContext(cancelContext)
+	logger := ctx.GetLogger()
+	// Also keeps hardware and libraries loaded between builds
+	if ctx.LoaderCache == nil {
+		ctx.LoaderCache = types.NewLoaderCache()
+	}
+
+	var folders []string
+	var exclude string
+	for build := 1; ; build++ {
+		stamp := WatchStamp(folders, exclude)
+
+		logger.Println(constants.LOG_LEVEL_INFO, constants.MSG_WATCH_BUILD_STARTED, strconv.Itoa(build))
+		buildCtx := ctx.NewBuildContext()
+		start := time.Now()
+		err := RunBuilder(buildCtx)
+		if cancelContext.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			logger.Fprintln(os.Stderr, constants.LOG_LEVEL_ERROR, constants.MSG_WATCH_BUILD_FAILED, strconv.Itoa(build), err.Error())
+		} else {
+			logger.Println(constants.LOG_LEVEL_INFO, constants.MSG_WATCH_BUILD_FINISHED, strconv.Itoa(build), time.Since(start).Round(time.Millisecond).String())
+		}
+
+		// Changes made while building trigger a new build right
+		// away, unless the build found new folders to watch
+		newFolders := WatchedFolders(buildCtx)
+		if !sameStrings(folders, newFolders) || exclude != buildCtx.BuildPath {
+			folders = newFolders
+			exclude = buildCtx.BuildPath
+			stamp = WatchStamp(folders, exclude)
+		}
+
+		logger.Println(constants.LOG_LEVEL_INFO, constants.MSG_WATCH_WAITING)
+		if !WaitForChanges(cancelContext, folders, exclude, stamp) {
+			return nil
+		}
+	}
+}
+
+// The folders a build depends on: the sketch folder, the folders of the
+// imported libraries and of the core and variant. A sketch archive is
+// watched itself, instead of the folder it's unpacked to, as every build
+// unpacks it again
+func WatchedFolders(ctx *types.Context) []string {
+	folders := []string{}
+	sketchArchive := ctx.SketchArchive
+	if sketchArchive == "" && IsSketchArchive(ctx.SketchLocation) {
+		// The build failed before unpacking it
+		sketchArchive = ctx.SketchLocation
+	}
+	if sketchArchive != "" && sketchArchive != SKETCH_ARCHIVE_STDIN {
+		if archive, err := filepath.Abs(sketchArchive); err == nil {
+			folders = append(folders, archive)
+		}
+	} else if ctx.Sketch != nil {
+		folders = append(folders, filepath.Dir(ctx.Sketch.MainFile.Name))
+	} else if ctx.SketchLocation != "" {
+		sketchLocation, err := filepath.Abs(ctx.SketchLocation)
+		if err == nil {
+			if info, err := os.Stat(sketchLocation); err == nil && !info.IsDir() {
+				sketchLocation = filepath.Dir(sketchLocation)
+			}
+			folders = append(folders, sketchLocation)
+		}
+	}
+	for _, library := range ctx.ImportedLibraries {
+		folders = utils.AppendIfNotPresent(folders, library.Folder)
+	}
+	for _, key := range []string{constants.BUILD_PROPERTIES_BUILD_CORE_PATH, constants.BUILD_PROPERTIES_BUILD_VARIANT_PATH} {
+		if folder := ctx.BuildProperties[key]; folder != "" {
+			folders = utils.AppendIfNotPresent(folders, folder)
+		}
+	}
+	return folders
+}
+
+// Returns a value that changes whenever a file in folders or in their
+// subfolders changes. Hidden files, like editors' backups, and the
+// exclude folder (i.e. the build path) are ignored
+func WatchStamp(folders []string, exclude string) string {
+	hash := md5.New()
+	for _, folder := range folders {
+		filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				fmt.Fprintln(hash, path, err)
+				return nil
+			}
+			if path != folder && (path == exclude || utils.IsSCCSOrHiddenFile(info)) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			// Files being added or removed are seen anyway, the
+			// mtime of folders would also change for ignored files
+			if !info.IsDir() {
+				fmt.Fprintln(hash, path, info.Size(), info.ModTime().UnixNano())
+			}
+			return nil
+		})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Waits until the stamp of folders is no longer stamp, and then until
+// they stop changing. Returns false if cancelContext is canceled first
+func WaitForChanges(cancelContext context.Context, folders []string, exclude string, stamp string) bool {
+	ticker := time.NewTicker(WATCH_INTERVAL)
+	defer ticker.Stop()
+
+	changed := false
+	for {
+		select {
+		case <-cancelContext.Done():
+			return false
+		case <-ticker.C:
+		}
+
+		current := WatchStamp(folders, exclude)
+		if current != stamp {
+			stamp = current
+			changed = true
+		} else if changed {
+			return true
+		}
+	}
+}
+
+func sameStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
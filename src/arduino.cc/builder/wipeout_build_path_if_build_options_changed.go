@@ -33,6 +33,8 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"arduino.cc/builder/constants"
 	"arduino.cc/builder/gohasissues"
@@ -41,6 +43,18 @@ import (
 	"arduino.cc/properties"
 )
 
+// What each folder of the build path, and the include cache, is built
+// with: they are kept when board options don't change these recipes
+var buildPathRecipes = []struct {
+	name    string
+	recipes []string
+}{
+	{constants.FOLDER_CORE, []string{constants.RECIPE_C_PATTERN, constants.RECIPE_CPP_PATTERN, constants.RECIPE_S_PATTERN, constants.RECIPE_AR_PATTERN}},
+	{constants.FOLDER_LIBRARIES, []string{constants.RECIPE_C_PATTERN, constants.RECIPE_CPP_PATTERN, constants.RECIPE_S_PATTERN}},
+	{constants.FOLDER_SKETCH, []string{constants.RECIPE_C_PATTERN, constants.RECIPE_CPP_PATTERN, constants.RECIPE_S_PATTERN}},
+	{constants.FILE_INCLUDES_CACHE, []string{constants.RECIPE_PREPROC_INCLUDES}},
+}
+
 type WipeoutBuildPathIfBuildOptionsChanged struct{}
 
 func (s *WipeoutBuildPathIfBuildOptionsChanged) Run(ctx *types.Context) error {
@@ -62,11 +76,56 @@ func (s *WipeoutBuildPathIfBuildOptionsChanged) Run(ctx *types.Context) error {
 		delete(prevOpts, "sketchLocation")
 	}
 
-	if opts.Equals(prevOpts) {
-		return nil
+	// Files are compiled again when their command line changes, but
+	// the include cache doesn't know which flags were used
+	customBuildPropertiesChanged := opts["customBuildProperties"] != prevOpts["customBuildProperties"]
+	delete(opts, "customBuildProperties")
+	delete(prevOpts, "customBuildProperties")
+
+	// Libraries folders only affect which libraries are compiled
+	librariesFoldersChanged := opts["builtInLibrariesFolders"] != prevOpts["builtInLibrariesFolders"] || opts["otherLibrariesFolders"] != prevOpts["otherLibrariesFolders"]
+	delete(opts, "builtInLibrariesFolders")
+	delete(prevOpts, "builtInLibrariesFolders")
+	delete(opts, "otherLibrariesFolders")
+	delete(prevOpts, "otherLibrariesFolders")
+
+	// With the same board, menu options only affect the files built
+	// with the properties they change
+	fqbn := opts["fqbn"]
+	prevFQBN := prevOpts["fqbn"]
+	if fqbn != prevFQBN && sameBoard(fqbn, prevFQBN) {
+		delete(opts, "fqbn")
+		delete(prevOpts, "fqbn")
 	}
 
-	logger.Println(constants.LOG_LEVEL_INFO, constants.MSG_BUILD_OPTIONS_CHANGED)
+	if !opts.Equals(prevOpts) {
+		return wipeoutBuildPath(ctx)
+	}
+
+	if customBuildPropertiesChanged {
+		logger.Println(constants.LOG_LEVEL_INFO, constants.MSG_BUILD_PROPERTIES_CHANGED)
+		if err := invalidateBuildPath(ctx, constants.FILE_INCLUDES_CACHE); err != nil {
+			return i18n.WrapError(err)
+		}
+	}
+
+	if librariesFoldersChanged {
+		invalidated := []string{constants.FOLDER_LIBRARIES, constants.FILE_INCLUDES_CACHE}
+		logger.Println(constants.LOG_LEVEL_INFO, constants.MSG_LIBRARIES_FOLDERS_CHANGED, strings.Join(invalidated, ", "))
+		if err := invalidateBuildPath(ctx, invalidated...); err != nil {
+			return i18n.WrapError(err)
+		}
+	}
+
+	if fqbn != prevFQBN {
+		return invalidateBuildPathForBoardOptions(ctx, prevFQBN)
+	}
+
+	return nil
+}
+
+func wipeoutBuildPath(ctx *types.Context) error {
+	ctx.GetLogger().Println(constants.LOG_LEVEL_INFO, constants.MSG_BUILD_OPTIONS_CHANGED)
 
 	buildPath := ctx.BuildPath
 	files, err := gohasissues.ReadDir(buildPath)
@@ -74,8 +133,121 @@ func (s *WipeoutBuildPathIfBuildOptionsChanged) Run(ctx *types.Context) error {
 		return i18n.WrapError(err)
 	}
 	for _, file := range files {
+		// Held by this build
+		if file.Name() == constants.FILE_BUILD_PATH_LOCK {
+			continue
+		}
+		// Unpacked by this build, before its options are compared
+		if file.Name() == constants.FOLDER_SKETCH_ARCHIVE {
+			continue
+		}
 		os.RemoveAll(filepath.Join(buildPath, file.Name()))
 	}
 
 	return nil
 }
+
+func invalidateBuildPath(ctx *types.Context, names ...string) error {
+	for _, name := range names {
+		err := os.RemoveAll(filepath.Join(ctx.BuildPath, name))
+		if err != nil {
+			return i18n.WrapError(err)
+		}
+	}
+	return nil
+}
+
+func sameBoard(fqbn string, anotherFQBN string) bool {
+	fqbnParts := strings.Split(fqbn, ":")
+	anotherFQBNParts := strings.Split(anotherFQBN, ":")
+	if len(fqbnParts) < 3 || len(anotherFQBNParts) < 3 {
+		return false
+	}
+	return strings.Join(fqbnParts[:3], ":") == strings.Join(anotherFQBNParts[:3], ":")
+}
+
+// Compares the recipes the build path was built with, using the menu
+// options of prevFQBN, with the current ones
+func invalidateBuildPathForBoardOptions(ctx *types.Context, prevFQBN string) error {
+	logger := ctx.GetLogger()
+	if ctx.TargetPlatform == nil || ctx.TargetBoard == nil || ctx.BuildProperties == nil {
+		return wipeoutBuildPath(ctx)
+	}
+	board := ctx.TargetPlatform.Boards[ctx.TargetBoard.BoardId]
+	if board == nil {
+		return wipeoutBuildPath(ctx)
+	}
+
+	prevBoard := &types.Board{BoardId: board.BoardId, Properties: board.Properties.Clone()}
+	if prevFQBNParts := strings.Split(prevFQBN, ":"); len(prevFQBNParts) > 3 {
+		addAdditionalPropertiesToTargetBoard(prevBoard, prevFQBNParts[3])
+	}
+	changedKeys := changedProperties(prevBoard.Properties, ctx.TargetBoard.Properties)
+	if len(changedKeys) == 0 {
+		return nil
+	}
+	for _, key := range changedKeys {
+		// The sources themselves are different
+		if key == constants.BUILD_PROPERTIES_BUILD_CORE || key == constants.BUILD_PROPERTIES_BUILD_VARIANT {
+			return wipeoutBuildPath(ctx)
+		}
+	}
+
+	customBuildProperties, err := properties.LoadFromSlice(ctx.CustomBuildProperties, logger)
+	if err != nil {
+		return i18n.WrapError(err)
+	}
+	platformProperties := make(properties.Map)
+	if ctx.ActualPlatform != nil {
+		platformProperties.Merge(ctx.ActualPlatform.Properties)
+	}
+	platformProperties.Merge(ctx.TargetPlatform.Properties)
+
+	prevBuildProperties := ctx.BuildProperties.Clone()
+	for _, key := range changedKeys {
+		if _, ok := customBuildProperties[key]; ok {
+			continue
+		}
+		if value, ok := prevBoard.Properties[key]; ok {
+			prevBuildProperties[key] = value
+		} else if value, ok := platformProperties[key]; ok {
+			prevBuildProperties[key] = value
+		} else {
+			delete(prevBuildProperties, key)
+		}
+	}
+
+	var invalidated []string
+	for _, buildPathRecipe := range buildPathRecipes {
+		for _, recipe := range buildPathRecipe.recipes {
+			if prevBuildProperties.ExpandPropsInString(prevBuildProperties[recipe]) != ctx.BuildProperties.ExpandPropsInString(ctx.BuildProperties[recipe]) {
+				invalidated = append(invalidated, buildPathRecipe.name)
+				break
+			}
+		}
+	}
+
+	if len(invalidated) == 0 {
+		logger.Println(constants.LOG_LEVEL_INFO, constants.MSG_BOARD_OPTIONS_CHANGED_NOTHING_TO_REBUILD, strings.Join(changedKeys, ", "))
+		return nil
+	}
+	logger.Println(constants.LOG_LEVEL_INFO, constants.MSG_BOARD_OPTIONS_CHANGED, strings.Join(changedKeys, ", "), strings.Join(invalidated, ", "))
+	return invalidateBuildPath(ctx, invalidated...)
+}
+
+// The sorted keys whose value differs between the two maps
+func changedProperties(aMap properties.Map, anotherMap properties.Map) []string {
+	var keys []string
+	for key, value := range aMap {
+		if anotherValue, ok := anotherMap[key]; !ok || anotherValue != value {
+			keys = append(keys, key)
+		}
+	}
+	for key := range anotherMap {
+		if _, ok := aMap[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}